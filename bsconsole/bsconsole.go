@@ -68,6 +68,9 @@ func usage() {
 	fmt.Fprintf(stderr, "    state       show node state\n")
 	fmt.Fprintf(stderr, "        %v state 'x->y'\n", fn)
 	fmt.Fprintf(stderr, "\n")
+	fmt.Fprintf(stderr, "    dig         lookup host on uri node\n")
+	fmt.Fprintf(stderr, "        %v dig 'x->y' example.com [A|AAAA|CNAME|MX|NS|TXT|PTR]\n", fn)
+	fmt.Fprintf(stderr, "\n")
 	fmt.Fprintf(stderr, "    shell       start shell which forwaring conn to uri\n")
 	fmt.Fprintf(stderr, "        %v shell 'x->y' http_proxy,https_proxy bash\n", fn)
 	fmt.Fprintf(stderr, "\n")
@@ -80,6 +83,9 @@ func usage() {
 	fmt.Fprintf(stderr, "    sftp        start sftp to uri\n")
 	fmt.Fprintf(stderr, "        %v sftp 'x->y' root@bshost:/tmp/xx\n", fn)
 	fmt.Fprintf(stderr, "\n")
+	fmt.Fprintf(stderr, "    keygen      generate key pair for e2e_key/e2e_peers\n")
+	fmt.Fprintf(stderr, "        %v keygen\n", fn)
+	fmt.Fprintf(stderr, "\n")
 }
 
 func main() {
@@ -190,6 +196,15 @@ func runall(osArgs ...string) {
 	case "version":
 		fmt.Println(Version)
 		return
+	case "keygen":
+		private, public, err := bsck.GenerateE2EKey()
+		if err != nil {
+			fmt.Fprintf(stderr, "generate key fail with %v\n", err)
+			exit(1)
+			return
+		}
+		fmt.Fprintf(stdout, "private: %v\npublic: %v\n", private, public)
+		return
 	case "help":
 		usage()
 		exit(1)
@@ -344,6 +359,21 @@ func runall(osArgs ...string) {
 			fmt.Printf("Print state done with %v\n", err)
 			exit(1)
 		}
+	case "dig":
+		if len(args) < 2 {
+			usage()
+			exit(1)
+			return
+		}
+		var qtype string
+		if len(args) > 2 {
+			qtype = args[2]
+		}
+		err = console.Dig(strings.Trim(args[0], "'\""), args[1], qtype)
+		if err != nil {
+			fmt.Printf("Dig done with %v\n", err)
+			exit(1)
+		}
 	case "shell":
 		if len(args) < 3 {
 			fmt.Fprintf(stderr, "key/runner is not setting\n")
@@ -204,6 +204,24 @@ func TestConsole(t *testing.T) {
 		exit = func(int) {}
 		runall("bsconsole", "state", "tcp://127.0.0.1:0", "3")
 	}
+	{ //dig
+		stdin, stdout, stderr = os.Stdin, os.Stdout, os.Stderr
+		exit = func(int) {
+			t.Error("exit")
+		}
+		runall("bsconsole", "dig", "", "localhost")
+		runall("bsconsole", "dig", "master", "localhost", "A")
+		//
+		//error
+		exited := 0
+		exit = func(int) { exited++ }
+		runall("bsconsole", "dig", "master")
+		runall("bsconsole", "dig", "master", "localhost", "XX")
+		runall("bsconsole", "dig", "master", "none.invalid")
+		if exited != 3 {
+			t.Error(exited)
+		}
+	}
 	{ //shell
 		stdin, stdout, stderr = os.Stdin, os.Stdout, os.Stderr
 		stdoutReader, stdout, _ = os.Pipe()
@@ -293,6 +311,7 @@ func TestConsole(t *testing.T) {
 	{ //other
 		exit = func(int) {}
 		runall("bsconsole", "version")
+		runall("bsconsole", "keygen")
 		runall("bsconsole", "help")
 		runall("bsconsole")
 	}
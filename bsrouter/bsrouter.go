@@ -5,6 +5,8 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
+	"strconv"
+	"syscall"
 
 	"github.com/codingeasygo/bsck"
 )
@@ -21,10 +23,23 @@ func main() {
 		fmt.Println(Version)
 		os.Exit(0)
 	}
+	if len(os.Args) > 2 && os.Args[1] == "upgrade" {
+		pid, err := strconv.Atoi(os.Args[2])
+		if err == nil {
+			err = sendUpgrade(pid)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "upgrade %v fail with %v\n", os.Args[2], err)
+			exitf(1)
+		}
+		exitf(0)
+		return
+	}
 	if len(os.Args) > 1 && os.Args[1] == "-h" {
 		fmt.Fprintf(os.Stderr, "Bond Socket Router Version %v\n", Version)
 		fmt.Fprintf(os.Stderr, "Usage:  %v configure\n", "bsrouter")
 		fmt.Fprintf(os.Stderr, "        %v /etc/bsrouter.json'\n", "bsrouter")
+		fmt.Fprintf(os.Stderr, "        %v upgrade <pid>, start new binary by inherited listeners and drain old process\n", "bsrouter")
 		fmt.Fprintf(os.Stderr, "bsrouter options:\n")
 		fmt.Fprintf(os.Stderr, "        name\n")
 		fmt.Fprintf(os.Stderr, "             the router name\n")
@@ -34,12 +49,16 @@ func main() {
 		fmt.Fprintf(os.Stderr, "             the forward uri by 'listen address':'uri'\n")
 		fmt.Fprintf(os.Stderr, "        showlog\n")
 		fmt.Fprintf(os.Stderr, "             the log level\n")
+		fmt.Fprintf(os.Stderr, "        drain\n")
+		fmt.Fprintf(os.Stderr, "             the max milliseconds to wait sessions done when receive SIGTERM\n")
 		fmt.Fprintf(os.Stderr, "        channels\n")
 		fmt.Fprintf(os.Stderr, "             the channel configure\n")
 		fmt.Fprintf(os.Stderr, "        channels.local\n")
 		fmt.Fprintf(os.Stderr, "             the binded local address connect to master\n")
 		fmt.Fprintf(os.Stderr, "        channels.remote\n")
 		fmt.Fprintf(os.Stderr, "             the master address\n")
+		fmt.Fprintf(os.Stderr, "        channels.stripe\n")
+		fmt.Fprintf(os.Stderr, "             stripe session frames on all channels with same name by 1\n")
 		os.Exit(1)
 	}
 	var configPath string
@@ -64,7 +83,34 @@ func main() {
 	service.ConfigPath = configPath
 	err = service.Start()
 	wc := make(chan os.Signal, 1)
-	signal.Notify(wc, os.Interrupt, os.Kill)
-	<-wc
+	signals := []os.Signal{os.Interrupt, os.Kill, syscall.SIGTERM}
+	if upgradeSignal != nil {
+		signals = append(signals, upgradeSignal)
+	}
+	signal.Notify(wc, signals...)
+	for {
+		sig := <-wc
+		if sig == upgradeSignal && upgradeSignal != nil {
+			executable, _ := os.Executable()
+			_, err = service.Upgrade(executable, os.Args[1:]...)
+			if err != nil {
+				bsck.ErrorLog("bsrouter upgrade by %v fail with %v", executable, err)
+				continue
+			}
+		}
+		if sig == syscall.SIGTERM || sig == upgradeSignal {
+			bsck.InfoLog("bsrouter receive %v, will drain and close hard on next signal", sig)
+			drained := make(chan int, 1)
+			go func() {
+				service.Drain()
+				drained <- 1
+			}()
+			select {
+			case <-drained:
+			case <-wc:
+			}
+		}
+		break
+	}
 	service.Stop()
 }
@@ -0,0 +1,19 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+//upgradeSignal is the signal to upgrade bsrouter by new binary
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+func sendUpgrade(pid int) (err error) {
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = process.Signal(syscall.SIGUSR2)
+	}
+	return
+}
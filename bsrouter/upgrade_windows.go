@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+//upgradeSignal is not supported on windows
+var upgradeSignal os.Signal
+
+func sendUpgrade(pid int) (err error) {
+	err = fmt.Errorf("upgrade is not supported on windows")
+	return
+}
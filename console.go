@@ -3,6 +3,7 @@ package bsck
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -223,16 +224,26 @@ func (c *Console) PrintState(uri, query string) (err error) {
 	fmt.Printf("[Channels]\n")
 	channels := state.Map("channels")
 	for name := range channels {
-		fmt.Printf(" ->%v\n", name)
 		bond := channels.Map(name)
+		fmt.Printf(" ->%v(%v)\n", name, bond.StrDef(SelectUsed, "strategy"))
 		for idx := range bond {
+			if !strings.HasPrefix(idx, "_") {
+				continue
+			}
 			val := bond.Map(idx)
 			idxVal, _ := strconv.ParseInt(strings.Replace(idx, "_", "", -1), 10, 64)
 			heartbeat := val.Int64Def(0, "heartbeat")
 			hs := time.Unix(0, heartbeat*1e6).Format("2006-01-02 15:04:05")
-			fmt.Printf("   %d % 4d   %v   %v\n", idxVal, int(val["used"].(float64)), hs, val["connect"])
+			capabilities := strings.Join(val.ArrayStrDef(nil, "capabilities"), ",")
+			fmt.Printf("   %d % 4d % 4d % 6dms   %v   v%v(%v)   %v\n", idxVal, val.Int64Def(0, "used"), val.Int64Def(0, "active"), val.Int64Def(0, "rtt"), hs, val.IntDef(1, "version"), capabilities, val["connect"])
 		}
 	}
+	fmt.Printf("\n\n[Reconnect]\n")
+	reconnect := state.Map("reconnect")
+	for key := range reconnect {
+		val := reconnect.Map(key)
+		fmt.Printf(" %v % 4d % 4d % 6dms   %v\n", key, val.Int64Def(0, "attempts"), val.Int64Def(0, "total"), val.Int64Def(0, "delay"), val.StrDef("", "error"))
+	}
 	fmt.Printf("\n\n[Table]\n")
 	table := state.ArrayStrDef(nil, "table")
 	for _, t := range table {
@@ -242,6 +253,35 @@ func (c *Console) PrintState(uri, query string) (err error) {
 	return
 }
 
+//Dig will lookup host by dns dialer on uri and print the records
+func (c *Console) Dig(uri, host, qtype string) (err error) {
+	if len(uri) > 0 {
+		uri += "->"
+	}
+	uri += "dns://" + host
+	if len(qtype) > 0 {
+		uri += "?type=" + qtype
+	}
+	conn, err := c.Dial(uri)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return
+	}
+	result := &dialer.DNSResult{}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return
+	}
+	for _, record := range result.Records {
+		fmt.Printf("%v\t%v\t%v\n", result.Host, result.Type, record)
+	}
+	return
+}
+
 //DialPiper will dial uri on router and return piper
 func (c *Console) DialPiper(uri string, bufferSize int) (raw xio.Piper, err error) {
 	piper := NewWaitedPiper()
@@ -346,9 +386,25 @@ func (c *Console) ProxyProcess(uri string, stdin, stdout, stderr *os.File, prepa
 	return
 }
 
+//splitSSHKey will split the private key and the certificate after it
+func splitSSHKey(data []byte) (key, cert []byte) {
+	key = data
+	index := bytes.Index(data, []byte("-----END"))
+	if index < 0 {
+		return
+	}
+	end := bytes.IndexByte(data[index:], '\n')
+	if end < 0 {
+		return
+	}
+	key, cert = data[:index+end+1], bytes.TrimSpace(data[index+end+1:])
+	return
+}
+
 //ProxySSH will start ssh client command and connect to uri by proxy command.
 //
-//it will try load the ssh key from slaver forwarding by bs-ssh-key, bs-ssh-key is forwarding to http server and return ssh key in body by uri argument.
+//it will try load the ssh key from slaver forwarding by bs-ssh-key, bs-ssh-key is forwarding to http server and return ssh key in body by uri argument,
+//the certificate after the key in body is saved to key-cert.pub which is loaded by ssh.
 //
 func (c *Console) ProxySSH(uri string, stdin io.Reader, stdout, stderr io.Writer, proxyCommand, command string, args ...string) (err error) {
 	replaceURI := uri
@@ -378,6 +434,12 @@ func (c *Console) ProxySSH(uri string, stdin io.Reader, stdout, stderr io.Writer
 			return
 		}
 		defer os.Remove(tempFile.Name())
+		sshKey, sshCert := splitSSHKey(sshKey)
+		if len(sshCert) > 0 {
+			certFile := tempFile.Name() + "-cert.pub"
+			ioutil.WriteFile(certFile, sshCert, 0600)
+			defer os.Remove(certFile)
+		}
 		tempFile.Write(sshKey)
 		tempFile.Close()
 		allArgs = append(allArgs, "-i", tempFile.Name())
@@ -1,11 +1,15 @@
 package dialer
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
+	"os"
 	"regexp"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/codingeasygo/util/converter"
@@ -58,13 +62,21 @@ type BalancedDialer struct {
 	dialers         map[string]Dialer
 	dialersUsed     map[string][]int64            //map key to [begin,used,fail]
 	dialersHostUsed map[string]map[string][]int64 //map key/host to [begin,used,fail]
-	dialersLock     chan int
+	dialersRemoved  map[string]Dialer             //removed dialer by fail_remove
+	dialersLock     sync.Mutex
+	waiting         []*balancedWaiter          //the dial which is waiting available dialer
+	dialing         map[uint64]*balancedWaiter //all dial which is running by sid
+	timer           *time.Timer                //the timer to schedule on next limit window reset
 	PolicyList      []*BalancedPolicy
 	Filters         []*BalancedFilter
 	Delay           int64
 	Timeout         int64
+	Snapshot        string //the file to save counters
+	SnapshotDelay   int64
 	Conf            xmap.M
 	matcher         *regexp.Regexp
+	exiter          chan int
+	waiter          sync.WaitGroup
 }
 
 func NewBalancedDialer() *BalancedDialer {
@@ -72,22 +84,20 @@ func NewBalancedDialer() *BalancedDialer {
 		dialers:         map[string]Dialer{},
 		dialersUsed:     map[string][]int64{},
 		dialersHostUsed: map[string]map[string][]int64{},
-		dialersLock:     make(chan int, 1),
+		dialersRemoved:  map[string]Dialer{},
+		dialersLock:     sync.Mutex{},
+		dialing:         map[uint64]*balancedWaiter{},
 		Delay:           500,
 		Timeout:         3000,
+		SnapshotDelay:   10000,
 		Conf:            xmap.M{},
 		matcher:         regexp.MustCompile(".*"),
+		exiter:          make(chan int, 1),
+		waiter:          sync.WaitGroup{},
 	}
-	dialer.dialersLock <- 1
 	return dialer
 }
 
-func (b *BalancedDialer) sortedDialer(index int) []string {
-	sorter := NewMapIntSorter(b.dialersUsed, index)
-	sort.Sort(sorter)
-	return sorter.List
-}
-
 func (b *BalancedDialer) AddPolicy(matcher string, limit []int64) (err error) {
 	if len(limit) < 2 {
 		err = fmt.Errorf("limit must be [time,limit]")
@@ -115,14 +125,15 @@ func (b *BalancedDialer) AddFilter(matcher string, access int) (err error) {
 }
 
 func (b *BalancedDialer) AddDialer(dialers ...Dialer) {
-	<-b.dialersLock
+	b.dialersLock.Lock()
 	for _, dialer := range dialers {
 		name := dialer.Name()
 		b.dialers[name] = dialer
 		b.dialersUsed[name] = []int64{0, 0, 0}
 		b.dialersHostUsed[name] = map[string][]int64{}
 	}
-	b.dialersLock <- 1
+	b.schedule()
+	b.dialersLock.Unlock()
 	return
 }
 
@@ -158,10 +169,8 @@ func (b *BalancedDialer) Bootstrap(options xmap.M) (err error) {
 			return
 		}
 	}
-	<-b.dialersLock
-	defer func() {
-		b.dialersLock <- 1
-	}()
+	b.dialersLock.Lock()
+	defer b.dialersLock.Unlock()
 	dialerOptions := options.ArrayMapDef(nil, "dialers")
 	for _, option := range dialerOptions {
 		dtype := option.Str("type")
@@ -179,9 +188,176 @@ func (b *BalancedDialer) Bootstrap(options xmap.M) (err error) {
 		b.dialersHostUsed[name] = map[string][]int64{}
 		DebugLog("BalancedDialer add dialer(%v) to pool success", dialer)
 	}
+	b.Snapshot = options.Str("snapshot")
+	b.SnapshotDelay = options.Int64Def(b.SnapshotDelay, "snapshot_delay")
+	if len(b.Snapshot) > 0 {
+		err = b.loadSnapshot()
+		if err != nil {
+			return
+		}
+		b.waiter.Add(1)
+		go b.runSnapshot()
+	}
 	return nil
 }
 
+type balancedSnapshot struct {
+	Used    map[string][]int64            `json:"used"`
+	Hosts   map[string]map[string][]int64 `json:"hosts"`
+	Removed []string                      `json:"removed"`
+}
+
+//loadSnapshot will load counters and removed status from snapshot file, it must be called with dialersLock
+func (b *BalancedDialer) loadSnapshot() (err error) {
+	data, err := ioutil.ReadFile(b.Snapshot)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	snapshot := &balancedSnapshot{}
+	err = json.Unmarshal(data, snapshot)
+	if err != nil {
+		err = fmt.Errorf("load snapshot %v fail with %v", b.Snapshot, err)
+		return
+	}
+	for name, used := range snapshot.Used {
+		if _, ok := b.dialers[name]; ok && len(used) == 3 {
+			b.dialersUsed[name] = used
+		}
+	}
+	for name, hosts := range snapshot.Hosts {
+		if _, ok := b.dialers[name]; ok && hosts != nil {
+			b.dialersHostUsed[name] = hosts
+		}
+	}
+	for _, name := range snapshot.Removed {
+		if dialer, ok := b.dialers[name]; ok {
+			b.remove(name, dialer)
+		}
+	}
+	InfoLog("BalancedDialer(%v) load snapshot from %v success", b.ID, b.Snapshot)
+	return
+}
+
+//saveSnapshot will save counters and removed status to snapshot file
+func (b *BalancedDialer) saveSnapshot() (err error) {
+	snapshot := &balancedSnapshot{
+		Used:  map[string][]int64{},
+		Hosts: map[string]map[string][]int64{},
+	}
+	b.dialersLock.Lock()
+	for name, used := range b.dialersUsed {
+		snapshot.Used[name] = append([]int64{}, used...)
+	}
+	for name, hosts := range b.dialersHostUsed {
+		snapshot.Hosts[name] = map[string][]int64{}
+		for host, used := range hosts {
+			snapshot.Hosts[name][host] = append([]int64{}, used...)
+		}
+	}
+	for name := range b.dialersRemoved {
+		snapshot.Removed = append(snapshot.Removed, name)
+	}
+	b.dialersLock.Unlock()
+	data, _ := json.Marshal(snapshot)
+	err = ioutil.WriteFile(b.Snapshot+".tmp", data, 0600)
+	if err == nil {
+		err = os.Rename(b.Snapshot+".tmp", b.Snapshot)
+	}
+	return
+}
+
+func (b *BalancedDialer) runSnapshot() {
+	defer b.waiter.Done()
+	ticker := time.NewTicker(time.Duration(b.SnapshotDelay) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.exiter:
+			return
+		case <-ticker.C:
+			if err := b.saveSnapshot(); err != nil {
+				WarnLog("BalancedDialer(%v) save snapshot to %v fail with %v", b.ID, b.Snapshot, err)
+			}
+		}
+	}
+}
+
+//remove will move the dialer to removed, it must be called with dialersLock
+func (b *BalancedDialer) remove(name string, dialer Dialer) {
+	delete(b.dialers, name)
+	delete(b.dialersUsed, name)
+	delete(b.dialersHostUsed, name)
+	b.dialersRemoved[name] = dialer
+}
+
+//Restore will add the removed dialer back to pool by name, all removed dialer is restored when names is empty
+func (b *BalancedDialer) Restore(names ...string) (restored []string) {
+	b.dialersLock.Lock()
+	defer b.dialersLock.Unlock()
+	if len(names) < 1 {
+		for name := range b.dialersRemoved {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		dialer, ok := b.dialersRemoved[name]
+		if !ok {
+			continue
+		}
+		delete(b.dialersRemoved, name)
+		b.dialers[name] = dialer
+		b.dialersUsed[name] = []int64{0, 0, 0}
+		b.dialersHostUsed[name] = map[string][]int64{}
+		restored = append(restored, name)
+		InfoLog("BalancedDialer(%v) restore dialer(%v) to pool", b.ID, dialer)
+	}
+	b.schedule()
+	sort.Strings(restored)
+	return
+}
+
+//State will return the counters, limits and removed status of all sub dialer
+func (b *BalancedDialer) State(args ...interface{}) (state xmap.M) {
+	counter := func(used []int64) xmap.M {
+		return xmap.M{"begin": used[0], "used": used[1], "fail": used[2]}
+	}
+	dialers := xmap.M{}
+	b.dialersLock.Lock()
+	for name, dialer := range b.dialers {
+		hosts := xmap.M{}
+		for host, used := range b.dialersHostUsed[name] {
+			hosts[host] = counter(used)
+		}
+		info := counter(b.dialersUsed[name])
+		info["hosts"] = hosts
+		info["limit"] = dialer.Options().ArrayInt64Def(nil, "limit")
+		info["fail_remove"] = dialer.Options().Int64Def(0, "fail_remove")
+		info["removed"] = false
+		dialers[name] = info
+	}
+	for name, dialer := range b.dialersRemoved {
+		dialers[name] = xmap.M{
+			"limit":       dialer.Options().ArrayInt64Def(nil, "limit"),
+			"fail_remove": dialer.Options().Int64Def(0, "fail_remove"),
+			"removed":     true,
+		}
+	}
+	waiting := len(b.waiting)
+	b.dialersLock.Unlock()
+	state = xmap.M{
+		"id":      b.ID,
+		"timeout": b.Timeout,
+		"delay":   b.Delay,
+		"waiting": waiting,
+		"dialers": dialers,
+	}
+	return
+}
+
 //Options
 func (b *BalancedDialer) Options() xmap.M {
 	return b.Conf
@@ -192,6 +368,230 @@ func (b *BalancedDialer) Matched(uri string) bool {
 	return b.matcher.MatchString(uri)
 }
 
+//balancedWaiter is one dial which is waiting available dialer
+type balancedWaiter struct {
+	sid      uint64
+	uri      string
+	host     string
+	failed   map[string]int
+	ready    chan string
+	cancel   chan error
+	done     bool
+	reserved [2]int64 //the window begin of dialer and host used when dialer is reserved
+}
+
+//fairWaiting will return the waiting dial by interleaving host, so the host having many dial is not starving others
+func (b *BalancedDialer) fairWaiting() (waiting []*balancedWaiter) {
+	var hosts []string
+	queues := map[string][]*balancedWaiter{}
+	for _, waiter := range b.waiting {
+		if _, ok := queues[waiter.host]; !ok {
+			hosts = append(hosts, waiter.host)
+		}
+		queues[waiter.host] = append(queues[waiter.host], waiter)
+	}
+	for len(waiting) < len(b.waiting) {
+		for _, host := range hosts {
+			if queue := queues[host]; len(queue) > 0 {
+				waiting = append(waiting, queue[0])
+				queues[host] = queue[1:]
+			}
+		}
+	}
+	return
+}
+
+//limited will check the [begin,used,fail] is limited by [time,limit], the next is the time of limit window reset
+func limited(used, limit []int64, now int64) (next int64, ok bool) {
+	if len(limit) < 2 {
+		return 0, false
+	}
+	if now-used[0] > limit[0] {
+		used[1] = 0
+	}
+	if used[1] < limit[1] {
+		return 0, false
+	}
+	return used[0] + limit[0] + 1, true
+}
+
+//policy will return the first policy which is matched to uri
+func (b *BalancedDialer) policy(uri string) *BalancedPolicy {
+	for _, p := range b.PolicyList {
+		if p.Matcher.MatchString(uri) {
+			return p
+		}
+	}
+	return nil
+}
+
+//limitedDialer will check the dialer is limited by dialer limit or host policy for waiter, it must be called with dialersLock
+func (b *BalancedDialer) limitedDialer(waiter *balancedWaiter, policy *BalancedPolicy, name string, dialer Dialer, now int64) (next int64, ok bool) {
+	next, ok = limited(b.dialersUsed[name], dialer.Options().ArrayInt64Def(nil, "limit"), now)
+	if !ok && policy != nil {
+		hostUsed := b.dialersHostUsed[name][waiter.host]
+		if hostUsed == nil {
+			hostUsed = []int64{0, 0, 0}
+			b.dialersHostUsed[name][waiter.host] = hostUsed
+		}
+		next, ok = limited(hostUsed, policy.Limit, now)
+	}
+	return
+}
+
+//selectDialer will select the dialer having least used by weight for waiter, the next is the time of the first limit window reset when all dialer is limited.
+//it must be called with dialersLock
+func (b *BalancedDialer) selectDialer(waiter *balancedWaiter, now int64) (name string, next int64) {
+	policy := b.policy(waiter.uri)
+	var selected float64
+	for current, dialer := range b.dialers {
+		if waiter.failed[current] > 2 || !dialer.Matched(waiter.uri) {
+			continue
+		}
+		used := b.dialersUsed[current]
+		reset, ok := b.limitedDialer(waiter, policy, current, dialer, now)
+		if ok {
+			if next == 0 || reset < next {
+				next = reset
+			}
+			continue
+		}
+		weight := dialer.Options().Float64Def(1, "weight")
+		if weight <= 0 {
+			weight = 1
+		}
+		score := float64(used[1]) / weight
+		if len(name) < 1 || score < selected || (score == selected && current < name) {
+			name, selected = current, score
+		}
+	}
+	return
+}
+
+//reserve will increase the used count of dialer and host for waiter, it must be called with dialersLock
+func (b *BalancedDialer) reserve(waiter *balancedWaiter, name string, now int64) {
+	used := b.dialersUsed[name]
+	hostUsed := b.dialersHostUsed[name][waiter.host]
+	if hostUsed == nil {
+		hostUsed = []int64{0, 0, 0}
+		b.dialersHostUsed[name][waiter.host] = hostUsed
+	}
+	if used[1] == 0 {
+		used[0] = now
+	}
+	if hostUsed[1] == 0 {
+		hostUsed[0] = now
+	}
+	used[1]++
+	hostUsed[1]++
+	waiter.reserved = [2]int64{used[0], hostUsed[0]}
+}
+
+//confirm will re-check the dialer reserved by schedule is still not limited when waiter is woken,
+//the reservation is moved to now, so the limit window is begun at real dial time. it must be called with dialersLock
+func (b *BalancedDialer) confirm(waiter *balancedWaiter, name string, now int64) (dialer Dialer, ok bool) {
+	b.release(waiter, name)
+	dialer = b.dialers[name]
+	if dialer == nil {
+		return
+	}
+	if _, limited := b.limitedDialer(waiter, b.policy(waiter.uri), name, dialer, now); limited {
+		return
+	}
+	b.reserve(waiter, name, now)
+	ok = true
+	return
+}
+
+//release will decrease the used count of dialer and host which is reserved by waiter but not dialed,
+//the count is not decreased when the limit window is reset after reserved, because the count of new window is used by others.
+//it must be called with dialersLock
+func (b *BalancedDialer) release(waiter *balancedWaiter, name string) {
+	if used := b.dialersUsed[name]; used != nil && used[1] > 0 && used[0] == waiter.reserved[0] {
+		used[1]--
+	}
+	if hostUsed := b.dialersHostUsed[name][waiter.host]; hostUsed != nil && hostUsed[1] > 0 && hostUsed[0] == waiter.reserved[1] {
+		hostUsed[1]--
+	}
+}
+
+//schedule will wake the waiting dial which having available dialer in fair order,
+//and start timer to schedule again on next limit window reset. it must be called with dialersLock
+func (b *BalancedDialer) schedule() {
+	now := xtime.Now()
+	var next int64
+	waiting := b.fairWaiting()
+	b.waiting = nil
+	for _, waiter := range waiting {
+		name, reset := b.selectDialer(waiter, now)
+		if len(name) > 0 {
+			b.reserve(waiter, name, now)
+			waiter.ready <- name
+			continue
+		}
+		b.waiting = append(b.waiting, waiter)
+		if reset > 0 && (next == 0 || reset < next) {
+			next = reset
+		}
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if next > 0 && len(b.waiting) > 0 {
+		b.timer = time.AfterFunc(time.Duration(next-now)*time.Millisecond, func() {
+			b.dialersLock.Lock()
+			b.schedule()
+			b.dialersLock.Unlock()
+		})
+	}
+}
+
+//enqueue will add waiter to waiting queue and schedule it, it must be called with dialersLock
+func (b *BalancedDialer) enqueue(waiter *balancedWaiter) {
+	if waiter.done {
+		return
+	}
+	b.waiting = append(b.waiting, waiter)
+	b.schedule()
+}
+
+//dequeue will remove waiter from waiting queue and release the dialer which is reserved but not received, it must be called with dialersLock
+func (b *BalancedDialer) dequeue(waiter *balancedWaiter) {
+	waiter.done = true
+	if b.dialing[waiter.sid] == waiter {
+		delete(b.dialing, waiter.sid)
+	}
+	for i, w := range b.waiting {
+		if w == waiter {
+			b.waiting = append(b.waiting[:i], b.waiting[i+1:]...)
+			break
+		}
+	}
+	select {
+	case name := <-waiter.ready:
+		b.release(waiter, name)
+	default:
+	}
+}
+
+//Cancel will cancel the dial which is waiting available dialer by sid
+func (b *BalancedDialer) Cancel(sid uint64) (canceled bool) {
+	b.dialersLock.Lock()
+	defer b.dialersLock.Unlock()
+	waiter := b.dialing[sid]
+	if waiter == nil {
+		return
+	}
+	select {
+	case waiter.cancel <- fmt.Errorf("dial to %v is canceled", waiter.uri):
+		canceled = true
+	default:
+	}
+	return
+}
+
+//Dial will queue the dial and wait available dialer by limit/policy, the waiting dial is woken when limit window reset or dialer is added/restored
 func (b *BalancedDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
 	for _, f := range b.Filters {
 		if f.Matcher.MatchString(uri) {
@@ -206,122 +606,102 @@ func (b *BalancedDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (
 	if err != nil {
 		return
 	}
-	//
-	begin := xtime.Now()
-	var showed int64
-	failed := map[string]int{}
+	waiter := &balancedWaiter{
+		sid:    sid,
+		uri:    uri,
+		host:   target.Host,
+		failed: map[string]int{},
+		ready:  make(chan string, 1),
+		cancel: make(chan error, 1),
+	}
+	timeout := time.NewTimer(time.Duration(b.Timeout) * time.Millisecond)
+	defer timeout.Stop()
+	b.dialersLock.Lock()
+	b.dialing[sid] = waiter
+	b.enqueue(waiter)
+	b.dialersLock.Unlock()
 	for {
-		now := xtime.Now()
-		if now-begin >= b.Timeout {
-			err = fmt.Errorf("dial to %v timeout", uri)
-			break
-		}
-		<-b.dialersLock
-		//do dialer limit
-		sortedNames := b.sortedDialer(1)
-		var limitedNames []string
-		now = xtime.Now()
-		for _, name := range sortedNames {
-			if failed[name] > 2 {
+		select {
+		case name := <-waiter.ready:
+			b.dialersLock.Lock()
+			dialer, ok := b.confirm(waiter, name, xtime.Now())
+			if !ok {
+				//the dialer is removed or limited again after woken, wait next schedule
+				b.enqueue(waiter)
+				b.dialersLock.Unlock()
 				continue
 			}
-			dialer := b.dialers[name]
-			used := b.dialersUsed[name]
-			limit := dialer.Options().ArrayInt64Def(nil, "limit")
-			if len(limit) < 2 {
-				limitedNames = append(limitedNames, name)
-				used[1] = 0
-				continue
-			}
-			if now-used[0] > limit[0] {
-				limitedNames = append(limitedNames, name)
-				used[1] = 0
-			}
-			if used[1] < limit[1] {
-				limitedNames = append(limitedNames, name)
-			}
-		}
-		//do host policy
-		var policy *BalancedPolicy
-		for _, p := range b.PolicyList {
-			if p.Matcher.MatchString(uri) {
-				policy = p
-				break
-			}
-		}
-		var policyNames []string
-		if policy == nil {
-			policyNames = limitedNames
-		} else {
-			for _, name := range limitedNames {
-				allHostUsed := b.dialersHostUsed[name]
-				used := allHostUsed[target.Host]
-				if used == nil {
-					used = []int64{0, 0, 0}
-					allHostUsed[target.Host] = used
-				}
-				if now-used[0] > policy.Limit[0] {
-					policyNames = append(policyNames, name)
-					used[1] = 0
-				}
-				if used[1] < policy.Limit[1] {
-					policyNames = append(policyNames, name)
-				}
-			}
-		}
-		for _, name := range policyNames {
-			dialer := b.dialers[name]
-			if !dialer.Matched(uri) {
-				continue
-			}
-			used := b.dialersUsed[name]
-			hostUsed := b.dialersHostUsed[name][target.Host]
-			if hostUsed == nil {
-				hostUsed = []int64{0, 0, 0}
-				b.dialersHostUsed[name][target.Host] = hostUsed
-			}
-			if used[1] == 0 {
-				used[0] = xtime.Now()
-			}
-			if hostUsed[1] == 0 {
-				hostUsed[0] = xtime.Now()
-			}
-			used[1]++
-			hostUsed[1]++
-			b.dialersLock <- 1
+			b.dialersLock.Unlock()
 			r, err = dialer.Dial(sid, uri, pipe)
-			<-b.dialersLock
+			b.dialersLock.Lock()
 			if err == nil {
-				used[2] = 0
-				hostUsed[2] = 0
-				b.dialersLock <- 1
+				b.dequeue(waiter)
+				if used := b.dialersUsed[name]; used != nil {
+					used[2] = 0
+				}
+				if hostUsed := b.dialersHostUsed[name][waiter.host]; hostUsed != nil {
+					hostUsed[2] = 0
+				}
+				b.dialersLock.Unlock()
 				DebugLog("BalancedDialer dail to %v with dialer(%v) success", uri, dialer)
 				return
 			}
-			failed[name]++
-			used[2]++
-			hostUsed[2]++
+			waiter.failed[name]++
 			DebugLog("BalancedDialer using %v and dial to %v fail with %v", dialer, uri, err)
-			failRemove := dialer.Options().Int64Def(0, "fail_remove")
-			if failRemove > 0 && used[2] >= failRemove {
-				DebugLog("BalancedDialer remove dialer(%v) by %v fail count", dialer, used[2])
-				delete(b.dialers, name)
-				delete(b.dialersUsed, name)
-				delete(b.dialersHostUsed, name)
+			if used := b.dialersUsed[name]; used != nil {
+				used[2]++
+				if hostUsed := b.dialersHostUsed[name][waiter.host]; hostUsed != nil {
+					hostUsed[2]++
+				}
+				failRemove := dialer.Options().Int64Def(0, "fail_remove")
+				if failRemove > 0 && used[2] >= failRemove {
+					DebugLog("BalancedDialer remove dialer(%v) by %v fail count", dialer, used[2])
+					b.remove(name, dialer)
+				}
 			}
+			b.dialersLock.Unlock()
+			time.AfterFunc(time.Duration(b.Delay)*time.Millisecond, func() {
+				b.dialersLock.Lock()
+				b.enqueue(waiter)
+				b.dialersLock.Unlock()
+			})
+		case <-timeout.C:
+			b.dialersLock.Lock()
+			b.dequeue(waiter)
+			b.dialersLock.Unlock()
+			err = fmt.Errorf("dial to %v timeout", uri)
+			return
+		case err = <-waiter.cancel:
+			b.dialersLock.Lock()
+			b.dequeue(waiter)
+			b.dialersLock.Unlock()
+			return
 		}
-		b.dialersLock <- 1
-		now = xtime.Now()
-		if now-showed > 3000 {
-			DebugLog("BalancedDialer dial to %v is waiting to connect", uri)
-			showed = now
-		}
-		time.Sleep(time.Duration(b.Delay) * time.Millisecond)
 	}
-	return
 }
 
-//Shutdown will shutdown dial
+//Shutdown will shutdown dial and save snapshot when snapshot is configured
 func (b *BalancedDialer) Shutdown() (err error) {
+	b.dialersLock.Lock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	for _, dialer := range b.dialers {
+		dialer.Shutdown()
+	}
+	for _, dialer := range b.dialersRemoved {
+		dialer.Shutdown()
+	}
+	b.dialersLock.Unlock()
+	if len(b.Snapshot) < 1 {
+		return
+	}
+	select {
+	case b.exiter <- 1:
+		b.waiter.Wait()
+		err = b.saveSnapshot()
+	default:
+	}
 	return
 }
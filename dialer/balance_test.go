@@ -3,10 +3,15 @@ package dialer
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/codingeasygo/util/converter"
 	"github.com/codingeasygo/util/xmap"
 	"github.com/codingeasygo/util/xtime"
 )
@@ -234,11 +239,11 @@ func (t *TimeDialer) Matched(uri string) bool {
 
 //dial raw connection
 func (t *TimeDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
-	if xtime.Now()-t.last < 100 {
+	if xtime.Now()-atomic.LoadInt64(&t.last) < 100 {
 		panic("too fast")
 	}
 	r = t
-	t.last = xtime.Now()
+	atomic.StoreInt64(&t.last, xtime.Now())
 	time.Sleep(10 * time.Millisecond)
 	return
 }
@@ -325,7 +330,7 @@ func TestBalancedDialerPolicy(t *testing.T) {
 	for i := 0; i < total; i++ {
 		go func(v int) {
 			defer wg.Done()
-			_, err = dialer.Dial(uint64(v), fmt.Sprintf("time-%v", v/10), nil)
+			_, err := dialer.Dial(uint64(v), fmt.Sprintf("time-%v", v/10), nil)
 			if err != nil {
 				t.Errorf("%v->%v", v, err)
 				return
@@ -390,7 +395,7 @@ func TestBalancedDialerLimit(t *testing.T) {
 	for i := 0; i < total; i++ {
 		go func(v int) {
 			defer wg.Done()
-			_, err = dialer.Dial(uint64(v), fmt.Sprintf("time-%v", v/10), nil)
+			_, err := dialer.Dial(uint64(v), fmt.Sprintf("time-%v", v/10), nil)
 			if err != nil {
 				t.Errorf("%v->%v", v, err)
 				return
@@ -473,3 +478,296 @@ func TestBalancedDialerFilter(t *testing.T) {
 		return
 	}
 }
+
+func TestBalancedDialerState(t *testing.T) {
+	NewDialer = func(t string) Dialer {
+		return &OnceDialer{}
+	}
+	defer func() {
+		NewDialer = DefaultDialerCreator
+	}()
+	dir, _ := ioutil.TempDir("", "balance")
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "snapshot.json")
+	options := xmap.M{
+		"id":             "b1",
+		"timeout":        200,
+		"delay":          10,
+		"snapshot":       snapshot,
+		"snapshot_delay": 50,
+		"dialers": []xmap.M{
+			{"id": "i0", "type": "once", "fail_remove": 1},
+			{"id": "i1", "type": "once", "limit": []int64{60000, 10}},
+		},
+	}
+	dialer := NewBalancedDialer()
+	err := dialer.Bootstrap(options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		_, err = dialer.Dial(uint64(i), "once", nil)
+		if (i < 2 && err != nil) || (i == 2 && err == nil) {
+			t.Errorf("%v,%v", i, err)
+			return
+		}
+	}
+	state := dialer.State()
+	dialers := state.Map("dialers")
+	if dialers.Value("i0/removed") != true || dialers.Value("i1/removed") != false || dialers.Int64Def(0, "i1/used") != 4 || len(dialers.Map("i1/hosts")) != 1 {
+		t.Errorf("%v", converter.JSON(state))
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err = os.Stat(snapshot); err != nil {
+		t.Error(err)
+		return
+	}
+	dialer.Shutdown()
+	dialer.Shutdown()
+	//load snapshot
+	dialer = NewBalancedDialer()
+	err = dialer.Bootstrap(options)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dialers = dialer.State().Map("dialers")
+	if dialers.Value("i0/removed") != true || dialers.Int64Def(0, "i1/used") != 4 || dialers.Int64Def(0, "i1/fail") != 3 {
+		t.Errorf("%v", converter.JSON(dialers))
+		return
+	}
+	//restore
+	pool := NewPool("test")
+	pool.AddDialer(dialer)
+	if restored, err := pool.Restore("b1", "none"); err != nil || len(restored) > 0 {
+		t.Errorf("%v,%v", err, restored)
+		return
+	}
+	if restored, err := pool.Restore("b1"); err != nil || len(restored) != 1 || restored[0] != "i0" {
+		t.Errorf("%v,%v", err, restored)
+		return
+	}
+	if _, err = pool.Restore("none"); err == nil {
+		t.Error(err)
+		return
+	}
+	if dialers = pool.State().Map("b1/dialers"); dialers.Value("i0/removed") != false {
+		t.Errorf("%v", converter.JSON(dialers))
+		return
+	}
+	pool.Shutdown()
+	pool.Shutdown()
+	//snapshot error
+	ioutil.WriteFile(snapshot, []byte("xx"), os.ModePerm)
+	if err = NewBalancedDialer().Bootstrap(options); err == nil {
+		t.Error(err)
+		return
+	}
+	options["snapshot"] = dir
+	if err = NewBalancedDialer().Bootstrap(options); err == nil {
+		t.Error(err)
+		return
+	}
+	dialer = NewBalancedDialer()
+	dialer.Snapshot = filepath.Join(dir, "none", "snapshot.json")
+	if err = dialer.saveSnapshot(); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+type CountDialer struct {
+	ID    string
+	conf  xmap.M
+	lck   sync.Mutex
+	dials []string
+}
+
+func (c *CountDialer) Name() string {
+	return c.ID
+}
+
+//initial dialer
+func (c *CountDialer) Bootstrap(options xmap.M) error {
+	c.ID = options.Str("id")
+	c.conf = options
+	return nil
+}
+
+//
+func (c *CountDialer) Options() xmap.M {
+	return c.conf
+}
+
+//match uri
+func (c *CountDialer) Matched(uri string) bool {
+	return true
+}
+
+//dial raw connection
+func (c *CountDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
+	c.lck.Lock()
+	c.dials = append(c.dials, uri)
+	c.lck.Unlock()
+	r = &OnceDialer{}
+	return
+}
+
+func (c *CountDialer) Shutdown() (err error) {
+	return
+}
+
+func (c *CountDialer) Dials() []string {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return append([]string{}, c.dials...)
+}
+
+func TestBalancedDialerQueue(t *testing.T) {
+	NewDialer = func(t string) Dialer {
+		return &CountDialer{}
+	}
+	defer func() {
+		NewDialer = DefaultDialerCreator
+	}()
+	//weight
+	dialer := NewBalancedDialer()
+	err := dialer.Bootstrap(xmap.M{
+		"id":      "q1",
+		"timeout": 1000,
+		"dialers": []xmap.M{
+			{"id": "w1", "type": "count", "weight": 3},
+			{"id": "w2", "type": "count"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 8; i++ {
+		if _, err = dialer.Dial(uint64(i), "tcp://a:80", nil); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	w1, w2 := dialer.dialers["w1"].(*CountDialer), dialer.dialers["w2"].(*CountDialer)
+	if len(w1.Dials()) != 6 || len(w2.Dials()) != 2 {
+		t.Errorf("%v,%v", w1.Dials(), w2.Dials())
+		return
+	}
+	dialer.Shutdown()
+	//wake on window reset and fair by host
+	dialer = NewBalancedDialer()
+	err = dialer.Bootstrap(xmap.M{
+		"id":      "q2",
+		"timeout": 3000,
+		"delay":   10000,
+		"dialers": []xmap.M{
+			{"id": "l1", "type": "count", "limit": []int{100, 1}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	begin := time.Now()
+	wg := sync.WaitGroup{}
+	for i, uri := range []string{"tcp://a:80", "tcp://a:80", "tcp://a:80", "tcp://b:80"} {
+		wg.Add(1)
+		go func(sid uint64, uri string) {
+			defer wg.Done()
+			if _, err := dialer.Dial(sid, uri, nil); err != nil {
+				t.Error(err)
+			}
+		}(uint64(i), uri)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	if used := time.Since(begin); used > time.Second {
+		t.Errorf("used %v", used)
+		return
+	}
+	l1 := dialer.dialers["l1"].(*CountDialer)
+	if dials := l1.Dials(); len(dials) != 4 || dials[2] != "tcp://b:80" {
+		t.Errorf("%v", dials)
+		return
+	}
+	//cancel
+	waited := make(chan error, 1)
+	go func() {
+		_, err := dialer.Dial(100, "tcp://a:80", nil)
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if state := dialer.State(); state.Int64Def(0, "waiting") != 1 {
+		t.Errorf("%v", converter.JSON(state))
+		return
+	}
+	pool := NewPool("test")
+	pool.AddDialer(dialer)
+	if !pool.Cancel(100) {
+		t.Error("not canceled")
+		return
+	}
+	if err = <-waited; err == nil {
+		t.Error(err)
+		return
+	}
+	if dialer.Cancel(100) || dialer.State().Int64Def(0, "waiting") != 0 {
+		t.Error("error")
+		return
+	}
+	//wake on add dialer
+	go func() {
+		_, err := dialer.Dial(101, "tcp://a:80", nil)
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	added := &CountDialer{}
+	added.Bootstrap(xmap.M{"id": "l2"})
+	dialer.AddDialer(added)
+	if err = <-waited; err != nil || len(added.Dials()) != 1 {
+		t.Errorf("%v,%v", err, added.Dials())
+		return
+	}
+	//timeout
+	dialer.Timeout = 50
+	dialer.dialers["l1"].(*CountDialer).conf = xmap.M{"limit": []int{10000, 1}}
+	dialer.dialers["l2"].(*CountDialer).conf = xmap.M{"limit": []int{10000, 1}}
+	if _, err = dialer.Dial(102, "tcp://a:80", nil); err == nil {
+		t.Error(err)
+		return
+	}
+	dialer.Shutdown()	//late confirm after window reset
+	dialer = NewBalancedDialer()
+	err = dialer.Bootstrap(xmap.M{
+		"id": "q3",
+		"dialers": []xmap.M{
+			{"id": "l1", "type": "count", "limit": []int{100, 1}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := xtime.Now()
+	late := &balancedWaiter{host: "a:80"}
+	dialer.reserve(late, "l1", now)
+	waiter := &balancedWaiter{host: "a:80", failed: map[string]int{}}
+	if name, _ := dialer.selectDialer(waiter, now+200); name != "l1" {
+		t.Error(name)
+		return
+	}
+	dialer.reserve(waiter, "l1", now+200)
+	if _, ok := dialer.confirm(waiter, "l1", now+201); !ok {
+		t.Error("error")
+		return
+	}
+	if _, ok := dialer.confirm(late, "l1", now+202); ok {
+		t.Error("error")
+		return
+	}
+	dialer.Shutdown()
+}
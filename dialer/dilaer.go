@@ -73,8 +73,10 @@ type Pool struct {
 	Name        string
 	Dialers     []Dialer
 	Webs        map[string]http.Handler
+	RouterDial  func(network, address string) (net.Conn, error) //the dialer to dial by router uri, it is used by resolver via
 	conns       map[string]Conn
 	connsLocker sync.RWMutex
+	stopped     bool
 }
 
 //NewPool will return new Pool
@@ -129,9 +131,32 @@ func (p *Pool) Bootstrap(options xmap.M) error {
 			InfoLog("Pool(%v) add web/%v dialer to pool", p.Name, n)
 		}
 	}
+	if options.Value("dns") != nil || options.IntDef(0, "standard") > 0 || options.IntDef(0, "std") > 0 {
+		conf := xmap.M{}
+		for key, val := range options.MapDef(xmap.M{}, "dns") {
+			conf[key] = val
+		}
+		if conf.Value("resolver") == nil && options.Value("tcp/resolver") != nil {
+			conf["resolver"] = options.Value("tcp/resolver") //lookup by same resolver of tcp dialer
+		}
+		dns := NewDNSDialer()
+		err := dns.Bootstrap(conf)
+		if err != nil {
+			return err
+		}
+		dns.Resolver.Dial = p.RouterDial
+		p.Dialers = append(p.Dialers, dns)
+		InfoLog("Pool(%v) add dns dialer to pool", p.Name)
+	}
 	if options.Value("tcp") != nil || options.IntDef(0, "standard") > 0 || options.IntDef(0, "std") > 0 {
 		tcp := NewTCPDialer()
-		tcp.Bootstrap(options.MapDef(xmap.M{}, "tcp"))
+		err := tcp.Bootstrap(options.MapDef(xmap.M{}, "tcp"))
+		if err != nil {
+			return err
+		}
+		if tcp.Resolver != nil {
+			tcp.Resolver.Dial = p.RouterDial
+		}
 		p.Dialers = append(p.Dialers, tcp)
 		InfoLog("Pool(%v) add tcp dialer to pool", p.Name)
 	}
@@ -151,8 +176,56 @@ func (p *Pool) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, er
 	return
 }
 
-//Shutdown will shutdown all dialer
+//State will return the state of all dialer which is Statable
+func (p *Pool) State(args ...interface{}) (state xmap.M) {
+	state = xmap.M{}
+	for _, dialer := range p.Dialers {
+		if statable, ok := dialer.(Statable); ok {
+			state[dialer.Name()] = statable.State(args...)
+		}
+	}
+	return
+}
+
+//Restore will add the removed sub dialer back to balanced dialer by id, all removed is restored when names is empty
+func (p *Pool) Restore(id string, names ...string) (restored []string, err error) {
+	for _, dialer := range p.Dialers {
+		if balanced, ok := dialer.(*BalancedDialer); ok && balanced.Name() == id {
+			restored = balanced.Restore(names...)
+			return
+		}
+	}
+	err = fmt.Errorf("balanced dialer %v is not exists", id)
+	return
+}
+
+//Cancelable is interface to cancel the dial which is running by sid
+type Cancelable interface {
+	Cancel(sid uint64) bool
+}
+
+//Cancel will cancel the dial which is running by sid on all Cancelable dialer
+func (p *Pool) Cancel(sid uint64) (canceled bool) {
+	for _, dialer := range p.Dialers {
+		if cancelable, ok := dialer.(Cancelable); ok && cancelable.Cancel(sid) {
+			canceled = true
+		}
+	}
+	return
+}
+
+//Shutdown will shutdown all dialer, it only works at first call
 func (p *Pool) Shutdown() (err error) {
+	p.connsLocker.Lock()
+	stopped := p.stopped
+	p.stopped = true
+	p.connsLocker.Unlock()
+	if stopped {
+		return
+	}
+	for _, dialer := range p.Dialers {
+		dialer.Shutdown()
+	}
 	return
 }
 
@@ -163,6 +236,10 @@ func DefaultDialerCreator(t string) (dialer Dialer) {
 		dialer = NewBalancedDialer()
 	case "socks":
 		dialer = NewSocksProxyDialer()
+	case "http":
+		dialer = NewHTTPProxyDialer()
+	case "ssh":
+		dialer = NewSSHDialer()
 	}
 	return
 }
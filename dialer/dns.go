@@ -0,0 +1,145 @@
+package dialer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+//DNSResult is the dns lookup result which is returned by DNSDialer
+type DNSResult struct {
+	Host    string   `json:"host"`
+	Type    string   `json:"type"`
+	Records []string `json:"records"`
+}
+
+//dnsResultConn is an implementation of the io.ReadWriteCloser interface for reading dns result, the writing data is dropped.
+type dnsResultConn struct {
+	*bytes.Reader
+}
+
+func (d *dnsResultConn) Write(p []byte) (n int, err error) {
+	n = len(p)
+	return
+}
+
+func (d *dnsResultConn) Close() (err error) {
+	return
+}
+
+//DNSDialer is an implementation of the Dialer interface for dns lookup by dns://host?type=A
+type DNSDialer struct {
+	Resolver *Resolver
+	conf     xmap.M
+}
+
+//NewDNSDialer will return new DNSDialer
+func NewDNSDialer() (dialer *DNSDialer) {
+	dialer = &DNSDialer{
+		Resolver: NewResolver(),
+		conf:     xmap.M{},
+	}
+	return
+}
+
+//Name will return dialer name
+func (d *DNSDialer) Name() string {
+	return "dns"
+}
+
+//Bootstrap the dialer, resolver is the custom resolver options, see Resolver.Bootstrap
+func (d *DNSDialer) Bootstrap(options xmap.M) (err error) {
+	d.conf = options
+	if resolver := options.Map("resolver"); resolver != nil {
+		err = d.Resolver.Bootstrap(resolver)
+	}
+	return
+}
+
+//Options is options getter
+func (d *DNSDialer) Options() xmap.M {
+	return d.conf
+}
+
+//Matched will return whether the uri is dns uri
+func (d *DNSDialer) Matched(uri string) bool {
+	target, err := url.Parse(uri)
+	return err == nil && target.Scheme == "dns"
+}
+
+//Lookup will lookup the host by type of ip/a/aaaa/cname/mx/ns/txt/ptr
+func (d *DNSDialer) Lookup(ctx context.Context, host, qtype string) (result *DNSResult, err error) {
+	result = &DNSResult{Host: host, Type: strings.ToUpper(qtype)}
+	if len(result.Type) < 1 {
+		result.Type = "IP"
+	}
+	switch result.Type {
+	case "IP", "A", "AAAA":
+		network := map[string]string{"IP": "ip", "A": "ip4", "AAAA": "ip6"}[result.Type]
+		var ips []net.IP
+		ips, err = d.Resolver.LookupIP(ctx, network, host)
+		for _, ip := range ips {
+			result.Records = append(result.Records, ip.String())
+		}
+	case "CNAME":
+		var cname string
+		cname, err = d.Resolver.Resolver.LookupCNAME(ctx, host)
+		result.Records = append(result.Records, cname)
+	case "MX":
+		var mxs []*net.MX
+		mxs, err = d.Resolver.Resolver.LookupMX(ctx, host)
+		for _, mx := range mxs {
+			result.Records = append(result.Records, fmt.Sprintf("%v %v", mx.Pref, mx.Host))
+		}
+	case "NS":
+		var nss []*net.NS
+		nss, err = d.Resolver.Resolver.LookupNS(ctx, host)
+		for _, ns := range nss {
+			result.Records = append(result.Records, ns.Host)
+		}
+	case "TXT":
+		result.Records, err = d.Resolver.Resolver.LookupTXT(ctx, host)
+	case "PTR":
+		result.Records, err = d.Resolver.Resolver.LookupAddr(ctx, host)
+	default:
+		err = fmt.Errorf("not supported type %v", qtype)
+	}
+	return
+}
+
+//Dial will lookup the host and return the connection which is reading the json of DNSResult
+func (d *DNSDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.Resolver.Timeout)
+	defer cancel()
+	result, err := d.Lookup(ctx, target.Hostname(), target.Query().Get("type"))
+	if err != nil {
+		DebugLog("DNSDialer lookup %v fail with %v", uri, err)
+		return
+	}
+	data, _ := json.Marshal(result)
+	raw = NewCopyPipable(&dnsResultConn{Reader: bytes.NewReader(data)})
+	if pipe != nil {
+		assert(raw.Pipe(pipe) == nil)
+	}
+	return
+}
+
+//Shutdown will shutdown dial
+func (d *DNSDialer) Shutdown() (err error) {
+	return
+}
+
+func (d *DNSDialer) String() string {
+	return "DNSDialer"
+}
@@ -0,0 +1,125 @@
+package dialer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+func TestDNSDialer(t *testing.T) {
+	udp, tcp := runTestDNSServer(t)
+	defer udp.Close()
+	defer tcp.Close()
+	dns := NewDNSDialer()
+	err := dns.Bootstrap(xmap.M{
+		"resolver": xmap.M{
+			"servers": []string{udp.LocalAddr().String()},
+			"hosts":   xmap.M{"static.bsck": "10.2.2.2,::2"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !dns.Matched("dns://test.bsck") || dns.Matched("tcp://test.bsck") {
+		t.Error("error")
+		return
+	}
+	dig := func(uri string) (result *DNSResult, err error) {
+		conn, err := dns.Dial(10, uri, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, err := ioutil.ReadAll(conn)
+		if err != nil {
+			return
+		}
+		result = &DNSResult{}
+		err = json.Unmarshal(data, result)
+		return
+	}
+	for uri, expect := range map[string]string{
+		"dns://test.bsck":              "IP:10.1.1.1",
+		"dns://test.bsck?type=a":       "A:10.1.1.1",
+		"dns://static.bsck":            "IP:10.2.2.2,::2",
+		"dns://static.bsck?type=AAAA":  "AAAA:::2",
+		"dns://txt.bsck?type=TXT":      "TXT:hello",
+		"dns://localhost?type=ptr":     "PTR:",
+		"dns://127.0.0.1?type=ptr":     "PTR:",
+		"dns://test.bsck?type=cname":   "CNAME:",
+		"dns://test.bsck?type=mx":      "MX:",
+		"dns://test.bsck?type=ns":      "NS:",
+		"dns://static.bsck?type=xx":    "",
+		"dns://none.bsck":              "",
+		"dns://test.bsck?type=AAAA":    "",
+		"dns://%zz":                    "",
+		"dns://127.0.0.1?type=a":       "A:127.0.0.1",
+		"dns://[::1]?type=aaaa":        "AAAA:::1",
+		"dns://static.bsck.?type=AAAA": "AAAA:::2",
+	} {
+		result, err := dig(uri)
+		if len(expect) < 1 {
+			if err == nil {
+				t.Errorf("%v,%v", uri, result)
+				return
+			}
+			continue
+		}
+		if strings.HasSuffix(expect, ":") {
+			//only check the type is supported, the record is depended on test server
+			if err == nil && result.Type+":" != expect {
+				t.Errorf("%v,%v", uri, result)
+				return
+			}
+			continue
+		}
+		if err != nil || result.Type+":"+strings.Join(result.Records, ",") != expect {
+			t.Errorf("%v,%v,%v", uri, err, result)
+			return
+		}
+	}
+	//pipe
+	cona, conb, _ := CreatePipedConn()
+	_, err = dns.Dial(10, "dns://test.bsck", conb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if data, err := ioutil.ReadAll(cona); err != nil || !strings.Contains(string(data), "10.1.1.1") {
+		t.Errorf("%v,%v", err, string(data))
+		return
+	}
+	dns.Options()
+	dns.Name()
+	dns.Shutdown()
+	t.Logf("%v", dns)
+	//pool
+	pool := NewPool("test")
+	err = pool.Bootstrap(xmap.M{
+		"dns": xmap.M{},
+		"tcp": xmap.M{
+			"resolver": xmap.M{"hosts": xmap.M{"static.bsck": "10.2.2.2"}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if d := pool.Dialers[0].(*DNSDialer); len(d.Resolver.Static("static.bsck")) != 1 {
+		t.Error("error")
+		return
+	}
+	//error
+	if err = NewDNSDialer().Bootstrap(xmap.M{"resolver": xmap.M{"via": "x"}}); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = NewPool("test").Bootstrap(xmap.M{"dns": xmap.M{"resolver": xmap.M{"via": "x"}}}); err == nil {
+		t.Error(err)
+		return
+	}
+}
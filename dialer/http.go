@@ -0,0 +1,159 @@
+package dialer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+//bufferedConn is net.Conn which read the data buffered by reader first
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (n int, err error) {
+	n, err = b.reader.Read(p)
+	return
+}
+
+//HTTPProxyDialer is an implementation of the Dialer interface for dial by http proxy CONNECT.
+type HTTPProxyDialer struct {
+	ID       string
+	Pooler   SocksProxyAddressPooler
+	Username string
+	Password string
+	matcher  *regexp.Regexp
+	conf     xmap.M
+}
+
+//NewHTTPProxyDialer will return new HTTPProxyDialer
+func NewHTTPProxyDialer() *HTTPProxyDialer {
+	return &HTTPProxyDialer{
+		matcher: regexp.MustCompile("^.*:[0-9]+$"),
+		conf:    xmap.M{},
+	}
+}
+
+//Name will return dialer name
+func (h *HTTPProxyDialer) Name() string {
+	return h.ID
+}
+
+//Bootstrap the dialer.
+func (h *HTTPProxyDialer) Bootstrap(options xmap.M) (err error) {
+	h.ID = options.Str("id")
+	if len(h.ID) < 1 {
+		return fmt.Errorf("the dialer id is required")
+	}
+	h.Username = options.Str("username")
+	h.Password = options.Str("password")
+	h.conf = options
+	matcher := options.Str("matcher")
+	if len(matcher) > 0 {
+		h.matcher, err = regexp.Compile(matcher)
+		if err != nil {
+			return
+		}
+	}
+	h.Pooler, err = NewAddressPooler(options)
+	return
+}
+
+//Options is options getter
+func (h *HTTPProxyDialer) Options() xmap.M {
+	return h.conf
+}
+
+//Matched will return whether the uri is invalid tcp uri.
+func (h *HTTPProxyDialer) Matched(uri string) bool {
+	remote, err := url.Parse(uri)
+	return err == nil && h.matcher.MatchString(remote.Host)
+}
+
+//Dial one connection by uri
+func (h *HTTPProxyDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	host := remote.Host
+	if len(remote.Port()) < 1 {
+		switch remote.Scheme {
+		case "http":
+			host += ":80"
+		case "https":
+			host += ":443"
+		default:
+			err = fmt.Errorf("not supported address:%v", remote.Host)
+			return
+		}
+	}
+	address, err := h.Pooler.Get(uri)
+	if err != nil {
+		return
+	}
+	var doneErr error
+	defer func() {
+		h.Pooler.Done(address, uri, doneErr)
+	}()
+	DebugLog("HTTPProxyDialer dial to %v by %v", host, address)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		doneErr = &CodeError{Inner: err, ByteCode: SocksCodeProxyFail}
+		return
+	}
+	request := fmt.Sprintf("CONNECT %v HTTP/1.1\r\nHost: %v\r\n", host, host)
+	if len(h.Username) > 0 || len(h.Password) > 0 {
+		auth := base64.StdEncoding.EncodeToString([]byte(h.Username + ":" + h.Password))
+		request += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	_, err = conn.Write([]byte(request + "\r\n"))
+	if err != nil {
+		conn.Close()
+		doneErr = &CodeError{Inner: err, ByteCode: SocksCodeProxyFail}
+		return
+	}
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, &http.Request{Method: "CONNECT"})
+	if err != nil {
+		conn.Close()
+		doneErr = &CodeError{Inner: err, ByteCode: SocksCodeProxyFail}
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		err = fmt.Errorf("response status(%v)", strings.TrimSpace(res.Status))
+		//the error status like 502/504 is returned by healthy proxy when target is down, so only auth fail is reported
+		if res.StatusCode == http.StatusProxyAuthRequired {
+			doneErr = &CodeError{Inner: err, ByteCode: SocksCodeAuthFail}
+		}
+		return
+	}
+	raw = NewCopyPipable(&bufferedConn{Conn: conn, reader: reader})
+	if pipe != nil {
+		assert(raw.Pipe(pipe) == nil)
+	}
+	return
+}
+
+//Shutdown will shutdown dial
+func (h *HTTPProxyDialer) Shutdown() (err error) {
+	if closer, ok := h.Pooler.(io.Closer); ok {
+		err = closer.Close()
+	}
+	return
+}
+
+func (h *HTTPProxyDialer) String() string {
+	return fmt.Sprintf("HTTPProxyDialer-%v", h.ID)
+}
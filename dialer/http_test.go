@@ -0,0 +1,233 @@
+package dialer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+type recordPool struct {
+	address string
+	done    error
+}
+
+func (r *recordPool) Get(uri string) (address string, err error) {
+	address = r.address
+	return
+}
+
+func (r *recordPool) Done(address, uri string, err error) {
+	r.done = err
+}
+
+//runTestHTTPProxy will run http proxy which only supports CONNECT
+func runTestHTTPProxy(t *testing.T, username, password string) (listener net.Listener) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				if req.Method != "CONNECT" {
+					fmt.Fprintf(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+					conn.Close()
+					return
+				}
+				auth := &http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}
+				user, pass, _ := auth.BasicAuth()
+				if user != username || pass != password {
+					fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					conn.Close()
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					conn.Close()
+					return
+				}
+				//send response and first data together to test buffered data
+				fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\nwelcome")
+				go func() {
+					io.Copy(conn, target)
+					conn.Close()
+				}()
+				io.Copy(target, reader)
+				target.Close()
+			}()
+		}
+	}()
+	return
+}
+
+func TestHTTPProxyDialer(t *testing.T) {
+	echo, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	proxy := runTestHTTPProxy(t, "test", "123")
+	defer proxy.Close()
+	dialer := NewHTTPProxyDialer()
+	err := dialer.Bootstrap(xmap.M{
+		"id":       "h1",
+		"address":  proxy.Addr().String(),
+		"username": "test",
+		"password": "123",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	uri := fmt.Sprintf("tcp://%v", echo.Addr())
+	if !dialer.Matched(uri) || dialer.Matched("http://xxx") || dialer.Name() != "h1" || dialer.Options() == nil || dialer.String() != "HTTPProxyDialer-h1" {
+		t.Error("error")
+		return
+	}
+	readAll := func(conn io.Reader, expect string) {
+		buf := make([]byte, len(expect))
+		_, err := io.ReadFull(conn, buf)
+		if err != nil || string(buf) != expect {
+			t.Errorf("%v,%v", err, string(buf))
+		}
+	}
+	conn, err := dialer.Dial(1, uri, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readAll(conn, "welcome")
+	fmt.Fprintf(conn, "abc")
+	readAll(conn, "abc")
+	conn.Close()
+	//pipe
+	piped, pipe, _ := CreatePipedConn()
+	_, err = dialer.Dial(1, uri, pipe)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readAll(piped, "welcome")
+	fmt.Fprintf(piped, "123")
+	readAll(piped, "123")
+	piped.Close()
+	//target error
+	if _, err = dialer.Dial(1, "tcp://127.0.0.1:1", nil); err == nil {
+		t.Error(err)
+		return
+	}
+	//default port
+	if _, err = dialer.Dial(1, "http://127.0.0.1", nil); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = dialer.Dial(1, "https://127.0.0.1", nil); err == nil {
+		t.Error(err)
+		return
+	}
+	//invalid uri
+	for _, uri := range []string{"tcp://%zz", "tcp://127.0.0.1"} {
+		if _, err = dialer.Dial(1, uri, nil); err == nil {
+			t.Error(uri)
+			return
+		}
+	}
+	//target error is not reported to pooler
+	pool := &recordPool{address: proxy.Addr().String()}
+	dialer = NewHTTPProxyDialer()
+	dialer.Bootstrap(xmap.M{"id": "h1", "username": "test", "password": "123"})
+	dialer.Pooler = pool
+	if _, err = dialer.Dial(1, "tcp://127.0.0.1:1", nil); err == nil || pool.done != nil {
+		t.Errorf("%v,%v", err, pool.done)
+		return
+	}
+	//auth fail
+	dialer = NewHTTPProxyDialer()
+	dialer.Bootstrap(xmap.M{"id": "h1", "username": "xx"})
+	dialer.Pooler = pool
+	_, err = dialer.Dial(1, uri, nil)
+	if cerr, ok := pool.done.(*CodeError); err == nil || !ok || cerr.Code() != SocksCodeAuthFail {
+		t.Errorf("%v,%v", err, pool.done)
+		return
+	}
+	//proxy fail
+	pool.address = "127.0.0.1:1"
+	_, err = dialer.Dial(1, uri, nil)
+	if cerr, ok := pool.done.(*CodeError); err == nil || !ok || cerr.Code() != SocksCodeProxyFail {
+		t.Errorf("%v,%v", err, pool.done)
+		return
+	}
+	//pool error
+	dialer.Pooler = ErroPool("")
+	if _, err = dialer.Dial(1, uri, nil); err == nil {
+		t.Error(err)
+		return
+	}
+	//proxy error, the echo server will response the request back which is not valid response
+	for _, address := range []string{"127.0.0.1:1", echo.Addr().String()} {
+		dialer = NewHTTPProxyDialer()
+		dialer.Bootstrap(xmap.M{"id": "h1", "address": address})
+		if _, err = dialer.Dial(1, uri, nil); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	//balance
+	balanced := NewBalancedDialer()
+	err = balanced.Bootstrap(xmap.M{
+		"id":      "b1",
+		"matcher": ".*",
+		"dialers": []xmap.M{
+			{
+				"id":       "h1",
+				"type":     "http",
+				"address":  proxy.Addr().String(),
+				"username": "test",
+				"password": "123",
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err = balanced.Dial(1, uri, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readAll(conn, "welcome")
+	conn.Close()
+	balanced.Shutdown()
+	//bootstrap error
+	for _, options := range []xmap.M{{}, {"id": "h1", "matcher": "["}} {
+		if err = NewHTTPProxyDialer().Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+	dialer.Shutdown()
+}
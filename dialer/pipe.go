@@ -0,0 +1,249 @@
+package dialer
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+//PipeBufferSize is the max buffered bytes of one direction on piped connection
+var PipeBufferSize = 64 * 1024
+
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "i/o timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+//ErrPipeTimeout is the error when read/write on piped connection is timeout by deadline
+var ErrPipeTimeout net.Error = pipeTimeoutError{}
+
+//pipeHalf is the one direction buffered pipe
+type pipeHalf struct {
+	buf           []byte
+	offset        int
+	closed        bool //the write side is closed, reader will receive EOF after buffered data is read
+	broken        bool //the read side is closed, writer will receive io.ErrClosedPipe
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readable      chan int
+	writable      chan int
+	lck           sync.Mutex
+}
+
+func newPipeHalf() (half *pipeHalf) {
+	half = &pipeHalf{
+		readable: make(chan int, 1),
+		writable: make(chan int, 1),
+		lck:      sync.Mutex{},
+	}
+	return
+}
+
+func notify(c chan int) {
+	select {
+	case c <- 1:
+	default:
+	}
+}
+
+//wait will wait the signal until deadline, return false if deadline is exceeded
+func wait(c chan int, deadline time.Time) bool {
+	if deadline.IsZero() {
+		<-c
+		return true
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (p *pipeHalf) Read(b []byte) (n int, err error) {
+	for {
+		p.lck.Lock()
+		if p.broken {
+			p.lck.Unlock()
+			err = io.ErrClosedPipe
+			return
+		}
+		if len(p.buf) > p.offset {
+			n = copy(b, p.buf[p.offset:])
+			p.offset += n
+			if p.offset == len(p.buf) {
+				p.buf, p.offset = p.buf[:0], 0
+			}
+			p.lck.Unlock()
+			notify(p.writable)
+			return
+		}
+		if p.closed {
+			p.lck.Unlock()
+			err = io.EOF
+			return
+		}
+		deadline := p.readDeadline
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			p.lck.Unlock()
+			err = ErrPipeTimeout
+			return
+		}
+		p.lck.Unlock()
+		wait(p.readable, deadline)
+	}
+}
+
+func (p *pipeHalf) Write(b []byte) (n int, err error) {
+	for {
+		p.lck.Lock()
+		if p.closed || p.broken {
+			p.lck.Unlock()
+			err = io.ErrClosedPipe
+			return
+		}
+		deadline := p.writeDeadline
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			p.lck.Unlock()
+			err = ErrPipeTimeout
+			return
+		}
+		if space := PipeBufferSize - (len(p.buf) - p.offset); space > 0 {
+			if p.offset > 0 && len(p.buf)+len(b)-n > cap(p.buf) {
+				p.buf = p.buf[:copy(p.buf, p.buf[p.offset:])]
+				p.offset = 0
+			}
+			size := len(b) - n
+			if size > space {
+				size = space
+			}
+			p.buf = append(p.buf, b[n:n+size]...)
+			n += size
+			p.lck.Unlock()
+			notify(p.readable)
+			if n >= len(b) {
+				return
+			}
+			continue
+		}
+		p.lck.Unlock()
+		wait(p.writable, deadline)
+	}
+}
+
+//closeWrite will mark the write side closed
+func (p *pipeHalf) closeWrite() {
+	p.lck.Lock()
+	p.closed = true
+	p.lck.Unlock()
+	notify(p.readable)
+	notify(p.writable)
+}
+
+//closeRead will mark the read side closed and drop the buffered data
+func (p *pipeHalf) closeRead() {
+	p.lck.Lock()
+	p.broken = true
+	p.buf, p.offset = nil, 0
+	p.lck.Unlock()
+	notify(p.readable)
+	notify(p.writable)
+}
+
+func (p *pipeHalf) setReadDeadline(t time.Time) {
+	p.lck.Lock()
+	p.readDeadline = t
+	p.lck.Unlock()
+	notify(p.readable)
+}
+
+func (p *pipeHalf) setWriteDeadline(t time.Time) {
+	p.lck.Lock()
+	p.writeDeadline = t
+	p.lck.Unlock()
+	notify(p.writable)
+}
+
+//PipedConn is an implementation of the net.Conn interface for in memory piped two connection,
+//it supports deadline and half-close by CloseWrite.
+type PipedConn struct {
+	reader *pipeHalf
+	writer *pipeHalf
+}
+
+//CreatePipedConn will return two piped connection.
+func CreatePipedConn() (a, b *PipedConn, err error) {
+	up, down := newPipeHalf(), newPipeHalf()
+	a = &PipedConn{reader: up, writer: down}
+	b = &PipedConn{reader: down, writer: up}
+	return
+}
+
+func (p *PipedConn) Read(b []byte) (n int, err error) {
+	n, err = p.reader.Read(b)
+	return
+}
+
+func (p *PipedConn) Write(b []byte) (n int, err error) {
+	n, err = p.writer.Write(b)
+	return
+}
+
+//CloseWrite will close the write side, the peer will receive EOF after buffered data is read
+func (p *PipedConn) CloseWrite() error {
+	p.writer.closeWrite()
+	return nil
+}
+
+//Close the piped connection
+func (p *PipedConn) Close() error {
+	p.writer.closeWrite()
+	p.reader.closeRead()
+	return nil
+}
+
+//LocalAddr return self
+func (p *PipedConn) LocalAddr() net.Addr {
+	return p
+}
+
+//RemoteAddr return self
+func (p *PipedConn) RemoteAddr() net.Addr {
+	return p
+}
+
+//SetDeadline will set read and write deadline
+func (p *PipedConn) SetDeadline(t time.Time) error {
+	p.reader.setReadDeadline(t)
+	p.writer.setWriteDeadline(t)
+	return nil
+}
+
+//SetReadDeadline will set read deadline
+func (p *PipedConn) SetReadDeadline(t time.Time) error {
+	p.reader.setReadDeadline(t)
+	return nil
+}
+
+//SetWriteDeadline will set write deadline
+func (p *PipedConn) SetWriteDeadline(t time.Time) error {
+	p.writer.setWriteDeadline(t)
+	return nil
+}
+
+//Network return "piped"
+func (p *PipedConn) Network() string {
+	return "piped"
+}
+
+func (p *PipedConn) String() string {
+	return "piped"
+}
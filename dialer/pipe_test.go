@@ -0,0 +1,116 @@
+package dialer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPipedConn(t *testing.T) {
+	cona, conb, _ := CreatePipedConn()
+	var _ net.Conn = cona
+	//read write
+	go cona.Write([]byte("abc"))
+	buf := make([]byte, 1024)
+	n, err := conb.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Errorf("%v,%v", err, string(buf[:n]))
+		return
+	}
+	//large write is blocked until read
+	data := bytes.Repeat([]byte("x"), 3*PipeBufferSize)
+	go func() {
+		cona.Write(data)
+		cona.CloseWrite()
+	}()
+	readed, err := ioutil.ReadAll(conb)
+	if err != nil || !bytes.Equal(readed, data) {
+		t.Errorf("%v,%v", err, len(readed))
+		return
+	}
+	//half close
+	if _, err = cona.Write([]byte("abc")); err != io.ErrClosedPipe {
+		t.Error(err)
+		return
+	}
+	go conb.Write([]byte("123"))
+	n, err = cona.Read(buf)
+	if err != nil || string(buf[:n]) != "123" {
+		t.Errorf("%v,%v", err, string(buf[:n]))
+		return
+	}
+	//close
+	conb.Close()
+	if _, err = cona.Read(buf); err != io.EOF {
+		t.Error(err)
+		return
+	}
+	if _, err = conb.Read(buf); err != io.ErrClosedPipe {
+		t.Error(err)
+		return
+	}
+	cona.Close()
+	cona.LocalAddr()
+	cona.RemoteAddr()
+	cona.Network()
+	_ = cona.String()
+}
+
+func TestPipedConnDeadline(t *testing.T) {
+	cona, conb, _ := CreatePipedConn()
+	defer cona.Close()
+	//read deadline
+	cona.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	buf := make([]byte, 1024)
+	_, err := cona.Read(buf)
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Error(err)
+		return
+	}
+	//extend deadline when reading
+	cona.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cona.SetReadDeadline(time.Time{})
+		time.Sleep(20 * time.Millisecond)
+		conb.Write([]byte("abc"))
+	}()
+	n, err := cona.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Errorf("%v,%v", err, string(buf[:n]))
+		return
+	}
+	//write deadline
+	conb.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	n, err = conb.Write(bytes.Repeat([]byte("x"), 2*PipeBufferSize))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() || n != PipeBufferSize {
+		t.Errorf("%v,%v", err, n)
+		return
+	}
+	conb.SetWriteDeadline(time.Time{})
+	//writer is broken by close
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cona.Close()
+	}()
+	_, err = conb.Write([]byte("abc"))
+	if err != io.ErrClosedPipe {
+		t.Error(err)
+		return
+	}
+}
+
+func BenchmarkPipedConn(b *testing.B) {
+	cona, conb, _ := CreatePipedConn()
+	go io.Copy(ioutil.Discard, conb)
+	data := make([]byte, 32*1024)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cona.Write(data)
+	}
+	cona.Close()
+}
@@ -0,0 +1,298 @@
+package dialer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+//poolerAddress is the state of one address in MultiAddressPooler
+type poolerAddress struct {
+	Address     string
+	Used        int64
+	Total       int64
+	Quarantined bool
+}
+
+//MultiAddressPooler is an implementation of the SocksProxyAddressPooler interface for multi address,
+//the address is quarantined when it is done with CodeError of SocksCodeProxyFail or network error of connecting to it,
+//and it is returned to rotation after probe success in background.
+type MultiAddressPooler struct {
+	Policy       string //the policy of round or least
+	File         string //the address file, one address per line
+	Reload       time.Duration
+	ProbeDelay   time.Duration
+	ProbeTimeout time.Duration
+	Probe        func(address string) error
+	addresses    []*poolerAddress
+	index        int
+	lck          sync.RWMutex
+	exiter       chan int
+	waiter       sync.WaitGroup
+}
+
+//NewMultiAddressPooler will return new MultiAddressPooler
+func NewMultiAddressPooler() (pooler *MultiAddressPooler) {
+	pooler = &MultiAddressPooler{
+		Policy:       "round",
+		Reload:       time.Minute,
+		ProbeDelay:   10 * time.Second,
+		ProbeTimeout: 3 * time.Second,
+		lck:          sync.RWMutex{},
+		exiter:       make(chan int, 1),
+		waiter:       sync.WaitGroup{},
+	}
+	pooler.Probe = pooler.probeTCP
+	return
+}
+
+//NewAddressPooler will return MultiAddressPooler when addresses or address_file is configured, else return StringAddressPooler by address
+func NewAddressPooler(options xmap.M) (pooler SocksProxyAddressPooler, err error) {
+	if len(options.ArrayStrDef(nil, "addresses")) < 1 && len(options.Str("address_file")) < 1 {
+		pooler = StringAddressPooler(options.Str("address"))
+		return
+	}
+	multi := NewMultiAddressPooler()
+	err = multi.Bootstrap(options)
+	if err == nil {
+		pooler = multi
+	}
+	return
+}
+
+//Bootstrap the pooler by options and start background runner
+//
+//addresses is the address list, address_file is the address file which is reloaded by reload delay in milliseconds
+//
+//policy is round or least, default is round
+//
+//probe/probe_timeout is the probe delay and timeout in milliseconds
+func (m *MultiAddressPooler) Bootstrap(options xmap.M) (err error) {
+	m.Policy = options.StrDef(m.Policy, "policy")
+	if m.Policy != "round" && m.Policy != "least" {
+		return fmt.Errorf("the pooler policy %v is not supported", m.Policy)
+	}
+	m.File = options.Str("address_file")
+	if reload := options.Int64Def(0, "reload"); reload > 0 {
+		m.Reload = time.Duration(reload) * time.Millisecond
+	}
+	if probe := options.Int64Def(0, "probe"); probe > 0 {
+		m.ProbeDelay = time.Duration(probe) * time.Millisecond
+	}
+	if timeout := options.Int64Def(0, "probe_timeout"); timeout > 0 {
+		m.ProbeTimeout = time.Duration(timeout) * time.Millisecond
+	}
+	addresses := options.ArrayStrDef(nil, "addresses")
+	if len(m.File) > 0 {
+		var fileAddresses []string
+		fileAddresses, err = m.readFile()
+		if err != nil {
+			return
+		}
+		addresses = append(addresses, fileAddresses...)
+	}
+	if len(addresses) < 1 {
+		return fmt.Errorf("the pooler address is required")
+	}
+	m.Set(addresses)
+	m.waiter.Add(1)
+	go m.runner(options.ArrayStrDef(nil, "addresses"))
+	return
+}
+
+//readFile will read address from file, the empty line and line begin with # is skipped
+func (m *MultiAddressPooler) readFile() (addresses []string, err error) {
+	data, err := ioutil.ReadFile(m.File)
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewBuffer(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addresses = append(addresses, line)
+	}
+	return
+}
+
+//Set will replace the address list, the state of exists address is kept
+func (m *MultiAddressPooler) Set(addresses []string) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	having := map[string]*poolerAddress{}
+	for _, address := range m.addresses {
+		having[address.Address] = address
+	}
+	m.addresses = nil
+	for _, address := range addresses {
+		if old := having[address]; old != nil {
+			m.addresses = append(m.addresses, old)
+			delete(having, address)
+		} else {
+			m.addresses = append(m.addresses, &poolerAddress{Address: address})
+		}
+	}
+}
+
+//Get will return the proxy server address by policy, the quarantined address is skipped
+func (m *MultiAddressPooler) Get(uri string) (address string, err error) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	var selected *poolerAddress
+	for i := range m.addresses {
+		current := m.addresses[(m.index+i)%len(m.addresses)]
+		if current.Quarantined {
+			continue
+		}
+		if selected == nil || (m.Policy == "least" && current.Used < selected.Used) {
+			selected = current
+		}
+		if m.Policy == "round" {
+			m.index = (m.index + i + 1) % len(m.addresses)
+			break
+		}
+	}
+	if selected == nil {
+		err = fmt.Errorf("no address available in %v addresses", len(m.addresses))
+		return
+	}
+	if m.Policy == "least" {
+		m.index = (m.index + 1) % len(m.addresses)
+	}
+	selected.Used++
+	selected.Total++
+	address = selected.Address
+	return
+}
+
+//Done will mark one address is free, the address is quarantined when err is CodeError of SocksCodeProxyFail or network error
+func (m *MultiAddressPooler) Done(address, uri string, err error) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	for _, current := range m.addresses {
+		if current.Address != address {
+			continue
+		}
+		if current.Used > 0 {
+			current.Used--
+		}
+		if quarantineError(err) && !current.Quarantined {
+			WarnLog("MultiAddressPooler address %v is quarantined by %v", address, err)
+			current.Quarantined = true
+		}
+		break
+	}
+}
+
+//quarantineError will return if the address should be quarantined by done error,
+//the code of CodeError is checked first, else the network error like connection refused or timeout is quarantined
+func quarantineError(err error) bool {
+	var coded *CodeError
+	if errors.As(err, &coded) {
+		return coded.Code() == SocksCodeProxyFail
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+//State will return the address state
+func (m *MultiAddressPooler) State() (state []xmap.M) {
+	m.lck.RLock()
+	defer m.lck.RUnlock()
+	for _, current := range m.addresses {
+		state = append(state, xmap.M{
+			"address":     current.Address,
+			"used":        current.Used,
+			"total":       current.Total,
+			"quarantined": current.Quarantined,
+		})
+	}
+	return
+}
+
+//probeTCP will probe address by tcp connect
+func (m *MultiAddressPooler) probeTCP(address string) (err error) {
+	if strings.Contains(address, "://") {
+		var remote *url.URL
+		remote, err = url.Parse(address)
+		if err != nil {
+			return
+		}
+		address = remote.Host
+	}
+	conn, err := net.DialTimeout("tcp", address, m.ProbeTimeout)
+	if err == nil {
+		conn.Close()
+	}
+	return
+}
+
+//probeAll will probe all quarantined address and return it to rotation when success
+func (m *MultiAddressPooler) probeAll() {
+	m.lck.RLock()
+	var quarantined []*poolerAddress
+	for _, current := range m.addresses {
+		if current.Quarantined {
+			quarantined = append(quarantined, current)
+		}
+	}
+	m.lck.RUnlock()
+	for _, current := range quarantined {
+		err := m.Probe(current.Address)
+		if err != nil {
+			DebugLog("MultiAddressPooler probe %v fail with %v", current.Address, err)
+			continue
+		}
+		InfoLog("MultiAddressPooler address %v is returned to rotation by probe success", current.Address)
+		m.lck.Lock()
+		current.Quarantined = false
+		m.lck.Unlock()
+	}
+}
+
+func (m *MultiAddressPooler) runner(addresses []string) {
+	defer m.waiter.Done()
+	prober := time.NewTicker(m.ProbeDelay)
+	defer prober.Stop()
+	reloader := time.NewTicker(m.Reload)
+	defer reloader.Stop()
+	for {
+		select {
+		case <-m.exiter:
+			return
+		case <-prober.C:
+			m.probeAll()
+		case <-reloader.C:
+			if len(m.File) < 1 {
+				continue
+			}
+			fileAddresses, err := m.readFile()
+			if err != nil {
+				WarnLog("MultiAddressPooler reload %v fail with %v", m.File, err)
+				continue
+			}
+			m.Set(append(append([]string{}, addresses...), fileAddresses...))
+		}
+	}
+}
+
+//Close will stop the background runner
+func (m *MultiAddressPooler) Close() (err error) {
+	select {
+	case m.exiter <- 1:
+		m.waiter.Wait()
+	default:
+	}
+	return
+}
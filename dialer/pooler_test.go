@@ -0,0 +1,200 @@
+package dialer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+func TestMultiAddressPooler(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conn.Close()
+		}
+	}()
+	alive := listener.Addr().String()
+	//round
+	pooler := NewMultiAddressPooler()
+	err := pooler.Bootstrap(xmap.M{
+		"addresses": []string{"socks5://" + alive, "a1", "a2"},
+		"probe":     50,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expect := range []string{"socks5://" + alive, "a1", "a2", "socks5://" + alive} {
+		address, err := pooler.Get("")
+		if err != nil || address != expect {
+			t.Errorf("%v,%v", err, address)
+			return
+		}
+		pooler.Done(address, "", nil)
+	}
+	//quarantine
+	proxyFail := &CodeError{Inner: fmt.Errorf("error"), ByteCode: SocksCodeProxyFail}
+	pooler.Done("a1", "", &CodeError{Inner: fmt.Errorf("error"), ByteCode: 0x01})
+	pooler.Done("a1", "", proxyFail)
+	pooler.Done("socks5://"+alive, "", proxyFail)
+	for i := 0; i < 3; i++ {
+		if address, err := pooler.Get(""); err != nil || address != "a2" {
+			t.Errorf("%v,%v", err, address)
+			return
+		}
+	}
+	pooler.Done("a2", "", fmt.Errorf("error"))
+	if address, err := pooler.Get(""); err != nil || address != "a2" {
+		t.Errorf("%v,%v", err, address)
+		return
+	}
+	//quarantine by dial error to closed address
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	_, dialErr := net.Dial("tcp", closed.Addr().String())
+	if dialErr == nil {
+		t.Error(dialErr)
+		return
+	}
+	pooler.Done("a2", "", dialErr)
+	if _, err = pooler.Get(""); err == nil {
+		t.Error(err)
+		return
+	}
+	//probe
+	time.Sleep(200 * time.Millisecond)
+	if address, err := pooler.Get(""); err != nil || address != "socks5://"+alive {
+		t.Errorf("%v,%v", err, address)
+		return
+	}
+	state := pooler.State()
+	if len(state) != 3 || state[0].Int64Def(0, "total") != 3 || state[1].Value("quarantined") != true {
+		t.Errorf("%v", state)
+		return
+	}
+	pooler.Close()
+	pooler.Close()
+	if err = pooler.probeTCP("socks5://%zz"); err == nil {
+		t.Error(err)
+		return
+	}
+	//least
+	pooler = NewMultiAddressPooler()
+	err = pooler.Bootstrap(xmap.M{
+		"addresses": []string{"a0", "a1", "a2"},
+		"policy":    "least",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a0, _ := pooler.Get("")
+	a1, _ := pooler.Get("")
+	pooler.Done(a0, "", nil)
+	a2, _ := pooler.Get("")
+	a3, _ := pooler.Get("")
+	if a0 != "a0" || a1 != "a1" || a2 != "a2" || a3 != "a0" {
+		t.Errorf("%v,%v,%v,%v", a0, a1, a2, a3)
+		return
+	}
+	pooler.Close()
+	//file
+	dir, _ := ioutil.TempDir("", "pooler")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "address")
+	ioutil.WriteFile(file, []byte("#comment\nf0\n\nf1\n"), os.ModePerm)
+	pooler = NewMultiAddressPooler()
+	err = pooler.Bootstrap(xmap.M{
+		"addresses":     []string{"a0"},
+		"address_file":  file,
+		"reload":        50,
+		"probe_timeout": 100,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pooler.Done("f1", "", proxyFail)
+	ioutil.WriteFile(file, []byte("f1\nf2\n"), os.ModePerm)
+	time.Sleep(200 * time.Millisecond)
+	state = pooler.State()
+	if len(state) != 3 || state[1].Str("address") != "f1" || state[1].Value("quarantined") != true || state[2].Str("address") != "f2" {
+		t.Errorf("%v", state)
+		return
+	}
+	os.Remove(file)
+	time.Sleep(100 * time.Millisecond)
+	if len(pooler.State()) != 3 {
+		t.Error("error")
+		return
+	}
+	pooler.Close()
+	//new pooler
+	if p, err := NewAddressPooler(xmap.M{"address": "a0"}); err != nil || p.(StringAddressPooler) != "a0" {
+		t.Errorf("%v,%v", err, p)
+		return
+	}
+	p, err := NewAddressPooler(xmap.M{"addresses": []string{"a0"}})
+	if err != nil || p.(*MultiAddressPooler) == nil {
+		t.Errorf("%v,%v", err, p)
+		return
+	}
+	p.(*MultiAddressPooler).Close()
+	if _, err = NewAddressPooler(xmap.M{"addresses": []string{"a0"}, "policy": "xx"}); err == nil {
+		t.Error(err)
+		return
+	}
+	//bootstrap error
+	for _, options := range []xmap.M{
+		{"addresses": []string{"a0"}, "policy": "xx"},
+		{"address_file": file},
+		{"addresses": []string{}},
+	} {
+		if err = NewMultiAddressPooler().Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+}
+
+func TestMultiAddressPoolerDialer(t *testing.T) {
+	echo, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer echo.Close()
+	server := runTestSocksServer(t, "", "")
+	defer server.Close()
+	for _, options := range []xmap.M{
+		{"id": "s", "type": "socks", "addresses": []string{"127.0.0.1:1", server.Addr().String()}},
+		{"id": "h", "type": "http", "addresses": []string{"127.0.0.1:1", server.Addr().String()}},
+	} {
+		dialer := NewDialer(options.Str("type"))
+		err := dialer.Bootstrap(options)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		dialer.Dial(1, "tcp://"+echo.Addr().String(), nil)
+		var pooler *MultiAddressPooler
+		switch d := dialer.(type) {
+		case *SocksProxyDialer:
+			pooler = d.Pooler.(*MultiAddressPooler)
+		case *HTTPProxyDialer:
+			pooler = d.Pooler.(*MultiAddressPooler)
+		}
+		if state := pooler.State(); state[0].Value("quarantined") != true {
+			t.Errorf("%v", state)
+			return
+		}
+		dialer.Shutdown()
+	}
+}
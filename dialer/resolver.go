@@ -0,0 +1,150 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+//Resolver is the custom dns resolver by static hosts and dns servers, the dns query can be sent by tcp through router uri
+type Resolver struct {
+	Servers  []string                                        //the dns server list, the system dns server is used when empty
+	Hosts    map[string][]net.IP                             //the static hosts
+	TCP      bool                                            //whether send dns query by tcp
+	Via      string                                          //the router uri to send dns query by tcp, like node1, it is dialed by node1->tcp://server
+	Timeout  time.Duration                                   //the dns server dial timeout
+	Dial     func(network, address string) (net.Conn, error) //the router dialer to dial via uri
+	Resolver *net.Resolver
+	next     uint32
+}
+
+//NewResolver will return new Resolver
+func NewResolver() (resolver *Resolver) {
+	resolver = &Resolver{
+		Hosts:   map[string][]net.IP{},
+		Timeout: 5 * time.Second,
+	}
+	resolver.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial:     resolver.dialServer,
+	}
+	return
+}
+
+//Bootstrap the resolver by options
+//
+//servers is dns server list, hosts is static hosts map to ip or ip list, tcp is whether query by tcp,
+//via is the router uri to query by tcp through router, timeout is dns server dial timeout in milliseconds
+func (r *Resolver) Bootstrap(options xmap.M) (err error) {
+	for _, server := range options.ArrayStrDef(nil, "servers") {
+		if _, _, serr := net.SplitHostPort(server); serr != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.Servers = append(r.Servers, server)
+	}
+	for host, value := range options.MapDef(xmap.M{}, "hosts") {
+		var addresses []string
+		switch value := value.(type) {
+		case string:
+			addresses = strings.Split(value, ",")
+		case []string:
+			addresses = value
+		case []interface{}:
+			for _, v := range value {
+				addresses = append(addresses, fmt.Sprintf("%v", v))
+			}
+		}
+		var ips []net.IP
+		for _, address := range addresses {
+			ip := net.ParseIP(strings.TrimSpace(address))
+			if ip == nil {
+				err = fmt.Errorf("invalid ip %v for host %v", address, host)
+				return
+			}
+			ips = append(ips, ip)
+		}
+		r.Hosts[strings.ToLower(host)] = ips
+	}
+	r.TCP = options.IntDef(0, "tcp") > 0
+	r.Via = options.Str("via")
+	if len(r.Via) > 0 && len(r.Servers) < 1 {
+		err = fmt.Errorf("the resolver servers is required when via is configured")
+		return
+	}
+	if timeout := options.Int64Def(0, "timeout"); timeout > 0 {
+		r.Timeout = time.Duration(timeout) * time.Millisecond
+	}
+	return
+}
+
+//Static will return the ip of host in static hosts
+func (r *Resolver) Static(host string) (ips []net.IP) {
+	ips = r.Hosts[strings.ToLower(strings.TrimSuffix(host, "."))]
+	return
+}
+
+//Addresses will return the ip:port list of host:port by static hosts, or the address self when not matched
+func (r *Resolver) Addresses(address string) (addresses []string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return []string{address}
+	}
+	for _, ip := range r.Static(host) {
+		addresses = append(addresses, net.JoinHostPort(ip.String(), port))
+	}
+	if len(addresses) < 1 {
+		addresses = []string{address}
+	}
+	return
+}
+
+//LookupIP will lookup the ip of host by static hosts first, then dns servers, the network is ip/ip4/ip6
+func (r *Resolver) LookupIP(ctx context.Context, network, host string) (ips []net.IP, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if static := r.Static(host); len(static) > 0 {
+		ips = static
+	} else {
+		ips, err = r.Resolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return
+		}
+	}
+	var matched []net.IP
+	for _, ip := range ips {
+		if network == "ip" || (network == "ip4" && ip.To4() != nil) || (network == "ip6" && ip.To4() == nil) {
+			matched = append(matched, ip)
+		}
+	}
+	ips = matched
+	if len(ips) < 1 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return
+}
+
+//dialServer will dial to dns server by servers/tcp/via, it is used by net.Resolver
+func (r *Resolver) dialServer(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	if len(r.Servers) > 0 {
+		address = r.Servers[int(atomic.AddUint32(&r.next, 1)-1)%len(r.Servers)]
+	}
+	if r.TCP || len(r.Via) > 0 {
+		network = "tcp"
+	}
+	if len(r.Via) > 0 {
+		if r.Dial == nil {
+			err = fmt.Errorf("dial via %v is not supported", r.Via)
+			return
+		}
+		conn, err = r.Dial("tcp", r.Via+"->tcp://"+address)
+		return
+	}
+	dialer := net.Dialer{Timeout: r.Timeout}
+	conn, err = dialer.DialContext(ctx, network, address)
+	return
+}
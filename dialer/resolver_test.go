@@ -0,0 +1,267 @@
+package dialer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/codingeasygo/util/xmap"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+//testDNSRecords is the A/TXT record of test dns server
+var testDNSRecords = map[string][]string{
+	"test.bsck.": {"10.1.1.1"},
+	"echo.bsck.": {"127.0.0.1"},
+	"txt.bsck.":  {"txt:hello"},
+}
+
+func testDNSAnswer(request []byte) (response []byte, err error) {
+	var msg dnsmessage.Message
+	err = msg.Unpack(request)
+	if err != nil || len(msg.Questions) < 1 {
+		return
+	}
+	question := msg.Questions[0]
+	msg.Header.Response = true
+	msg.Header.RCode = dnsmessage.RCodeSuccess
+	header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+	for _, record := range testDNSRecords[strings.ToLower(question.Name.String())] {
+		if strings.HasPrefix(record, "txt:") {
+			if question.Type == dnsmessage.TypeTXT {
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.TXTResource{TXT: []string{strings.TrimPrefix(record, "txt:")}}})
+			}
+			continue
+		}
+		if question.Type == dnsmessage.TypeA {
+			a := &dnsmessage.AResource{}
+			copy(a.A[:], net.ParseIP(record).To4())
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: a})
+		}
+	}
+	if _, ok := testDNSRecords[strings.ToLower(question.Name.String())]; !ok {
+		msg.Header.RCode = dnsmessage.RCodeNameError
+	}
+	response, err = msg.Pack()
+	return
+}
+
+func runTestDNSServer(t *testing.T) (udp net.PacketConn, tcp net.Listener) {
+	udp, _ = net.ListenPacket("udp", "127.0.0.1:0")
+	tcp, _ = net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := udp.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			if response, err := testDNSAnswer(buf[:n]); err == nil {
+				udp.WriteTo(response, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					length := make([]byte, 2)
+					if _, err := io.ReadFull(conn, length); err != nil {
+						return
+					}
+					request := make([]byte, binary.BigEndian.Uint16(length))
+					if _, err := io.ReadFull(conn, request); err != nil {
+						return
+					}
+					response, err := testDNSAnswer(request)
+					if err != nil {
+						return
+					}
+					binary.BigEndian.PutUint16(length, uint16(len(response)))
+					conn.Write(append(length, response...))
+				}
+			}()
+		}
+	}()
+	return
+}
+
+func TestResolver(t *testing.T) {
+	udp, tcp := runTestDNSServer(t)
+	defer udp.Close()
+	defer tcp.Close()
+	lookup := func(resolver *Resolver, network, host string) (ips string, err error) {
+		found, err := resolver.LookupIP(context.Background(), network, host)
+		ips = fmt.Sprintf("%v", found)
+		return
+	}
+	//udp
+	resolver := NewResolver()
+	err := resolver.Bootstrap(xmap.M{
+		"servers": []string{udp.LocalAddr().String()},
+		"hosts": xmap.M{
+			"static.bsck": "10.2.2.2",
+			"Multi.bsck":  []interface{}{"10.3.3.3", "::1"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, c := range [][]string{
+		{"ip", "test.bsck", "[10.1.1.1]"},
+		{"ip", "static.bsck", "[10.2.2.2]"},
+		{"ip", "multi.bsck.", "[10.3.3.3 ::1]"},
+		{"ip4", "multi.bsck", "[10.3.3.3]"},
+		{"ip6", "multi.bsck", "[::1]"},
+		{"ip", "127.0.0.1", "[127.0.0.1]"},
+	} {
+		if ips, err := lookup(resolver, c[0], c[1]); err != nil || ips != c[2] {
+			t.Errorf("%v,%v,%v", c, err, ips)
+			return
+		}
+	}
+	if _, err = lookup(resolver, "ip6", "static.bsck"); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = lookup(resolver, "ip", "none.bsck"); err == nil {
+		t.Error(err)
+		return
+	}
+	if addresses := resolver.Addresses("multi.bsck:80"); len(addresses) != 2 || addresses[1] != "[::1]:80" {
+		t.Errorf("%v", addresses)
+		return
+	}
+	if addresses := resolver.Addresses("test.bsck:80"); len(addresses) != 1 || addresses[0] != "test.bsck:80" {
+		t.Errorf("%v", addresses)
+		return
+	}
+	if addresses := resolver.Addresses("test.bsck"); len(addresses) != 1 || addresses[0] != "test.bsck" {
+		t.Errorf("%v", addresses)
+		return
+	}
+	//tcp
+	resolver = NewResolver()
+	err = resolver.Bootstrap(xmap.M{
+		"servers": []string{tcp.Addr().String()},
+		"tcp":     1,
+		"timeout": 1000,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if ips, err := lookup(resolver, "ip", "test.bsck"); err != nil || ips != "[10.1.1.1]" {
+		t.Errorf("%v,%v", err, ips)
+		return
+	}
+	//via
+	resolver = NewResolver()
+	err = resolver.Bootstrap(xmap.M{
+		"servers": []string{tcp.Addr().String()},
+		"via":     "node1",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err = lookup(resolver, "ip", "test.bsck"); err == nil {
+		t.Error(err)
+		return
+	}
+	var dialed string
+	resolver.Dial = func(network, address string) (net.Conn, error) {
+		dialed = address
+		return net.Dial(network, strings.TrimPrefix(address, "node1->tcp://"))
+	}
+	if ips, err := lookup(resolver, "ip", "test.bsck"); err != nil || ips != "[10.1.1.1]" || dialed != "node1->tcp://"+tcp.Addr().String() {
+		t.Errorf("%v,%v,%v", err, ips, dialed)
+		return
+	}
+	//default port
+	resolver = NewResolver()
+	resolver.Bootstrap(xmap.M{"servers": []string{"127.0.0.1"}})
+	if resolver.Servers[0] != "127.0.0.1:53" {
+		t.Errorf("%v", resolver.Servers)
+		return
+	}
+	//bootstrap error
+	for _, options := range []xmap.M{
+		{"hosts": xmap.M{"a": "xx"}},
+		{"via": "node1"},
+	} {
+		if err = NewResolver().Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+}
+
+func TestTCPDialerResolver(t *testing.T) {
+	udp, tcp := runTestDNSServer(t)
+	defer udp.Close()
+	defer tcp.Close()
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	pool := NewPool("test")
+	pool.RouterDial = func(network, address string) (net.Conn, error) {
+		return net.Dial(network, strings.TrimPrefix(address, "node1->tcp://"))
+	}
+	err := pool.Bootstrap(xmap.M{
+		"tcp": xmap.M{
+			"deny": []string{"10.0.0.0/8"},
+			"resolver": xmap.M{
+				"servers": []string{tcp.Addr().String()},
+				"via":     "node1",
+				"hosts": xmap.M{
+					"static.bsck": []string{"::1", "127.0.0.1"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, uri := range []string{"tcp://echo.bsck:" + port, "tcp://static.bsck:" + port} {
+		conn, err := pool.Dial(10, uri, nil)
+		if err != nil {
+			t.Errorf("%v,%v", uri, err)
+			return
+		}
+		conn.Close()
+	}
+	if _, err = pool.Dial(10, "tcp://test.bsck:"+port, nil); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = pool.Dial(10, "tcp://none.bsck:"+port, nil); err == nil {
+		t.Error(err)
+		return
+	}
+	//error
+	if err = NewTCPDialer().Bootstrap(xmap.M{"resolver": xmap.M{"via": "node1"}}); err == nil {
+		t.Error(err)
+		return
+	}
+}
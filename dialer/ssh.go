@@ -1 +1,340 @@
 package dialer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingeasygo/util/xmap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//sshClient is the cached ssh client to one jump host
+type sshClient struct {
+	*ssh.Client
+	key string
+	lck sync.Mutex
+}
+
+//SSHDialer is an implementation of the Dialer interface for dial by direct-tcpip channel of ssh server,
+//the uri is ssh://user@jumphost:22/tcp://target:port, the client connection is cached by jump host.
+type SSHDialer struct {
+	ID              string
+	User            string
+	Auth            []ssh.AuthMethod
+	HostKeyCallback ssh.HostKeyCallback
+	Timeout         time.Duration
+	Keepalive       time.Duration
+	Hosts           []string //the allowed jump host address, all is allowed when empty and matcher is configured
+	clients         map[string]*sshClient
+	clientsLck      sync.RWMutex
+	matcher         *regexp.Regexp
+	conf            xmap.M
+}
+
+//NewSSHDialer will return new SSHDialer
+func NewSSHDialer() *SSHDialer {
+	return &SSHDialer{
+		Timeout:    10 * time.Second,
+		Keepalive:  30 * time.Second,
+		clients:    map[string]*sshClient{},
+		clientsLck: sync.RWMutex{},
+		matcher:    regexp.MustCompile("^ssh://.*$"),
+		conf:       xmap.M{},
+	}
+}
+
+//Name will return dialer name
+func (s *SSHDialer) Name() string {
+	return s.ID
+}
+
+//loadSSHSigner will load signer by key file path or key content
+func loadSSHSigner(key, passphrase string) (signer ssh.Signer, err error) {
+	data := []byte(key)
+	if !strings.HasPrefix(key, "-----BEGIN") {
+		data, err = ioutil.ReadFile(key)
+		if err != nil {
+			return
+		}
+	}
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+	return
+}
+
+//Bootstrap the dialer by options
+//
+//id is required dialer id
+//
+//user is default user when uri is not having user
+//
+//key/keys is private key file path or content, passphrase is the private key passphrase
+//
+//password is the password auth
+//
+//known_hosts is known_hosts file path to verify host key, insecure=1 will skip verify when known_hosts is not set,
+//the password is not allowed with insecure
+//
+//hosts is the allowed jump host address list, the port is 22 when not set, it is required when matcher is not configured
+//
+//timeout/keepalive is the connect timeout and keepalive delay in milliseconds
+func (s *SSHDialer) Bootstrap(options xmap.M) (err error) {
+	s.ID = options.Str("id")
+	if len(s.ID) < 1 {
+		return fmt.Errorf("the dialer id is required")
+	}
+	s.conf = options
+	s.User = options.Str("user")
+	keys := options.ArrayStrDef(nil, "keys")
+	if key := options.Str("key"); len(key) > 0 {
+		keys = append(keys, key)
+	}
+	var signers []ssh.Signer
+	for _, key := range keys {
+		signer, err := loadSSHSigner(key, options.Str("passphrase"))
+		if err != nil {
+			return fmt.Errorf("load ssh key fail with %v", err)
+		}
+		signers = append(signers, signer)
+	}
+	s.Auth = nil
+	if len(signers) > 0 {
+		s.Auth = append(s.Auth, ssh.PublicKeys(signers...))
+	}
+	if password := options.Str("password"); len(password) > 0 {
+		s.Auth = append(s.Auth, ssh.Password(password))
+	}
+	if len(s.Auth) < 1 {
+		return fmt.Errorf("the key or password is required")
+	}
+	if knownHosts := options.Str("known_hosts"); len(knownHosts) > 0 {
+		s.HostKeyCallback, err = knownhosts.New(knownHosts)
+		if err != nil {
+			return
+		}
+	} else if options.IntDef(0, "insecure") > 0 {
+		if len(options.Str("password")) > 0 {
+			return fmt.Errorf("the password is not allowed with insecure")
+		}
+		WarnLog("SSHDialer(%v) host key verify is skipped by insecure", s.ID)
+		s.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		return fmt.Errorf("the known_hosts is required")
+	}
+	if timeout := options.Int64Def(0, "timeout"); timeout > 0 {
+		s.Timeout = time.Duration(timeout) * time.Millisecond
+	}
+	if keepalive := options.Int64Def(0, "keepalive"); keepalive > 0 {
+		s.Keepalive = time.Duration(keepalive) * time.Millisecond
+	}
+	s.Hosts = nil
+	for _, host := range options.ArrayStrDef(nil, "hosts") {
+		if _, _, serr := net.SplitHostPort(host); serr != nil {
+			host = net.JoinHostPort(host, "22")
+		}
+		s.Hosts = append(s.Hosts, host)
+	}
+	matcher := options.Str("matcher")
+	if len(matcher) > 0 {
+		s.matcher, err = regexp.Compile(matcher)
+	} else if len(s.Hosts) < 1 {
+		err = fmt.Errorf("the hosts or matcher is required")
+	}
+	return
+}
+
+//Allowed will check the jump host is allowed by hosts, it return CodeError of 0x02 when denied
+func (s *SSHDialer) Allowed(jump string) (err error) {
+	if len(s.Hosts) < 1 {
+		return
+	}
+	for _, host := range s.Hosts {
+		if host == jump {
+			return
+		}
+	}
+	err = &CodeError{Inner: fmt.Errorf("jump host %v is not allowed", jump), ByteCode: 0x02}
+	return
+}
+
+//Options is options getter
+func (s *SSHDialer) Options() xmap.M {
+	return s.conf
+}
+
+//Matched will return whether the uri is ssh jump uri
+func (s *SSHDialer) Matched(uri string) bool {
+	return s.matcher.MatchString(uri)
+}
+
+//parseSSHURI will return the jump host and target address by ssh://user@jumphost:22/tcp://target:port
+func parseSSHURI(uri string) (user, jump, target string, err error) {
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if remote.User != nil {
+		user = remote.User.Username()
+	}
+	jump = remote.Host
+	if len(remote.Port()) < 1 {
+		jump += ":22"
+	}
+	target = strings.TrimPrefix(remote.Path, "/")
+	if strings.Contains(target, "://") {
+		var targetURL *url.URL
+		targetURL, err = url.Parse(target)
+		if err != nil {
+			return
+		}
+		target = targetURL.Host
+	}
+	if len(remote.Hostname()) < 1 || len(target) < 1 {
+		err = fmt.Errorf("invalid ssh uri %v, it must be ssh://user@jumphost:22/tcp://target:port", uri)
+	}
+	return
+}
+
+//connect will return the cached client or connect new client to jump host
+func (s *SSHDialer) connect(user, jump string) (client *sshClient, conn *ssh.Client, err error) {
+	key := user + "@" + jump
+	s.clientsLck.Lock()
+	client = s.clients[key]
+	if client == nil {
+		client = &sshClient{key: key, lck: sync.Mutex{}}
+		s.clients[key] = client
+	}
+	s.clientsLck.Unlock()
+	client.lck.Lock()
+	defer client.lck.Unlock()
+	if client.Client != nil {
+		conn = client.Client
+		return
+	}
+	InfoLog("SSHDialer(%v) start connect to %v", s.ID, key)
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            s.Auth,
+		HostKeyCallback: s.HostKeyCallback,
+		Timeout:         s.Timeout,
+	}
+	conn, err = ssh.Dial("tcp", jump, config)
+	if err != nil {
+		WarnLog("SSHDialer(%v) connect to %v fail with %v", s.ID, key, err)
+		return
+	}
+	client.Client = conn
+	go s.keepalive(client, conn)
+	return
+}
+
+//keepalive will send keepalive request to jump host and remove client when it is disconnected
+func (s *SSHDialer) keepalive(client *sshClient, conn *ssh.Client) {
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Wait()
+	}()
+	ticker := time.NewTicker(s.Keepalive)
+	defer ticker.Stop()
+	var err error
+	for running := true; running; {
+		select {
+		case err = <-done:
+			running = false
+		case <-ticker.C:
+			if _, _, err = conn.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				running = false
+			}
+		}
+	}
+	conn.Close()
+	s.remove(client, conn)
+	InfoLog("SSHDialer(%v) the connection to %v is closed by %v", s.ID, client.key, err)
+}
+
+//remove will remove the cached client when it is the conn
+func (s *SSHDialer) remove(client *sshClient, conn *ssh.Client) {
+	client.lck.Lock()
+	if client.Client == conn {
+		client.Client = nil
+	}
+	client.lck.Unlock()
+}
+
+//Dial one connection by uri
+func (s *SSHDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	user, jump, target, err := parseSSHURI(uri)
+	if err != nil {
+		return
+	}
+	if len(user) < 1 {
+		user = s.User
+	}
+	err = s.Allowed(jump)
+	if err != nil {
+		WarnLog("SSHDialer(%v) dial(%v) to %v is denied by %v", s.ID, sid, uri, err)
+		return
+	}
+	var conn net.Conn
+	for i := 0; i < 2; i++ {
+		var client *sshClient
+		var sshConn *ssh.Client
+		client, sshConn, err = s.connect(user, jump)
+		if err != nil {
+			return
+		}
+		conn, err = sshConn.Dial("tcp", target)
+		if err == nil {
+			break
+		}
+		if _, ok := err.(*ssh.OpenChannelError); ok {
+			//the target is rejected by jump host
+			return
+		}
+		//the cached connection may be broken, close it and retry by new connection
+		WarnLog("SSHDialer(%v) dial to %v by %v fail with %v, will retry", s.ID, target, client.key, err)
+		sshConn.Close()
+		s.remove(client, sshConn)
+	}
+	if err != nil {
+		return
+	}
+	DebugLog("SSHDialer(%v) dial to %v by %v@%v success", s.ID, target, user, jump)
+	raw = NewCopyPipable(conn)
+	if pipe != nil {
+		assert(raw.Pipe(pipe) == nil)
+	}
+	return
+}
+
+//Shutdown will close all cached client
+func (s *SSHDialer) Shutdown() (err error) {
+	s.clientsLck.Lock()
+	clients := s.clients
+	s.clients = map[string]*sshClient{}
+	s.clientsLck.Unlock()
+	for _, client := range clients {
+		client.lck.Lock()
+		if client.Client != nil {
+			client.Client.Close()
+			client.Client = nil
+		}
+		client.lck.Unlock()
+	}
+	return
+}
+
+func (s *SSHDialer) String() string {
+	return fmt.Sprintf("SSHDialer-%v", s.ID)
+}
@@ -1 +1,278 @@
 package dialer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingeasygo/util/xmap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestSSHKey() (signer ssh.Signer, data []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	signer, _ = ssh.NewSignerFromKey(key)
+	return
+}
+
+//runTestSSHServer will run ssh server which only supports direct-tcpip channel
+func runTestSSHServer(t *testing.T, hostKey ssh.Signer, authorized ssh.PublicKey) (listener net.Listener) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "test" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("denied")
+		},
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "test" && string(password) == "123" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for channel := range channels {
+					if channel.ChannelType() != "direct-tcpip" {
+						channel.Reject(ssh.UnknownChannelType, "not supported")
+						continue
+					}
+					var payload struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					ssh.Unmarshal(channel.ExtraData(), &payload)
+					target, err := net.Dial("tcp", fmt.Sprintf("%v:%v", payload.Host, payload.Port))
+					if err != nil {
+						channel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, reqs, _ := channel.Accept()
+					go ssh.DiscardRequests(reqs)
+					go func() {
+						io.Copy(ch, target)
+						ch.Close()
+					}()
+					go func() {
+						io.Copy(target, ch)
+						target.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return
+}
+
+func TestSSHDialer(t *testing.T) {
+	echo, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	hostKey, _ := newTestSSHKey()
+	clientKey, clientKeyData := newTestSSHKey()
+	server := runTestSSHServer(t, hostKey, clientKey.PublicKey())
+	defer server.Close()
+	dir, _ := ioutil.TempDir("", "ssh")
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "id_ecdsa")
+	ioutil.WriteFile(keyFile, clientKeyData, os.ModePerm)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{server.Addr().String()}, hostKey.PublicKey())+"\n"), os.ModePerm)
+	//
+	dialer := NewSSHDialer()
+	err := dialer.Bootstrap(xmap.M{
+		"id":          "ssh",
+		"user":        "test",
+		"key":         keyFile,
+		"known_hosts": knownHosts,
+		"hosts":       []string{server.Addr().String()},
+		"timeout":     1000,
+		"keepalive":   1000,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	uri := fmt.Sprintf("ssh://test@%v/tcp://%v", server.Addr(), echo.Addr())
+	if !dialer.Matched(uri) || dialer.Matched("tcp://127.0.0.1:22") || dialer.Name() != "ssh" || dialer.Options() == nil || dialer.String() != "SSHDialer-ssh" {
+		t.Error("error")
+		return
+	}
+	for i := 0; i < 3; i++ {
+		conn, err := dialer.Dial(1, uri, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil || string(buf) != "abc" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+		conn.Close()
+	}
+	if len(dialer.clients) != 1 {
+		t.Errorf("%v", len(dialer.clients))
+		return
+	}
+	//pipe and default user
+	piped, pipe, _ := CreatePipedConn()
+	_, err = dialer.Dial(1, fmt.Sprintf("ssh://%v/%v", server.Addr(), echo.Addr()), pipe)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(piped, "123")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(piped, buf)
+	if err != nil || string(buf) != "123" {
+		t.Errorf("%v,%v", err, string(buf))
+		return
+	}
+	piped.Close()
+	//broken cached connection is reconnected
+	for _, client := range dialer.clients {
+		client.Client.Close()
+	}
+	conn, err := dialer.Dial(1, uri, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	//target rejected
+	_, err = dialer.Dial(1, fmt.Sprintf("ssh://test@%v/tcp://127.0.0.1:1", server.Addr()), nil)
+	if _, ok := err.(*ssh.OpenChannelError); !ok {
+		t.Error(err)
+		return
+	}
+	//jump host not allowed
+	_, err = dialer.Dial(1, fmt.Sprintf("ssh://test@127.0.0.1/tcp://%v", echo.Addr()), nil)
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != 0x02 {
+		t.Error(err)
+		return
+	}
+	//auth fail
+	_, err = dialer.Dial(1, fmt.Sprintf("ssh://xx@%v/tcp://%v", server.Addr(), echo.Addr()), nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//invalid uri
+	for _, uri := range []string{"ssh://%zz", "ssh://test@127.0.0.1", "ssh:///tcp://127.0.0.1:22", "ssh://127.0.0.1/tcp://%zz"} {
+		if _, err = dialer.Dial(1, uri, nil); err == nil {
+			t.Error(uri)
+			return
+		}
+	}
+	dialer.Shutdown()
+	//password
+	dialer = NewSSHDialer()
+	err = dialer.Bootstrap(xmap.M{
+		"id":          "ssh",
+		"password":    "123",
+		"known_hosts": knownHosts,
+		"matcher":     "^ssh://.*$",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err = dialer.Dial(1, uri, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	dialer.Shutdown()
+	//insecure
+	dialer = NewSSHDialer()
+	err = dialer.Bootstrap(xmap.M{
+		"id":       "ssh",
+		"key":      keyFile,
+		"insecure": 1,
+		"hosts":    []string{server.Addr().String()},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err = dialer.Dial(1, uri, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	dialer.Shutdown()
+	//host key not matched
+	otherKey, _ := newTestSSHKey()
+	ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{server.Addr().String()}, otherKey.PublicKey())+"\n"), os.ModePerm)
+	dialer = NewSSHDialer()
+	dialer.Bootstrap(xmap.M{"id": "ssh", "key": string(clientKeyData), "known_hosts": knownHosts, "hosts": []string{server.Addr().String()}})
+	if _, err = dialer.Dial(1, uri, nil); err == nil {
+		t.Error(err)
+		return
+	}
+	//bootstrap error
+	for _, options := range []xmap.M{
+		{},
+		{"id": "ssh"},
+		{"id": "ssh", "key": "none"},
+		{"id": "ssh", "key": keyFile, "passphrase": "xx"},
+		{"id": "ssh", "key": keyFile},
+		{"id": "ssh", "key": keyFile, "known_hosts": "none"},
+		{"id": "ssh", "key": keyFile, "insecure": 1, "matcher": "["},
+		{"id": "ssh", "key": keyFile, "insecure": 1},
+		{"id": "ssh", "password": "123", "insecure": 1, "matcher": ".*"},
+	} {
+		if err = NewSSHDialer().Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+	if NewDialer("ssh") == nil {
+		t.Error("error")
+		return
+	}
+}
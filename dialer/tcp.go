@@ -1,16 +1,171 @@
 package dialer
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/codingeasygo/util/xmap"
 )
 
+//TCPNetworks is the network list to check the destination address is allowed
+type TCPNetworks []*net.IPNet
+
+//ParseTCPNetworks will parse the CIDR list, the ip without mask is parsed as single address
+func ParseTCPNetworks(cidrs []string) (networks TCPNetworks, err error) {
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				err = fmt.Errorf("invalid ip %v", cidr)
+				return
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				networks = append(networks, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+		var network *net.IPNet
+		_, network, err = net.ParseCIDR(cidr)
+		if err != nil {
+			return
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+//Contains will return whether the ip is in one of network
+func (t TCPNetworks) Contains(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//TCPPorts is the port range list to check the destination port is allowed
+type TCPPorts [][2]int
+
+//ParseTCPPorts will parse the port list, the port can be single like 80 or range like 8000-9000
+func ParseTCPPorts(ports []string) (parsed TCPPorts, err error) {
+	for _, port := range ports {
+		parts := strings.SplitN(strings.TrimSpace(port), "-", 2)
+		var begin, end int
+		begin, err = strconv.Atoi(parts[0])
+		if err != nil {
+			return
+		}
+		end = begin
+		if len(parts) > 1 {
+			end, err = strconv.Atoi(parts[1])
+			if err != nil {
+				return
+			}
+		}
+		if begin < 0 || end > 65535 || begin > end {
+			err = fmt.Errorf("invalid port %v", port)
+			return
+		}
+		parsed = append(parsed, [2]int{begin, end})
+	}
+	return
+}
+
+//Contains will return whether the port is in one of port range
+func (t TCPPorts) Contains(port int) bool {
+	for _, r := range t {
+		if r[0] <= port && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+//TCPOptions is the connect options of TCPDialer
+type TCPOptions struct {
+	Timeout   time.Duration //the connect timeout, zero is no timeout
+	KeepAlive time.Duration //the keepalive period, zero is system default, negative is disabled
+	Fallback  time.Duration //the delay before fallback to other ip family by happy-eyeballs, zero is 300ms, negative is disabled
+	NoDelay   bool          //whether set TCP_NODELAY, default is true
+	Mark      int           //the SO_MARK on linux
+	Interface string        //the interface to bind by SO_BINDTODEVICE on linux
+}
+
+//parseTCPDuration will parse the duration like 3s or 3000 in milliseconds
+func parseTCPDuration(value string) (duration time.Duration, err error) {
+	if ms, perr := strconv.ParseInt(value, 10, 64); perr == nil {
+		duration = time.Duration(ms) * time.Millisecond
+		return
+	}
+	duration, err = time.ParseDuration(value)
+	return
+}
+
+//Parse will parse the options which can be overrided by uri, the option which is not exists is kept
+func (t *TCPOptions) Parse(get func(key string) string) (err error) {
+	for _, duration := range []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"timeout", &t.Timeout},
+		{"keepalive", &t.KeepAlive},
+		{"fallback", &t.Fallback},
+	} {
+		if value := get(duration.key); len(value) > 0 {
+			*duration.value, err = parseTCPDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %v %v", duration.key, value)
+			}
+		}
+	}
+	if value := get("nodelay"); len(value) > 0 {
+		t.NoDelay = value == "1" || value == "true"
+	}
+	return
+}
+
+//ParseConfig will parse all options from dialer configure, the mark/interface is only configurable here,
+//so the routing of exit node can't be changed by remote uri
+func (t *TCPOptions) ParseConfig(get func(key string) string) (err error) {
+	err = t.Parse(get)
+	if err != nil {
+		return
+	}
+	if value := get("mark"); len(value) > 0 {
+		t.Mark, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid mark %v", value)
+		}
+	}
+	if value := get("interface"); len(value) > 0 {
+		t.Interface = value
+	}
+	if !socketOptionSupported && (t.Mark > 0 || len(t.Interface) > 0) {
+		err = controlSocket(nil, t.Mark, t.Interface)
+	}
+	return
+}
+
 //TCPDialer is an implementation of the Dialer interface for dial tcp connections.
 type TCPDialer struct {
+	TCPOptions
+	Allow       TCPNetworks //the allowed destination network, all is allowed when empty
+	Deny        TCPNetworks //the denied destination network, it is checked before allow
+	AllowPorts  TCPPorts    //the allowed destination port, all is allowed when empty
+	DenyPorts   TCPPorts    //the denied destination port, it is checked before allow
+	Resolver    *Resolver   //the custom resolver, the system resolver is used when nil
 	portMatcher *regexp.Regexp
 	conf        xmap.M
 }
@@ -18,6 +173,7 @@ type TCPDialer struct {
 //NewTCPDialer will return new TCPDialer
 func NewTCPDialer() *TCPDialer {
 	return &TCPDialer{
+		TCPOptions:  TCPOptions{NoDelay: true},
 		portMatcher: regexp.MustCompile("^.*:[0-9]+$"),
 		conf:        xmap.M{},
 	}
@@ -29,9 +185,37 @@ func (t *TCPDialer) Name() string {
 }
 
 //Bootstrap the dialer.
-func (t *TCPDialer) Bootstrap(options xmap.M) error {
+//
+//allow/deny is the destination CIDR list, allow_ports/deny_ports is the destination port list,
+//they are checked on the resolved address before connect.
+//
+//timeout/keepalive/fallback/nodelay/mark/interface/bind is the connect options, only timeout/keepalive/fallback/nodelay/bind can be overrided by uri query.
+//
+//resolver is the custom resolver options, see Resolver.Bootstrap
+func (t *TCPDialer) Bootstrap(options xmap.M) (err error) {
 	t.conf = options
-	return nil
+	if options == nil {
+		return
+	}
+	err = t.TCPOptions.ParseConfig(func(key string) string { return options.Str(key) })
+	if err != nil {
+		return
+	}
+	t.Allow, err = ParseTCPNetworks(options.ArrayStrDef(nil, "allow"))
+	if err == nil {
+		t.Deny, err = ParseTCPNetworks(options.ArrayStrDef(nil, "deny"))
+	}
+	if err == nil {
+		t.AllowPorts, err = ParseTCPPorts(options.ArrayStrDef(nil, "allow_ports"))
+	}
+	if err == nil {
+		t.DenyPorts, err = ParseTCPPorts(options.ArrayStrDef(nil, "deny_ports"))
+	}
+	if resolver := options.Map("resolver"); err == nil && resolver != nil {
+		t.Resolver = NewResolver()
+		err = t.Resolver.Bootstrap(resolver)
+	}
+	return
 }
 
 //Options is options getter
@@ -45,12 +229,45 @@ func (t *TCPDialer) Matched(uri string) bool {
 	return err == nil
 }
 
+//Allowed will check the resolved address is allowed by allow/deny networks and ports, it return CodeError of 0x02 when denied
+func (t *TCPDialer) Allowed(address string) (err error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		err = fmt.Errorf("invalid ip %v", host)
+		return
+	}
+	portNum, _ := strconv.Atoi(port)
+	if t.Deny.Contains(ip) || (len(t.Allow) > 0 && !t.Allow.Contains(ip)) {
+		err = &CodeError{Inner: fmt.Errorf("destination %v is not allowed", address), ByteCode: 0x02}
+		return
+	}
+	if t.DenyPorts.Contains(portNum) || (len(t.AllowPorts) > 0 && !t.AllowPorts.Contains(portNum)) {
+		err = &CodeError{Inner: fmt.Errorf("destination %v is not allowed", address), ByteCode: 0x02}
+		return
+	}
+	return
+}
+
 //Dial one connection by uri
 func (t *TCPDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
 	remote, err := url.Parse(uri)
 	if err == nil {
-		var dialer net.Dialer
-		bind := remote.Query().Get("bind")
+		query := remote.Query()
+		options := t.TCPOptions
+		err = options.Parse(query.Get)
+		if err != nil {
+			return
+		}
+		dialer := net.Dialer{
+			Timeout:       options.Timeout,
+			KeepAlive:     options.KeepAlive,
+			FallbackDelay: options.Fallback,
+		}
+		bind := query.Get("bind")
 		if len(bind) < 1 && t.conf != nil {
 			bind = t.conf.Str("bind")
 		}
@@ -60,9 +277,24 @@ func (t *TCPDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 				return
 			}
 		}
-		network := remote.Scheme
+		checking := len(t.Allow) > 0 || len(t.Deny) > 0 || len(t.AllowPorts) > 0 || len(t.DenyPorts) > 0
+		if checking || options.Mark > 0 || len(options.Interface) > 0 {
+			//check on the address which is connecting, so the resolved address can't be changed after check
+			dialer.Control = func(network, address string, c syscall.RawConn) (err error) {
+				if checking {
+					err = t.Allowed(address)
+				}
+				if err == nil && (options.Mark > 0 || len(options.Interface) > 0) {
+					err = controlSocket(c, options.Mark, options.Interface)
+				}
+				return
+			}
+		}
+		network := "tcp"
 		host := remote.Host
-		switch network {
+		switch remote.Scheme {
+		case "tcp4", "tcp6":
+			network = remote.Scheme
 		case "http":
 			if !t.portMatcher.MatchString(host) {
 				host += ":80"
@@ -72,14 +304,32 @@ func (t *TCPDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 				host += ":443"
 			}
 		}
+		addresses := []string{host}
+		if t.Resolver != nil {
+			dialer.Resolver = t.Resolver.Resolver
+			addresses = t.Resolver.Addresses(host)
+		}
 		var basic net.Conn
-		basic, err = dialer.Dial("tcp", host)
+		for _, address := range addresses {
+			basic, err = dialer.Dial(network, address)
+			if err == nil {
+				break
+			}
+		}
 		if err == nil {
+			if conn, ok := basic.(*net.TCPConn); ok && !options.NoDelay {
+				conn.SetNoDelay(false)
+			}
 			raw = NewCopyPipable(basic)
 			if pipe != nil {
 				assert(raw.Pipe(pipe) == nil)
 			}
 		}
+		var denied *CodeError
+		if errors.As(err, &denied) {
+			WarnLog("TCPDialer dial(%v) to %v is denied by %v", sid, uri, denied)
+			err = denied
+		}
 	}
 	return
 }
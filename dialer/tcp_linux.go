@@ -0,0 +1,27 @@
+package dialer
+
+import (
+	"syscall"
+)
+
+//socketOptionSupported is whether the mark/interface socket option is supported
+const socketOptionSupported = true
+
+//controlSocket will set SO_MARK and SO_BINDTODEVICE to socket
+func controlSocket(c syscall.RawConn, mark int, iface string) (err error) {
+	cerr := c.Control(func(fd uintptr) {
+		if mark > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+			if err != nil {
+				return
+			}
+		}
+		if len(iface) > 0 {
+			err = syscall.BindToDevice(int(fd), iface)
+		}
+	})
+	if err == nil {
+		err = cerr
+	}
+	return
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package dialer
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+//socketOptionSupported is whether the mark/interface socket option is supported
+const socketOptionSupported = false
+
+//controlSocket is not supported on not linux
+func controlSocket(c syscall.RawConn, mark int, iface string) (err error) {
+	err = fmt.Errorf("mark/interface is not supported on %v", runtime.GOOS)
+	return
+}
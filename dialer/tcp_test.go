@@ -2,7 +2,10 @@ package dialer
 
 import (
 	"fmt"
+	"net"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/codingeasygo/util/xmap"
 )
@@ -72,3 +75,177 @@ func TestTCPDialer(t *testing.T) {
 	con.Close()
 	cona.Close()
 }
+
+func TestTCPDialerAccess(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	dial := func(options xmap.M, uri string) (err error) {
+		tcp := NewTCPDialer()
+		err = tcp.Bootstrap(options)
+		if err != nil {
+			return
+		}
+		conn, err := tcp.Dial(10, uri, nil)
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	denied := func(err error) bool {
+		coded, ok := err.(*CodeError)
+		return ok && coded.Code() == 0x02
+	}
+	//allowed
+	for _, options := range []xmap.M{
+		nil,
+		{"allow": []string{"127.0.0.0/8"}},
+		{"allow": []string{"127.0.0.1"}, "allow_ports": []string{port}},
+		{"deny": []string{"10.0.0.0/8", "::1"}, "deny_ports": []string{"22", "1-1024"}},
+	} {
+		if err := dial(options, "tcp://127.0.0.1:"+port); err != nil {
+			t.Errorf("%v,%v", options, err)
+			return
+		}
+	}
+	//denied
+	for _, options := range []xmap.M{
+		{"deny": []string{"127.0.0.0/8"}},
+		{"allow": []string{"10.0.0.0/8"}},
+		{"allow_ports": []string{"80", "443"}},
+		{"deny_ports": []string{port + "-" + port}},
+	} {
+		if err := dial(options, "tcp://127.0.0.1:"+port); !denied(err) {
+			t.Errorf("%v,%v", options, err)
+			return
+		}
+		//checked after resolve
+		if err := dial(options, "tcp://localhost:"+port); !denied(err) {
+			t.Errorf("%v,%v", options, err)
+			return
+		}
+	}
+	//bootstrap error
+	for _, options := range []xmap.M{
+		{"allow": []string{"xx"}},
+		{"deny": []string{"127.0.0.1/xx"}},
+		{"allow_ports": []string{"xx"}},
+		{"deny_ports": []string{"80-xx"}},
+		{"deny_ports": []string{"90-80"}},
+	} {
+		if err := NewTCPDialer().Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+	if err := NewPool("test").Bootstrap(xmap.M{"tcp": xmap.M{"allow": []string{"xx"}}}); err == nil {
+		t.Error(err)
+		return
+	}
+	if err := NewTCPDialer().Allowed("xx"); err == nil {
+		t.Error(err)
+		return
+	}
+	if err := NewTCPDialer().Allowed("xx:80"); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestTCPDialerOptions(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	tcp := NewTCPDialer()
+	err := tcp.Bootstrap(xmap.M{
+		"timeout":   3000,
+		"keepalive": "30s",
+		"fallback":  -1,
+		"nodelay":   0,
+	})
+	if err != nil || tcp.Timeout != 3*time.Second || tcp.KeepAlive != 30*time.Second || tcp.Fallback != -time.Millisecond || tcp.NoDelay {
+		t.Errorf("%v,%v", err, tcp.TCPOptions)
+		return
+	}
+	dial := func(uri string) (err error) {
+		conn, err := tcp.Dial(10, uri, nil)
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	for _, uri := range []string{
+		"tcp://127.0.0.1:" + port,
+		"tcp://127.0.0.1:" + port + "?timeout=1s&keepalive=-1&nodelay=1",
+		"tcp4://localhost:" + port,
+		//socket options in uri is ignored
+		"tcp://127.0.0.1:" + port + "?mark=xx",
+		"tcp://127.0.0.1:" + port + "?mark=1&interface=none-xx",
+	} {
+		if err = dial(uri); err != nil {
+			t.Errorf("%v,%v", uri, err)
+			return
+		}
+	}
+	if runtime.GOOS == "linux" {
+		for name, failed := range map[string]bool{"lo": false, "none-xx": true} {
+			bound := NewTCPDialer()
+			if err = bound.Bootstrap(xmap.M{"interface": name}); err != nil {
+				t.Error(err)
+				return
+			}
+			conn, err := bound.Dial(10, "tcp://127.0.0.1:"+port+"?interface=lo", nil)
+			if err == nil {
+				conn.Close()
+			}
+			if (err != nil) != failed {
+				t.Errorf("%v,%v", name, err)
+				return
+			}
+		}
+	}
+	//error
+	begin := time.Now()
+	for _, uri := range []string{
+		"tcp6://127.0.0.1:" + port,
+		"tcp://127.0.0.1:" + port + "?timeout=1ns",
+		"tcp://127.0.0.1:" + port + "?timeout=xx",
+	} {
+		if err = dial(uri); err == nil {
+			t.Error(uri)
+			return
+		}
+	}
+	if used := time.Since(begin); used > time.Second {
+		t.Errorf("used %v", used)
+		return
+	}
+	for _, options := range []xmap.M{
+		{"timeout": "xx"},
+		{"keepalive": "xx"},
+		{"mark": "xx"},
+	} {
+		if err = NewTCPDialer().Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+}
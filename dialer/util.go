@@ -1,10 +1,7 @@
 package dialer
 
 import (
-	"fmt"
 	"io"
-	"os"
-	"sync/atomic"
 	"time"
 )
 
@@ -141,23 +138,6 @@ import (
 // 	return string(bys)
 // }
 
-type DuplexPiped struct {
-	UpReader   *os.File
-	UpWriter   *os.File
-	DownReader *os.File
-	DownWriter *os.File
-	closed     uint32
-}
-
-func (d *DuplexPiped) Close() error {
-	if !atomic.CompareAndSwapUint32(&d.closed, 0, 1) {
-		return fmt.Errorf("DuplexPiped is closed")
-	}
-	d.UpWriter.Close()
-	d.DownWriter.Close()
-	return nil
-}
-
 // type WriterF func(p []byte) (n int, err error)
 
 // func (w WriterF) Write(p []byte) (n int, err error) {
@@ -6,9 +6,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"sync"
-	"time"
 
 	"github.com/codingeasygo/util/xmap"
 	"golang.org/x/net/webdav"
@@ -188,89 +186,6 @@ func (w *WebDialerAddr) String() string {
 	return w.Info
 }
 
-//PipedConn is an implementation of the net.Conn interface for piped two connection.
-type PipedConn struct {
-	piped *DuplexPiped
-	up    bool
-}
-
-//CreatePipedConn will return two piped connection.
-func CreatePipedConn() (a, b *PipedConn, err error) {
-	piped := &DuplexPiped{}
-	piped.UpReader, piped.DownWriter, err = os.Pipe()
-	if err == nil {
-		piped.DownReader, piped.UpWriter, err = os.Pipe()
-	}
-	if err == nil {
-		a = &PipedConn{
-			piped: piped,
-			up:    true,
-		}
-		b = &PipedConn{
-			piped: piped,
-			up:    false,
-		}
-	}
-	return
-}
-
-func (p *PipedConn) Read(b []byte) (n int, err error) {
-	if p.up {
-		n, err = p.piped.UpReader.Read(b)
-	} else {
-		n, err = p.piped.DownReader.Read(b)
-	}
-	return
-}
-
-func (p *PipedConn) Write(b []byte) (n int, err error) {
-	if p.up {
-		n, err = p.piped.UpWriter.Write(b)
-	} else {
-		n, err = p.piped.DownWriter.Write(b)
-	}
-	return
-}
-
-//Close the piped connection
-func (p *PipedConn) Close() error {
-	return p.piped.Close()
-}
-
-//LocalAddr return self
-func (p *PipedConn) LocalAddr() net.Addr {
-	return p
-}
-
-//RemoteAddr return self
-func (p *PipedConn) RemoteAddr() net.Addr {
-	return p
-}
-
-//SetDeadline is empty
-func (p *PipedConn) SetDeadline(t time.Time) error {
-	return nil
-}
-
-//SetReadDeadline is empty
-func (p *PipedConn) SetReadDeadline(t time.Time) error {
-	return nil
-}
-
-//SetWriteDeadline is empty
-func (p *PipedConn) SetWriteDeadline(t time.Time) error {
-	return nil
-}
-
-//Network return "piped"
-func (p *PipedConn) Network() string {
-	return "piped"
-}
-
-func (p *PipedConn) String() string {
-	return "piped"
-}
-
 //WebdavHandler is webdav handler
 type WebdavHandler struct {
 	davsLck sync.RWMutex
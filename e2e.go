@@ -0,0 +1,296 @@
+package bsck
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+//E2EKeys is the pinned keys of end-to-end encrypted session.
+//
+//the originating router encrypts session to the exit router by the public key of exit router name in Peers,
+//the exit router only accepts session from originating router which public key is in Peers.
+type E2EKeys struct {
+	Private []byte            //the x25519 private key of current router
+	Public  []byte            //the x25519 public key of current router
+	Peers   map[string][]byte //the x25519 public key of peer router by name
+}
+
+//NewE2EKeys will return new E2EKeys by base64 encoded private key and peer public keys
+func NewE2EKeys(private string, peers map[string]string) (keys *E2EKeys, err error) {
+	keys = &E2EKeys{Peers: map[string][]byte{}}
+	keys.Private, err = decodeE2EKey(private)
+	if err != nil {
+		err = fmt.Errorf("parse e2e private key fail with %v", err)
+		return
+	}
+	keys.Public, err = curve25519.X25519(keys.Private, curve25519.Basepoint)
+	if err != nil {
+		return
+	}
+	for name, peer := range peers {
+		keys.Peers[name], err = decodeE2EKey(peer)
+		if err != nil {
+			err = fmt.Errorf("parse e2e public key of %v fail with %v", name, err)
+			return
+		}
+	}
+	return
+}
+
+func decodeE2EKey(key string) (data []byte, err error) {
+	data, err = base64.StdEncoding.DecodeString(key)
+	if err == nil && len(data) != curve25519.ScalarSize {
+		err = fmt.Errorf("key length must be %v, but %v", curve25519.ScalarSize, len(data))
+	}
+	return
+}
+
+//GenerateE2EKey will generate base64 encoded x25519 key pair for end-to-end encrypted session
+func GenerateE2EKey() (private, public string, err error) {
+	key := make([]byte, curve25519.ScalarSize)
+	_, err = io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return
+	}
+	pub, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return
+	}
+	private, public = base64.StdEncoding.EncodeToString(key), base64.StdEncoding.EncodeToString(pub)
+	return
+}
+
+//allowed will return if the public key is pinned in peers
+func (e *E2EKeys) allowed(public []byte) bool {
+	for _, peer := range e.Peers {
+		if bytes.Equal(peer, public) {
+			return true
+		}
+	}
+	return false
+}
+
+//uriE2E will return if end-to-end encrypted session is enabled by e2e argument on the last part of uri
+func uriE2E(uri string) bool {
+	switch uriArgs(uri).Get("e2e") {
+	case "1", "true":
+		return true
+	default:
+		return false
+	}
+}
+
+//ErrE2EClosed is the error when end-to-end encrypted session is closed before handshake done
+var ErrE2EClosed = fmt.Errorf("%v", "e2e session is closed")
+
+const e2eHelloSize = 2 * curve25519.PointSize
+
+//e2eCipher is the session cipher between originating router and exit router.
+//
+//each side sends hello frame of ephemeral and static public key as first data frame,
+//the session keys is derived from DH(ephemeral,ephemeral), DH(initiator static,responder ephemeral)
+//and DH(initiator ephemeral,responder static), so only the routers holding pinned private keys can derive them.
+//after hello, each data frame payload is sealed by ChaCha20-Poly1305 with per direction key and frame sequence nonce,
+//so dropped or reordered frame is failed on open.
+type e2eCipher struct {
+	keys      *E2EKeys
+	peer      []byte //the pinned public key of responder, it is nil on responder
+	ephemeral []byte
+	hello     []byte
+	sent      int32 //1 when hello is sent, it is accessed by atomic from read loop
+	received  int32 //1 when hello is received, it is accessed by atomic from write loop
+	send      cipher.AEAD
+	recv      cipher.AEAD
+	sendSeq   uint64
+	recvSeq   uint64
+	nonce     []byte
+	ready     chan int
+	done      chan int
+	err       error
+	lck       sync.Mutex
+}
+
+//newE2ECipher will return new cipher, it is initiator when peer is not nil
+func newE2ECipher(keys *E2EKeys, peer []byte) (c *e2eCipher, err error) {
+	c = &e2eCipher{
+		keys:      keys,
+		peer:      peer,
+		ephemeral: make([]byte, curve25519.ScalarSize),
+		nonce:     make([]byte, chacha20poly1305.NonceSize),
+		ready:     make(chan int),
+		done:      make(chan int),
+		lck:       sync.Mutex{},
+	}
+	_, err = io.ReadFull(rand.Reader, c.ephemeral)
+	if err != nil {
+		return
+	}
+	public, err := curve25519.X25519(c.ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return
+	}
+	c.hello = append(public, keys.Public...)
+	return
+}
+
+//Hello will return the hello payload, it must be sent as first data frame
+func (c *e2eCipher) Hello() []byte {
+	atomic.StoreInt32(&c.sent, 1)
+	return c.hello
+}
+
+//Sent will return if hello is sent
+func (c *e2eCipher) Sent() bool {
+	return atomic.LoadInt32(&c.sent) == 1
+}
+
+//Received will return if peer hello is received
+func (c *e2eCipher) Received() bool {
+	return atomic.LoadInt32(&c.received) == 1
+}
+
+//Handshake will derive session keys by peer hello payload, the cipher is closed with error when handshake fail
+func (c *e2eCipher) Handshake(hello []byte) (err error) {
+	if !atomic.CompareAndSwapInt32(&c.received, 0, 1) {
+		err = fmt.Errorf("e2e handshake is done")
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.fail(err)
+		}
+	}()
+	if len(hello) != e2eHelloSize {
+		err = fmt.Errorf("invalid e2e hello size %v", len(hello))
+		return
+	}
+	ephemeral, static := hello[:curve25519.PointSize], hello[curve25519.PointSize:]
+	initiator := c.peer != nil
+	if initiator && !bytes.Equal(static, c.peer) {
+		err = fmt.Errorf("e2e peer key is not matched")
+		return
+	}
+	if !initiator && !c.keys.allowed(static) {
+		err = fmt.Errorf("e2e peer key is not allowed")
+		return
+	}
+	var secrets [3][]byte
+	secrets[0], err = curve25519.X25519(c.ephemeral, ephemeral)
+	if err != nil {
+		return
+	}
+	if initiator {
+		secrets[1], err = curve25519.X25519(c.keys.Private, ephemeral)
+		if err == nil {
+			secrets[2], err = curve25519.X25519(c.ephemeral, static)
+		}
+	} else {
+		secrets[1], err = curve25519.X25519(c.ephemeral, static)
+		if err == nil {
+			secrets[2], err = curve25519.X25519(c.keys.Private, ephemeral)
+		}
+	}
+	if err != nil {
+		return
+	}
+	transcript := []byte("bsck-e2e-v1")
+	if initiator {
+		transcript = append(append(transcript, c.hello...), hello...)
+	} else {
+		transcript = append(append(transcript, hello...), c.hello...)
+	}
+	secret := append(append(secrets[0], secrets[1]...), secrets[2]...)
+	keys := make([]byte, 2*chacha20poly1305.KeySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, secret, nil, transcript), keys)
+	if err != nil {
+		return
+	}
+	forward, backward := keys[:chacha20poly1305.KeySize], keys[chacha20poly1305.KeySize:]
+	if !initiator {
+		forward, backward = backward, forward
+	}
+	c.send, _ = chacha20poly1305.New(forward)
+	c.recv, _ = chacha20poly1305.New(backward)
+	close(c.ready)
+	return
+}
+
+//Wait will wait handshake done or cipher closed, it will return the handshake error when handshake fail
+func (c *e2eCipher) Wait() (err error) {
+	select {
+	case <-c.ready:
+	case <-c.done:
+		c.lck.Lock()
+		err = c.err
+		c.lck.Unlock()
+		if err == nil {
+			err = ErrE2EClosed
+		}
+	}
+	return
+}
+
+//Seal will encrypt plain and append to dst
+func (c *e2eCipher) Seal(dst, plain []byte) []byte {
+	binary.BigEndian.PutUint64(c.nonce[4:], c.sendSeq)
+	c.sendSeq++
+	return c.send.Seal(dst, c.nonce, plain, nil)
+}
+
+//Open will decrypt sealed and append to dst
+func (c *e2eCipher) Open(dst, sealed []byte) (plain []byte, err error) {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.BigEndian.PutUint64(nonce[4:], c.recvSeq)
+	c.recvSeq++
+	plain, err = c.recv.Open(dst, nonce[:], sealed, nil)
+	return
+}
+
+//Close will release the handshake waiter
+func (c *e2eCipher) Close() {
+	c.fail(nil)
+}
+
+//fail will release the handshake waiter by error, the first error is kept
+func (c *e2eCipher) fail(err error) {
+	c.lck.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.lck.Unlock()
+}
+
+//e2eCipher will return the initiator cipher to exit router by name, or responder cipher when exit is empty
+func (r *Router) e2eCipher(exit string) (c *e2eCipher, err error) {
+	if r.E2E == nil {
+		err = newDialError(DialErrDenied, "e2e is not configured on %v", r.Name)
+		return
+	}
+	var peer []byte
+	if len(exit) > 0 {
+		peer = r.E2E.Peers[exit]
+		if peer == nil {
+			err = newDialError(DialErrInvalid, "e2e key of %v is not pinned", exit)
+			return
+		}
+	}
+	c, err = newE2ECipher(r.E2E, peer)
+	return
+}
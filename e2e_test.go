@@ -0,0 +1,293 @@
+package bsck
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/codingeasygo/bsck/dialer"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xmap"
+)
+
+func newTestE2EKeys(t *testing.T, peers map[string]string) (keys *E2EKeys, public string) {
+	private, public, err := GenerateE2EKey()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keys, err = NewE2EKeys(private, peers)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	return
+}
+
+func TestE2ECipher(t *testing.T) {
+	server, serverPublic := newTestE2EKeys(t, nil)
+	client, clientPublic := newTestE2EKeys(t, map[string]string{"server": serverPublic})
+	server.Peers["client"], _ = decodeE2EKey(clientPublic)
+	initiator, _ := newE2ECipher(client, client.Peers["server"])
+	responder, _ := newE2ECipher(server, nil)
+	if err := initiator.Handshake(responder.Hello()); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := responder.Handshake(initiator.Hello()); err != nil {
+		t.Error(err)
+		return
+	}
+	if initiator.Wait() != nil || responder.Wait() != nil {
+		t.Error("error")
+		return
+	}
+	for i := 0; i < 3; i++ {
+		sealed := initiator.Seal(nil, []byte("abc"))
+		if bytes.Contains(sealed, []byte("abc")) {
+			t.Error("not sealed")
+			return
+		}
+		plain, err := responder.Open(nil, sealed)
+		if err != nil || string(plain) != "abc" {
+			t.Errorf("%v,%v", err, string(plain))
+			return
+		}
+		sealed = responder.Seal(nil, []byte("123"))
+		plain, err = initiator.Open(nil, sealed)
+		if err != nil || string(plain) != "123" {
+			t.Errorf("%v,%v", err, string(plain))
+			return
+		}
+	}
+	//replay
+	sealed := initiator.Seal(nil, []byte("abc"))
+	responder.Open(nil, sealed)
+	if _, err := responder.Open(nil, sealed); err == nil {
+		t.Error("error")
+		return
+	}
+	//handshake error
+	other, _ := newTestE2EKeys(t, nil)
+	for _, c := range []struct {
+		Initiator *e2eCipher
+		Responder *e2eCipher
+	}{
+		{Initiator: mustE2ECipher(other, client.Peers["server"]), Responder: mustE2ECipher(server, nil)},
+		{Initiator: mustE2ECipher(client, client.Peers["server"]), Responder: mustE2ECipher(other, nil)},
+	} {
+		errA := c.Initiator.Handshake(c.Responder.Hello())
+		errB := c.Responder.Handshake(c.Initiator.Hello())
+		if errA == nil && errB == nil {
+			t.Error("error")
+			return
+		}
+	}
+	if err := initiator.Handshake(responder.Hello()); err == nil {
+		t.Error("error")
+		return
+	}
+	if err := mustE2ECipher(server, nil).Handshake([]byte("xx")); err == nil {
+		t.Error("error")
+		return
+	}
+	//closed
+	closed := mustE2ECipher(server, nil)
+	closed.Close()
+	closed.Close()
+	if closed.Wait() != ErrE2EClosed {
+		t.Error("error")
+		return
+	}
+	//keys
+	if _, err := NewE2EKeys("xx", nil); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, err := NewE2EKeys("YWJj", nil); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, err := NewE2EKeys(clientPublic, map[string]string{"x": "xx"}); err == nil {
+		t.Error("error")
+		return
+	}
+}
+
+func mustE2ECipher(keys *E2EKeys, peer []byte) *e2eCipher {
+	c, err := newE2ECipher(keys, peer)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestE2ERawConn(t *testing.T) {
+	server, serverPublic := newTestE2EKeys(t, nil)
+	client, clientPublic := newTestE2EKeys(t, map[string]string{"server": serverPublic})
+	server.Peers["client"], _ = decodeE2EKey(clientPublic)
+	conna, connb, _ := dialer.CreatePipedConn()
+	initiator := NewRawConn("a", connb, 1024, 1, "server->tcp://xx?e2e=1")
+	initiator.cipher = mustE2ECipher(client, client.Peers["server"])
+	responder := NewRawConn("b", xio.NewEchoConn(), 1024, 2, "tcp://xx?e2e=1")
+	responder.cipher = mustE2ECipher(server, nil)
+	//forward frame between two raw like router
+	forward := func(src, dst *RawConn) {
+		for {
+			frame, err := src.ReadFrame()
+			if err != nil {
+				dst.Close()
+				break
+			}
+			if bytes.Contains(frame[13:], []byte("abc")) {
+				panic("not sealed")
+			}
+			if _, err = dst.WriteFrame(frame); err != nil {
+				src.Close()
+				break
+			}
+		}
+	}
+	go forward(initiator, responder)
+	go forward(responder, initiator)
+	fmt.Fprintf(conna, "abc")
+	buf := make([]byte, 1024)
+	n, err := conna.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Errorf("%v,%v", err, string(buf[:n]))
+		return
+	}
+	//tampered frame
+	sealed := initiator.cipher.Seal(nil, []byte("abc"))
+	sealed[0]++
+	if _, err = responder.WriteFrame(append(make([]byte, 13), sealed...)); err == nil {
+		t.Error("error")
+		return
+	}
+	conna.Close()
+	initiator.Close()
+	responder.Close()
+	//closed before handshake
+	closed := NewRawConn("c", xio.NewEchoConn(), 1024, 3, "tcp://xx?e2e=1")
+	closed.cipher = mustE2ECipher(server, nil)
+	closed.ReadFrame()
+	closed.Close()
+	if _, err = closed.ReadFrame(); err != ErrE2EClosed {
+		t.Error(err)
+		return
+	}
+	//handshake fail
+	failed := NewRawConn("d", xio.NewEchoConn(), 1024, 4, "tcp://xx?e2e=1")
+	failed.cipher = mustE2ECipher(server, nil)
+	failed.Ready(nil, nil)
+	failed.ReadFrame()
+	if _, err = failed.WriteFrame(append(make([]byte, 13), "xx"...)); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, err = failed.ReadFrame(); err == nil || err == ErrE2EClosed {
+		t.Error(err)
+		return
+	}
+	if err = failed.Wait(); err == nil || err == ErrE2EClosed {
+		t.Error(err)
+		return
+	}
+}
+
+func TestProxyE2E(t *testing.T) {
+	dialServer := func(sid uint64, uri string) (conn Conn, err error) {
+		conn = NewRawConn("echo", xio.NewEchoConn(), 1024, sid, uri)
+		return
+	}
+	slaverKeys, slaverPublic := newTestE2EKeys(t, nil)
+	otherKeys, _ := newTestE2EKeys(t, nil)
+	callerKeys, callerPublic := newTestE2EKeys(t, map[string]string{"slaver": slaverPublic, "slaver2": slaverPublic, "slaver3": slaverPublic})
+	slaverKeys.Peers["caller"], _ = decodeE2EKey(callerPublic)
+	otherKeys.Peers["caller"], _ = decodeE2EKey(callerPublic)
+	masterHandler := NewNormalAcessHandler("master", nil)
+	for _, name := range []string{"slaver", "slaver2", "slaver3", "caller", "caller2"} {
+		masterHandler.LoginAccess[name] = "abc"
+	}
+	masterHandler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", masterHandler)
+	err := master.ListenMaster(":9239")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	option := xmap.M{"remote": "localhost:9239", "token": "abc", "index": 0}
+	//the e2e keys is configured before login, they are not changed on running router
+	login := func(name string, keys *E2EKeys) (proxy *Proxy, err error) {
+		handler := NewNormalAcessHandler(name, DialRawF(dialServer))
+		handler.DialAccess = [][]string{{".*", ".*"}}
+		proxy = NewProxy(name, handler)
+		proxy.E2E = keys
+		_, _, err = proxy.Login(option)
+		return
+	}
+	nodes := []struct {
+		Name string
+		Keys *E2EKeys
+	}{
+		{Name: "slaver", Keys: slaverKeys},
+		{Name: "slaver2", Keys: nil},
+		{Name: "slaver3", Keys: otherKeys},
+		{Name: "caller", Keys: callerKeys},
+		{Name: "caller2", Keys: nil},
+	}
+	proxies := map[string]*Proxy{}
+	for _, node := range nodes {
+		proxy, xerr := login(node.Name, node.Keys)
+		if xerr != nil {
+			t.Error(xerr)
+			return
+		}
+		defer proxy.Close()
+		proxies[node.Name] = proxy
+	}
+	caller := proxies["caller"]
+	echo := func(caller *Proxy, uri string) (err error) {
+		conna, connb, _ := dialer.CreatePipedConn()
+		defer conna.Close()
+		_, err = caller.SyncDial(uri, connb)
+		if err != nil {
+			return
+		}
+		data := bytes.Repeat([]byte("x"), 10000)
+		go conna.Write(data)
+		back := make([]byte, len(data))
+		_, err = io.ReadFull(conna, back)
+		if err == nil && !bytes.Equal(back, data) {
+			err = fmt.Errorf("not equal")
+		}
+		return
+	}
+	if err = echo(caller, "master->slaver->tcp://xx?e2e=1"); err != nil {
+		t.Error(err)
+		return
+	}
+	//not pinned
+	var dialErr *DialError
+	if err = echo(caller, "master->tcp://xx?e2e=1"); !errors.As(err, &dialErr) || dialErr.ErrCode != DialErrInvalid {
+		t.Error(err)
+		return
+	}
+	//not configured
+	if err = echo(caller, "master->slaver2->tcp://xx?e2e=1"); !errors.As(err, &dialErr) || dialErr.ErrCode != DialErrDenied {
+		t.Error(err)
+		return
+	}
+	if err = echo(proxies["caller2"], "master->slaver->tcp://xx?e2e=1"); !errors.As(err, &dialErr) || dialErr.ErrCode != DialErrDenied {
+		t.Error(err)
+		return
+	}
+	//key not matched
+	if err = echo(caller, "master->slaver3->tcp://xx?e2e=1"); err == nil {
+		t.Error(err)
+		return
+	}
+}
@@ -2,6 +2,7 @@ package bsck
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -149,6 +150,16 @@ func (f *Forward) ProcRouter(router ForwardUri, w http.ResponseWriter, req *http
 				return f.procDialTLS(network, addr, remoteURI)
 			},
 		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			WarnLog("Forward proc web by router(%v),Connection(%v) fail with %v", router, connection, err)
+			status := http.StatusBadGateway
+			var dialErr *DialError
+			if errors.As(err, &dialErr) {
+				status = dialErr.HTTPStatus()
+			}
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "Error:%v", err)
+		},
 	}
 	proxy.ServeHTTP(w, req)
 }
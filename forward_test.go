@@ -306,3 +306,42 @@ func TestWaitReadWriteCloser(t *testing.T) {
 	cona.Close()
 
 }
+
+func TestForwardDialError(t *testing.T) {
+	forward := NewForward()
+	forward.WebSuffix = ".loc"
+	forward.Dialer = func(uri string, raw io.ReadWriteCloser) (sid uint64, err error) {
+		switch {
+		case strings.Contains(uri, "denied"):
+			err = newDialError(DialErrDenied, "access denied")
+		case strings.Contains(uri, "nochannel"):
+			err = newDialError(DialErrNoChannel, "channel not exist")
+		case strings.Contains(uri, "timeout"):
+			err = ErrRouteTimeout
+		default:
+			err = fmt.Errorf("test error")
+		}
+		return
+	}
+	for _, name := range []string{"denied", "nochannel", "timeout", "other"} {
+		forward.AddForward("web://"+name, "http://"+name)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Host = strings.TrimPrefix(req.URL.Path, "/") + ".loc"
+		req.URL.Path = "/"
+		forward.HostForwardF(w, req)
+	}))
+	defer ts.Close()
+	for name, status := range map[string]int{
+		"denied":    http.StatusForbidden,
+		"nochannel": http.StatusServiceUnavailable,
+		"timeout":   http.StatusGatewayTimeout,
+		"other":     http.StatusBadGateway,
+	} {
+		_, err := hget("%v/%v", ts.URL, name)
+		if err == nil || err.Error() != fmt.Sprintf("code:%v", status) {
+			t.Errorf("%v,%v", name, err)
+			return
+		}
+	}
+}
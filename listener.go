@@ -0,0 +1,107 @@
+package bsck
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//ListenerEnv is the env key to pass listener to child process, the value format is name=fd,name=fd
+const ListenerEnv = "BSCK_LISTENERS"
+
+var inherited map[string]net.Listener
+var inheritedLck = sync.Mutex{}
+
+func loadInheritedNoLock() {
+	if inherited != nil {
+		return
+	}
+	inherited = map[string]net.Listener{}
+	value := os.Getenv(ListenerEnv)
+	if len(value) < 1 {
+		return
+	}
+	os.Unsetenv(ListenerEnv)
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		fd, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			WarnLog("parse inherited listener %v fail with %v", item, err)
+			continue
+		}
+		file := os.NewFile(uintptr(fd), parts[0])
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			WarnLog("load inherited listener %v fail with %v", item, err)
+			continue
+		}
+		InfoLog("load inherited listener %v on %v", parts[0], listener.Addr())
+		inherited[parts[0]] = listener
+	}
+}
+
+//Listen will return the listener inherited from parent process by name, or listen new one on address
+func Listen(name, network, address string) (listener net.Listener, err error) {
+	inheritedLck.Lock()
+	loadInheritedNoLock()
+	listener, ok := inherited[name]
+	delete(inherited, name)
+	inheritedLck.Unlock()
+	if ok {
+		InfoLog("use inherited listener %v on %v", name, listener.Addr())
+		return
+	}
+	listener, err = net.Listen(network, address)
+	return
+}
+
+//CloseInherited will close all inherited listener which is not used
+func CloseInherited() {
+	inheritedLck.Lock()
+	loadInheritedNoLock()
+	for name, listener := range inherited {
+		InfoLog("close unused inherited listener %v on %v", name, listener.Addr())
+		listener.Close()
+		delete(inherited, name)
+	}
+	inheritedLck.Unlock()
+}
+
+type fileListener interface {
+	File() (f *os.File, err error)
+}
+
+//ListenerFiles will return the duplicated file of listeners and the env value to pass them to child process by ExtraFiles
+func ListenerFiles(listeners map[string]net.Listener) (files []*os.File, env string, err error) {
+	items := []string{}
+	for name, listener := range listeners {
+		raw, ok := listener.(fileListener)
+		if !ok {
+			err = fmt.Errorf("listener %v is not supported to get file", name)
+			break
+		}
+		var file *os.File
+		file, err = raw.File()
+		if err != nil {
+			break
+		}
+		items = append(items, fmt.Sprintf("%v=%v", name, 3+len(files)))
+		files = append(files, file)
+	}
+	if err != nil {
+		for _, file := range files {
+			file.Close()
+		}
+		files = nil
+		return
+	}
+	env = ListenerEnv + "=" + strings.Join(items, ",")
+	return
+}
@@ -0,0 +1,93 @@
+// +build !windows
+
+package bsck
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestListener(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer raw.Close()
+	files, env, err := ListenerFiles(map[string]net.Listener{"master": raw})
+	if err != nil || len(files) != 1 || env != ListenerEnv+"=master=3" {
+		t.Errorf("%v,%v", err, env)
+		return
+	}
+	//inherited
+	inheritedLck.Lock()
+	inherited = nil
+	inheritedLck.Unlock()
+	fd, err := syscall.Dup(int(files[0].Fd()))
+	files[0].Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	os.Setenv(ListenerEnv, fmt.Sprintf("master=%v,xx,web2=xx", fd))
+	listener, err := Listen("master", "tcp", "127.0.0.1:0")
+	if err != nil || listener.Addr().String() != raw.Addr().String() {
+		t.Errorf("%v,%v", err, listener)
+		return
+	}
+	conn, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	listener.Close()
+	//not inherited
+	listener, err = Listen("master", "tcp", "127.0.0.1:0")
+	if err != nil || listener.Addr().String() == raw.Addr().String() {
+		t.Errorf("%v,%v", err, listener)
+		return
+	}
+	listener.Close()
+	CloseInherited()
+	//error
+	_, _, err = ListenerFiles(map[string]net.Listener{"master": raw, "none": &net.UnixListener{}, "xx": nil})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestServiceUpgrade(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer raw.Close()
+	service := NewService()
+	service.Web = raw
+	if len(service.Listeners()) != 1 {
+		t.Error("error")
+		return
+	}
+	process, err := service.Upgrade("sh", "-c", `echo "$`+ListenerEnv+`" | grep -q web=3`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	state, err := process.Wait()
+	if err != nil || !state.Success() {
+		t.Errorf("%v,%v", err, state)
+		return
+	}
+	_, err = service.Upgrade("/none/bsrouter")
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,117 @@
+package bsck
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/codingeasygo/util/xio/frame"
+)
+
+const (
+	poolMinShift = 6
+	poolMaxShift = 20
+)
+
+//BufferPool is the sync.Pool based buffer allocator by size class of power of 2
+type BufferPool struct {
+	pools [poolMaxShift + 1]sync.Pool
+}
+
+//NewBufferPool will return new BufferPool
+func NewBufferPool() (pool *BufferPool) {
+	pool = &BufferPool{}
+	for i := poolMinShift; i <= poolMaxShift; i++ {
+		size := 1 << uint(i)
+		pool.pools[i].New = func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
+	return
+}
+
+func poolShift(size int) (shift int) {
+	shift = poolMinShift
+	for 1<<uint(shift) < size {
+		shift++
+	}
+	return
+}
+
+//Get will return buffer which length is size, the buffer is not pooled when size is greater than 1MB
+func (b *BufferPool) Get(size int) []byte {
+	shift := poolShift(size)
+	if shift > poolMaxShift {
+		return make([]byte, size)
+	}
+	buf := b.pools[shift].Get().(*[]byte)
+	return (*buf)[:size]
+}
+
+//Put will put buffer back to pool, the buffer must be not used after put
+func (b *BufferPool) Put(buf []byte) {
+	size := cap(buf)
+	shift := poolShift(size)
+	if shift > poolMaxShift || 1<<uint(shift) != size {
+		return
+	}
+	buf = buf[:size]
+	b.pools[shift].Put(&buf)
+}
+
+//Buffers is the default buffer pool shared by all router
+var Buffers = NewBufferPool()
+
+//bufferReleaser is the connection which can release the pooled buffer when reader is stopped
+type bufferReleaser interface {
+	ReleaseBuffer()
+}
+
+//releaseBuffer will release the pooled buffer of connection
+func releaseBuffer(conn interface{}) {
+	for conn != nil {
+		switch c := conn.(type) {
+		case bufferReleaser:
+			c.ReleaseBuffer()
+			return
+		case *Channel:
+			conn = c.ReadWriteCloser
+		case *InfoRWC:
+			conn = c.ReadWriteCloser
+		default:
+			return
+		}
+	}
+}
+
+//FrameConn is frame.ReadWriteCloser which read buffer is allocated from Buffers
+type FrameConn struct {
+	*frame.BaseReadWriteCloser
+	released uint32
+}
+
+//NewFrameConn will return new FrameConn by raw connection and buffer size
+func NewFrameConn(raw io.ReadWriteCloser, bufferSize int) (conn *FrameConn) {
+	if bufferSize < 1 {
+		panic("buffer size is < 1")
+	}
+	reader := frame.NewBaseReader(raw, 1)
+	reader.Buffer = Buffers.Get(bufferSize)
+	conn = &FrameConn{
+		BaseReadWriteCloser: &frame.BaseReadWriteCloser{
+			Closer:     raw,
+			BaseReader: reader,
+			BaseWriter: frame.NewBaseWriter(raw),
+		},
+	}
+	return
+}
+
+//ReleaseBuffer will put read buffer back to pool, it must be called after reader is stopped
+func (f *FrameConn) ReleaseBuffer() {
+	if atomic.CompareAndSwapUint32(&f.released, 0, 1) {
+		Buffers.Put(f.BaseReader.Buffer)
+		f.BaseReader.Buffer = nil
+	}
+}
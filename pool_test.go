@@ -0,0 +1,73 @@
+package bsck
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xio/frame"
+)
+
+func TestBufferPool(t *testing.T) {
+	pool := NewBufferPool()
+	for _, size := range []int{1, 13, 64, 65, 1024, 32 * 1024, 1 << 20} {
+		buf := pool.Get(size)
+		if len(buf) != size || cap(buf) < size {
+			t.Errorf("size:%v,len:%v,cap:%v", size, len(buf), cap(buf))
+			return
+		}
+		pool.Put(buf)
+	}
+	//not pooled
+	buf := pool.Get(1<<20 + 1)
+	if len(buf) != 1<<20+1 {
+		t.Error("error")
+		return
+	}
+	pool.Put(buf)
+	pool.Put(make([]byte, 100))
+	//release
+	releaseBuffer(nil)
+	releaseBuffer("xx")
+	raw := NewRawConn("raw", xio.NewEchoConn(), 1024, 1, "xx")
+	releaseBuffer(raw)
+	releaseBuffer(raw)
+	conna, connb, _ := xio.Pipe()
+	framea, frameb := NewFrameConn(conna, 1024), NewFrameConn(connb, 1024)
+	go framea.WriteFrame([]byte("xxxxabc"))
+	data, err := frameb.ReadFrame()
+	if err != nil || !bytes.Equal(data[4:], []byte("abc")) {
+		t.Errorf("%v,%v", err, data)
+		return
+	}
+	releaseBuffer(&Channel{ReadWriteCloser: NewInfoRWC(framea, "a")})
+	releaseBuffer(framea)
+	framea.Close()
+	frameb.Close()
+}
+
+func BenchmarkWriteCmd(b *testing.B) {
+	writer := frame.NewBaseWriter(ioutil.Discard)
+	msg := []byte("dial to uri(tcp://127.0.0.1) fail with connection refused")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		writeCmd(writer, nil, CmdClosed, uint64(i), msg)
+	}
+}
+
+func BenchmarkRawConn(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		raw := NewRawConn("raw", nil, 32*1024, 1, "xx")
+		raw.ReleaseBuffer()
+	}
+}
+
+func BenchmarkFrameConn(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		conn := NewFrameConn(nil, 32*1024)
+		conn.ReleaseBuffer()
+	}
+}
@@ -7,13 +7,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	mrand "math/rand"
 	"net"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/codingeasygo/util/converter"
 	"github.com/codingeasygo/util/proxy/socks"
 	"github.com/codingeasygo/util/xio"
 	"github.com/codingeasygo/util/xio/frame"
@@ -88,6 +91,13 @@ func (n *NormalAcessHandler) DialRaw(sid uint64, uri string) (raw Conn, err erro
 	return
 }
 
+//CancelDial will cancel the running raw dial when dialer is DialCanceler
+func (n *NormalAcessHandler) CancelDial(sid uint64) {
+	if canceler, ok := n.Dialer.(DialCanceler); ok {
+		canceler.CancelDial(sid)
+	}
+}
+
 //OnConnLogin is proxy handler to handle login
 func (n *NormalAcessHandler) OnConnLogin(channel Conn, args string) (name string, index int, result xmap.M, err error) {
 	var option = xmap.M{}
@@ -237,15 +247,22 @@ type ForwardEntry []interface{}
 
 //Proxy is an implementation of proxy router
 type Proxy struct {
-	*Router                      //the router
-	Running        bool          //proxy is running.
-	ReconnectDelay time.Duration //reconnect delay
-	Cert           string        //the tls cert
-	Key            string        //the tls key
+	*Router                                                          //the router
+	Running        bool                                              //proxy is running, it is guarded by reconnectLck
+	ReconnectDelay time.Duration                                     //reconnect delay
+	ReconnectMax   time.Duration                                     //the max reconnect delay of backoff
+	ReconnectRetry func(option xmap.M, attempts int, err error) bool //check if continue reconnect after fail, default is stop when login is rejected
+	Cert           string                                            //the tls cert
+	Key            string                                            //the tls key
 	master         net.Listener
+	masterRaw      net.Listener
 	forwards       map[string]ForwardEntry
 	forwardsLck    sync.RWMutex
+	reconnect      map[string]*reconnectState
+	reconnectLck   sync.RWMutex
+	stopReconnect  bool //proxy is draining, the closed login channel is not reconnected, it is guarded by reconnectLck
 	Handler        ProxyHandler
+	Dialer         func(uri string, raw io.ReadWriteCloser, sync bool) (sid uint64, err error) //the forward dialer, default is dial on router
 }
 
 //NewProxy will return new Proxy by name
@@ -257,8 +274,13 @@ func NewProxy(name string, handler ProxyHandler) (proxy *Proxy) {
 		Handler:        handler,
 		Running:        true,
 		ReconnectDelay: 3 * time.Second,
+		ReconnectMax:   5 * time.Minute,
+		reconnect:      map[string]*reconnectState{},
+		reconnectLck:   sync.RWMutex{},
 	}
 	proxy.Router.Handler = proxy
+	proxy.Dialer = proxy.dialRouter
+	proxy.ReconnectRetry = proxy.retryReconnect
 	return
 }
 
@@ -274,9 +296,13 @@ func (p *Proxy) ListenMaster(addr string) (err error) {
 		}
 		config := &tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}
 		config.Rand = rand.Reader
-		p.master, err = tls.Listen("tcp", addr, config)
+		p.masterRaw, err = Listen("master", "tcp", addr)
+		if err == nil {
+			p.master = tls.NewListener(p.masterRaw, config)
+		}
 	} else {
-		p.master, err = net.Listen("tcp", addr)
+		p.masterRaw, err = Listen("master", "tcp", addr)
+		p.master = p.masterRaw
 	}
 	if err == nil {
 		go p.loopMaster(p.master)
@@ -302,16 +328,19 @@ func (p *Proxy) StartForward(name string, listen *url.URL, router string) (liste
 	case "socks":
 		sp := socks.NewServer()
 		sp.Dialer = xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
-			raw, err = p.DialPiper(strings.Replace(router, "${HOST}", uri, -1), bufferSize)
+			piper := NewWaitedPiper()
+			_, err = p.Dialer(strings.Replace(router, "${HOST}", uri, -1), piper, true)
+			raw = piper
 			return
 		})
-		listener, err = sp.Start(listen.Host)
+		listener, err = Listen("forward:"+name, "tcp", listen.Host)
 		if err == nil {
+			go p.loopSocks(listener, sp)
 			p.forwards[name] = []interface{}{listener, listen, router}
 			InfoLog("Proxy(%v) start socket forward on %v success by %v->%v", p.Name, listener.Addr(), listen, router)
 		}
 	default:
-		listener, err = net.Listen(listen.Scheme, listen.Host)
+		listener, err = Listen("forward:"+name, listen.Scheme, listen.Host)
 		if err == nil {
 			p.forwards[name] = []interface{}{listener, listen, router}
 			go p.loopForward(listener, name, listen, router)
@@ -337,36 +366,58 @@ func (p *Proxy) StopForward(name string) (err error) {
 func (p *Proxy) loopMaster(l net.Listener) {
 	var err error
 	var conn net.Conn
-	for p.Running {
+	for p.running() {
 		conn, err = l.Accept()
 		if err != nil {
 			break
 		}
 		DebugLog("Proxy(%v) master accepting connection from %v", p.Name, conn.RemoteAddr())
-		p.Router.Accept(NewInfoRWC(frame.NewReadWriteCloser(conn, p.BufferSize), conn.RemoteAddr().String()))
+		p.Router.Accept(NewInfoRWC(NewFrameConn(conn, p.BufferSize), conn.RemoteAddr().String()))
 	}
 	l.Close()
 	InfoLog("Proxy(%v) master accept on %v is stopped", p.Name, l.Addr())
 }
 
+func (p *Proxy) loopSocks(l net.Listener, sp *socks.Server) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+		go func(c net.Conn) {
+			if err := sp.ProcConn(c); err != xio.ErrAsyncRunning {
+				c.Close()
+			}
+		}(conn)
+	}
+	InfoLog("Proxy(%v) socks forward on %v accept runner is stopped", p.Name, l.Addr())
+}
+
+//Listeners will return all master/forward listener by name
+func (p *Proxy) Listeners() (listeners map[string]net.Listener) {
+	listeners = map[string]net.Listener{}
+	if p.masterRaw != nil {
+		listeners["master"] = p.masterRaw
+	}
+	p.forwardsLck.RLock()
+	for name, f := range p.forwards {
+		listeners["forward:"+name] = f[0].(net.Listener)
+	}
+	p.forwardsLck.RUnlock()
+	return
+}
+
 func (p *Proxy) loopForward(l net.Listener, name string, listen *url.URL, uri string) {
 	var err error
-	var sid uint64
 	var conn net.Conn
 	InfoLog("Proxy(%v) proxy forward(%v->%v) accept runner is starting", p.Name, l.Addr(), uri)
-	for p.Running {
+	for p.running() {
 		conn, err = l.Accept()
 		if err != nil {
 			break
 		}
 		DebugLog("Proxy(%v) accepting forward(%v->%v) connection from %v", p.Name, l.Addr(), uri, conn.RemoteAddr())
-		sid, err = p.Dial(uri, conn)
-		if err == nil {
-			DebugLog("Proxy(%v) proxy forward(%v->%v) success on session(%v)", p.Name, l.Addr(), uri, sid)
-		} else {
-			WarnLog("Proxy(%v) proxy forward(%v->%v) fail with %v", p.Name, l.Addr(), uri, err)
-			conn.Close()
-		}
+		go p.procForward(l, uri, conn)
 	}
 	l.Close()
 	InfoLog("Proxy(%v) proxy forward(%v->%v) accept runner is stopped", p.Name, l.Addr(), uri)
@@ -375,10 +426,70 @@ func (p *Proxy) loopForward(l net.Listener, name string, listen *url.URL, uri st
 	p.forwardsLck.Unlock()
 }
 
+func (p *Proxy) procForward(l net.Listener, uri string, conn net.Conn) {
+	sid, err := p.Dialer(uri, conn, false)
+	if err == nil {
+		DebugLog("Proxy(%v) proxy forward(%v->%v) success on session(%v)", p.Name, l.Addr(), uri, sid)
+	} else {
+		WarnLog("Proxy(%v) proxy forward(%v->%v) fail with %v", p.Name, l.Addr(), uri, err)
+		conn.Close()
+	}
+}
+
+func (p *Proxy) dialRouter(uri string, raw io.ReadWriteCloser, sync bool) (sid uint64, err error) {
+	if sync {
+		sid, err = p.SyncDial(uri, raw)
+	} else {
+		sid, err = p.Dial(uri, raw)
+	}
+	return
+}
+
+//Drain will stop master and forward listener, then drain router in timeout,
+//the login channel closed in draining is not reconnected.
+func (p *Proxy) Drain(timeout time.Duration) (remain int) {
+	InfoLog("Proxy(%v) is draining", p.Name)
+	p.SetDraining(true)
+	if p.master != nil {
+		p.master.Close()
+	}
+	p.forwardsLck.RLock()
+	for _, f := range p.forwards {
+		f[0].(net.Listener).Close()
+	}
+	p.forwardsLck.RUnlock()
+	remain = p.Router.Drain(timeout)
+	return
+}
+
+//running will return whether proxy is running
+func (p *Proxy) running() bool {
+	p.reconnectLck.RLock()
+	defer p.reconnectLck.RUnlock()
+	return p.Running
+}
+
+//SetDraining will set the draining flag, the closed login channel is not reconnected when draining,
+//so the channel login by upgraded process with same name and index is not kicked by reconnect of draining process.
+func (p *Proxy) SetDraining(draining bool) {
+	p.reconnectLck.Lock()
+	p.stopReconnect = draining
+	p.reconnectLck.Unlock()
+}
+
+//reconnecting will return whether the closed login channel should be reconnected
+func (p *Proxy) reconnecting() bool {
+	p.reconnectLck.RLock()
+	defer p.reconnectLck.RUnlock()
+	return p.Running && !p.stopReconnect
+}
+
 //Close will close the tcp listen
 func (p *Proxy) Close() (err error) {
 	InfoLog("Proxy(%v) is closing", p.Name)
+	p.reconnectLck.Lock()
 	p.Running = false
+	p.reconnectLck.Unlock()
 	if p.master != nil {
 		err = p.master.Close()
 		InfoLog("Proxy(%v) master is closed", p.Name)
@@ -394,16 +505,118 @@ func (p *Proxy) Close() (err error) {
 	return
 }
 
+type reconnectState struct {
+	Attempts int           //the current fail attempts
+	Total    int64         //the total attempts
+	Delay    time.Duration //the delay of next attempt
+	Error    string        //the last error
+	Running  bool          //whether reconnect is running
+}
+
+func reconnectKey(option xmap.M) string {
+	return fmt.Sprintf("%v#%v", option.Str("remote"), option.Int("index"))
+}
+
 func (p *Proxy) runReconnect(args xmap.M) {
-	for p.Running {
+	key := reconnectKey(args)
+	p.reconnectLck.Lock()
+	state := p.reconnect[key]
+	if state == nil {
+		state = &reconnectState{}
+		p.reconnect[key] = state
+	}
+	if state.Running {
+		p.reconnectLck.Unlock()
+		return
+	}
+	state.Running = true
+	state.Attempts = 0
+	//delay the first attempt by jitter too, so all channels is not reconnecting at same time after disconnect
+	state.Delay = p.reconnectDelay(1)
+	delay := state.Delay
+	p.reconnectLck.Unlock()
+	defer func() {
+		p.reconnectLck.Lock()
+		state.Running = false
+		p.reconnectLck.Unlock()
+	}()
+	time.Sleep(delay)
+	for attempts := 1; p.reconnecting(); attempts++ {
 		_, _, err := p.Login(args)
+		p.reconnectLck.Lock()
+		state.Total++
+		if err == nil {
+			state.Attempts, state.Delay, state.Error = 0, 0, ""
+		} else {
+			state.Attempts, state.Delay, state.Error = attempts, p.reconnectDelay(attempts), err.Error()
+		}
+		delay = state.Delay
+		p.reconnectLck.Unlock()
 		if err == nil {
 			break
 		}
-		time.Sleep(p.ReconnectDelay)
+		if !p.ReconnectRetry(args, attempts, err) {
+			WarnLog("Proxy(%v) stop reconnect to %v after %v attempts by %v", p.Name, key, attempts, err)
+			break
+		}
+		DebugLog("Proxy(%v) reconnect to %v fail with %v, will retry after %v", p.Name, key, err, delay)
+		time.Sleep(delay)
 	}
 }
 
+//reconnectDelay will return exponential backoff delay with jitter by attempts
+func (p *Proxy) reconnectDelay(attempts int) (delay time.Duration) {
+	delay = p.ReconnectDelay
+	for i := 1; i < attempts && (p.ReconnectMax < 1 || delay < p.ReconnectMax); i++ {
+		delay *= 2
+	}
+	if p.ReconnectMax > 0 && delay > p.ReconnectMax {
+		delay = p.ReconnectMax
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + mrand.Int63n(half))
+	}
+	return
+}
+
+func (p *Proxy) retryReconnect(option xmap.M, attempts int, err error) bool {
+	_, rejected := err.(*LoginError)
+	return !rejected
+}
+
+//State will return the router state with reconnect state
+func (p *Proxy) State(args ...interface{}) (state xmap.M) {
+	state = p.Router.State(args...)
+	if len(state) < 1 {
+		return
+	}
+	reconnect := xmap.M{}
+	p.reconnectLck.RLock()
+	for key, s := range p.reconnect {
+		reconnect[key] = xmap.M{
+			"attempts": s.Attempts,
+			"total":    s.Total,
+			"delay":    s.Delay.Milliseconds(),
+			"error":    s.Error,
+			"running":  s.Running,
+		}
+	}
+	p.reconnectLck.RUnlock()
+	state["reconnect"] = reconnect
+	return
+}
+
+//StateH return the current state of proxy
+func (p *Proxy) StateH(w http.ResponseWriter, req *http.Request) {
+	var query = xmap.M{}
+	for key := range req.URL.Query() {
+		query[key] = req.URL.Query().Get(key)
+	}
+	state := p.State(query)
+	w.Header().Add("Content-Type", "application/json;charset=utf-8")
+	fmt.Fprintf(w, "%v", converter.JSON(state))
+}
+
 //DialRaw will dial raw connection
 func (p *Proxy) DialRaw(sid uint64, uri string) (raw Conn, err error) {
 	if p.Handler == nil {
@@ -414,6 +627,13 @@ func (p *Proxy) DialRaw(sid uint64, uri string) (raw Conn, err error) {
 	return
 }
 
+//CancelDial will cancel the running raw dial when handler is DialCanceler
+func (p *Proxy) CancelDial(sid uint64) {
+	if canceler, ok := p.Handler.(DialCanceler); ok {
+		canceler.CancelDial(sid)
+	}
+}
+
 //OnConnDialURI is on connection dial uri
 func (p *Proxy) OnConnDialURI(channel Conn, conn string, parts []string) (err error) {
 	if p.Handler == nil {
@@ -436,18 +656,20 @@ func (p *Proxy) OnConnLogin(channel Conn, args string) (name string, index int,
 
 //OnConnClose will be called when connection is closed
 func (p *Proxy) OnConnClose(conn Conn) (err error) {
-	if !p.Running {
+	if !p.running() {
 		return
 	}
 	channel, ok := conn.(*Channel)
-	if !p.Running || !ok {
+	if !ok {
 		return
 	}
 	context := channel.Context()
 	if p.Handler != nil {
 		err = p.Handler.OnConnClose(conn)
 	}
-	if err == nil && context.IntDef(-1, "login_conn") == 1 {
+	if err == nil && context.IntDef(-1, "login_conn") == 1 && !p.reconnecting() {
+		InfoLog("Proxy(%v) the channel(%v) is closed in draining, skip reconnect", p.Name, channel)
+	} else if err == nil && context.IntDef(-1, "login_conn") == 1 {
 		go p.runReconnect(context.Map("option"))
 		InfoLog("Proxy(%v) the channel(%v) is closed, will reconnect it", p.Name, channel)
 	} else {
@@ -522,10 +744,9 @@ func (p *Proxy) Login(option xmap.M) (channel *Channel, result xmap.M, err error
 	}
 	auth["index"] = index
 	auth["name"] = p.Name
-	channel, result, err = p.JoinConn(NewInfoRWC(frame.NewReadWriteCloser(conn, p.BufferSize), conn.RemoteAddr().String()), index, auth)
+	context := xmap.M{"option": option, "login_conn": 1}
+	channel, result, err = p.joinConn(NewInfoRWC(NewFrameConn(conn, p.BufferSize), conn.RemoteAddr().String()), index, auth, context)
 	if err == nil {
-		channel.Context()["option"] = option
-		channel.Context()["login_conn"] = 1
 		p.Handler.OnConnJoin(channel, option, result)
 	}
 	return
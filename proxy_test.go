@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -874,11 +878,19 @@ func TestProxyError(t *testing.T) {
 		srcRaw = NewErrReadWriteCloser(buf[0:104], 0)
 		src = &Channel{ReadWriteCloser: frame.NewReadWriteCloser(srcRaw, 50)}
 		master.Router.loopReadRaw(src)
-		//cmd error
-		binary.BigEndian.PutUint32(buf, 100)
-		srcRaw = NewErrReadWriteCloser(buf[0:104], 0)
-		src = &Channel{ReadWriteCloser: frame.NewReadWriteCloser(srcRaw, 1024)}
+		//unknown cmd is ignored
+		conna, connb, _ := xio.Pipe()
+		go func() {
+			writeCmd(frame.NewReadWriteCloser(connb, 1024), nil, 0, 0, []byte("unknown"))
+			writeCmd(frame.NewReadWriteCloser(connb, 1024), nil, CmdHeartbeat, 0, []byte("ping"))
+			connb.Close()
+		}()
+		src = &Channel{ReadWriteCloser: frame.NewReadWriteCloser(conna, 1024)}
 		master.Router.loopReadRaw(src)
+		if src.Heartbeat < 1 {
+			t.Error("error")
+			return
+		}
 	}
 	{ //test for cover
 		// rawConn := NewRawConn("", NewEcho("data"), 1024, 0, "")
@@ -967,6 +979,66 @@ func TestReconnect(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 }
 
+func TestReconnectBackoff(t *testing.T) {
+	handler := NewNormalAcessHandler("master", nil)
+	handler.LoginAccess["slaver"] = "abc"
+	handler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", handler)
+	err := master.ListenMaster(":9233")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	slaver := NewProxy("slaver", NewNoneHandler())
+	defer slaver.Close()
+	slaver.ReconnectDelay = 10 * time.Millisecond
+	slaver.ReconnectMax = 40 * time.Millisecond
+	//backoff
+	for attempts := 1; attempts < 10; attempts++ {
+		delay := slaver.reconnectDelay(attempts)
+		if delay < 5*time.Millisecond || delay >= slaver.ReconnectMax {
+			t.Errorf("attempts:%v,delay:%v", attempts, delay)
+			return
+		}
+		if attempts > 3 && delay < 20*time.Millisecond {
+			t.Errorf("attempts:%v,delay:%v", attempts, delay)
+			return
+		}
+	}
+	//stop by rejected, the first attempt is delayed by jitter
+	begin := time.Now()
+	slaver.runReconnect(xmap.M{"remote": "localhost:9233", "token": "xxx", "index": 0})
+	if used := time.Since(begin); used < 5*time.Millisecond {
+		t.Errorf("used:%v", used)
+		return
+	}
+	state := slaver.State(xmap.M{"*": "*"})
+	if state.Int64Def(0, "reconnect/localhost:9233#0/attempts") != 1 || len(state.StrDef("", "reconnect/localhost:9233#0/error")) < 1 {
+		t.Error(converter.JSON(state))
+		return
+	}
+	//retry until max attempts
+	slaver.ReconnectRetry = func(option xmap.M, attempts int, err error) bool { return attempts < 3 }
+	slaver.runReconnect(xmap.M{"remote": "localhost:9234", "token": "abc", "index": 0})
+	state = slaver.State(xmap.M{"*": "*"})
+	if state.Int64Def(0, "reconnect/localhost:9234#0/attempts") != 3 || state.Int64Def(0, "reconnect/localhost:9234#0/total") != 3 {
+		t.Error(converter.JSON(state))
+		return
+	}
+	//reset on success
+	slaver.runReconnect(xmap.M{"remote": "localhost:9233", "token": "abc", "index": 0})
+	state = slaver.State(xmap.M{"*": "*"})
+	if state.Int64Def(-1, "reconnect/localhost:9233#0/attempts") != 0 || state.Int64Def(0, "reconnect/localhost:9233#0/total") != 2 {
+		t.Error(converter.JSON(state))
+		return
+	}
+	if len(slaver.State()) > 0 {
+		t.Error("error")
+		return
+	}
+}
+
 func TestProxyForward(t *testing.T) {
 	var masterEcho *Echo
 	handler := NewNormalAcessHandler("master", DialRawF(func(sid uint64, uri string) (conn Conn, err error) {
@@ -1406,3 +1478,741 @@ func TestProxyDialSync(t *testing.T) {
 		return
 	}
 }
+
+func TestProxyStripe(t *testing.T) {
+	masterHandler := NewNormalAcessHandler("master", nil)
+	masterHandler.LoginAccess["slaver"] = "abc"
+	masterHandler.LoginAccess["caller"] = "abc"
+	masterHandler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", masterHandler)
+	err := master.ListenMaster(":9232")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	slaverHandler := NewNormalAcessHandler("slaver", DialRawF(func(sid uint64, uri string) (conn Conn, err error) {
+		conn = NewRawConn("echo", xio.NewEchoConn(), 1024, sid, uri)
+		return
+	}))
+	slaverHandler.DialAccess = [][]string{{".*", ".*"}}
+	slaver := NewProxy("slaver", slaverHandler)
+	defer slaver.Close()
+	caller := NewProxy("caller", NewNoneHandler())
+	defer caller.Close()
+	for i := 0; i < 2; i++ {
+		option := xmap.M{"remote": "localhost:9232", "token": "abc", "index": i, "stripe": 1}
+		_, _, err = slaver.Login(option)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _, err = caller.Login(option)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if selected, _ := caller.SelectChannel("master"); selected.Index() != -1 {
+		t.Error("error")
+		return
+	}
+	conna, connb, _ := xio.Pipe()
+	_, err = caller.SyncDial("master->slaver->xx", connb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	transfer := func(n int) {
+		for i := 0; i < n; i++ {
+			data := []byte(fmt.Sprintf("data->%v", i))
+			_, err = conna.Write(data)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			back := make([]byte, len(data))
+			err = xio.FullBuffer(conna, back, uint32(len(back)), nil)
+			if err != nil || !bytes.Equal(data, back) {
+				t.Errorf("err:%v,data:%v,back:%v", err, string(data), string(back))
+				return
+			}
+		}
+	}
+	transfer(100)
+	//close one channel, the session should be keeped
+	caller.channelLck.RLock()
+	first := caller.channel["master"].channels[0]
+	caller.channelLck.RUnlock()
+	first.Close()
+	time.Sleep(100 * time.Millisecond)
+	transfer(100)
+	conna.Close()
+	//close one channel on transfering, the frames in flight should be re-sent
+	if _, _, err = caller.Login(xmap.M{"remote": "localhost:9232", "token": "abc", "index": 0, "stripe": 1}); err != nil {
+		t.Error(err)
+		return
+	}
+	conna, connb, _ = xio.Pipe()
+	_, err = caller.SyncDial("master->slaver->xx", connb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data := bytes.Repeat([]byte("0123456789"), 400000)
+	go func() {
+		for i := 0; i < len(data); i += 1000 {
+			conna.Write(data[i : i+1000])
+		}
+	}()
+	back := make([]byte, len(data))
+	_, err = io.ReadFull(conna, back[:len(data)/2])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//wait frames are queued on channels
+	time.Sleep(100 * time.Millisecond)
+	master.channelLck.RLock()
+	second := master.channel["caller"].channels[1].(*Channel)
+	master.channelLck.RUnlock()
+	caller.channelLck.RLock()
+	secondRemote := caller.channel["master"].channels[1].(*Channel)
+	caller.channelLck.RUnlock()
+	//close raw connection on both side without flushing pending frames
+	second.ReadWriteCloser.Close()
+	secondRemote.ReadWriteCloser.Close()
+	received := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(conna, back[len(data)/2:])
+		received <- err
+	}()
+	select {
+	case err = <-received:
+	case <-time.After(5 * time.Second):
+		err = fmt.Errorf("timeout")
+	}
+	if err != nil || !bytes.Equal(data, back) {
+		t.Errorf("err:%v", err)
+		return
+	}
+	conna.Close()
+	//stripe error
+	bond := newBondChannel("test", 1)
+	_, err = bond.conn.WriteFrame(make([]byte, 13))
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	err = bond.conn.Receive(nil, make([]byte, 13), nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i <= stripeMaxPending/(1<<20); i++ {
+		frame := make([]byte, 22+1<<20)
+		frame[4] = CmdStripe
+		binary.BigEndian.PutUint64(frame[13:], uint64(i+1))
+		frame[21] = CmdData
+		err = bond.conn.Receive(nil, frame, nil)
+	}
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestStripeResend(t *testing.T) {
+	bond := newBondChannel("test", 1)
+	links := []*Channel{}
+	remotes := []frame.ReadWriteCloser{}
+	for i := 0; i < 2; i++ {
+		conna, connb, _ := xio.Pipe()
+		link := &Channel{ReadWriteCloser: frame.NewReadWriteCloser(conna, 1024), cid: uint64(i + 1), name: "test", index: i, context: xmap.M{}, stripe: true}
+		links = append(links, link)
+		remotes = append(remotes, frame.NewReadWriteCloser(connb, 1024))
+		bond.channels[i] = link
+		defer conna.Close()
+		defer connb.Close()
+	}
+	readStripe := func(remote frame.ReadWriteCloser) (seq uint64) {
+		buf, err := remote.ReadFrame()
+		if err != nil || buf[4] != CmdStripe {
+			t.Errorf("%v,%v", err, buf)
+			return
+		}
+		return binary.BigEndian.Uint64(buf[13:])
+	}
+	//frame 0 is sent to link 1, frame 1 is sent to link 0
+	buffer := make([]byte, 14)
+	buffer[4] = CmdData
+	binary.BigEndian.PutUint64(buffer[5:], 100)
+	go bond.conn.WriteFrame(buffer)
+	if seq := readStripe(remotes[1]); seq != 0 {
+		t.Error(seq)
+		return
+	}
+	go bond.conn.WriteFrame(buffer)
+	if seq := readStripe(remotes[0]); seq != 1 {
+		t.Error(seq)
+		return
+	}
+	//link 1 is lost, frame 0 is re-sent to link 0
+	delete(bond.channels, 1)
+	go bond.conn.resend(links[1])
+	if seq := readStripe(remotes[0]); seq != 0 {
+		t.Error(seq)
+		return
+	}
+	//ack
+	ack := make([]byte, 22)
+	ack[4] = CmdStripe
+	binary.BigEndian.PutUint64(ack[5:], 100)
+	binary.BigEndian.PutUint64(ack[13:], 1)
+	ack[21] = stripeAck
+	bond.conn.Receive(links[0], ack, nil)
+	if len(bond.conn.unacked[100]) != 1 {
+		t.Error("error")
+		return
+	}
+	binary.BigEndian.PutUint64(ack[13:], 2)
+	bond.conn.Receive(links[0], ack, nil)
+	if len(bond.conn.unacked) != 0 {
+		t.Error("error")
+		return
+	}
+	//receive duplicate and closed
+	received := 0
+	process := func(frame []byte) error {
+		received++
+		return nil
+	}
+	stripe := func(seq uint64, cmd byte) []byte {
+		frame := make([]byte, 23)
+		frame[4] = CmdStripe
+		binary.BigEndian.PutUint64(frame[5:], 200)
+		binary.BigEndian.PutUint64(frame[13:], seq)
+		frame[21] = cmd
+		return frame
+	}
+	readAck := func(next uint64) bool {
+		buf, err := remotes[0].ReadFrame()
+		if err != nil || buf[21] != stripeAck || binary.BigEndian.Uint64(buf[13:]) != next {
+			t.Errorf("%v,%v", err, buf)
+			return false
+		}
+		return true
+	}
+	bond.conn.Receive(links[0], stripe(0, CmdData), process)
+	//duplicate is acked again
+	go bond.conn.Receive(links[0], stripe(0, CmdData), process)
+	if !readAck(1) {
+		return
+	}
+	if received != 1 {
+		t.Error(received)
+		return
+	}
+	go bond.conn.Receive(links[0], stripe(1, CmdClosed), process)
+	if !readAck(2) {
+		return
+	}
+	//re-sent frame after closed is acked
+	go bond.conn.Receive(links[0], stripe(1, CmdClosed), process)
+	if !readAck(2) {
+		return
+	}
+	if received != 2 || len(bond.conn.receiving) != 0 {
+		t.Error(received)
+		return
+	}
+	for i := 0; i <= stripeMaxClosed; i++ {
+		bond.conn.stripeLck.Lock()
+		bond.conn.closeSession(uint64(1000 + i))
+		bond.conn.closeSession(uint64(1000 + i))
+		bond.conn.stripeLck.Unlock()
+	}
+	if len(bond.conn.closed) != stripeMaxClosed || bond.conn.closed[200] {
+		t.Error("error")
+		return
+	}
+	bond.conn.reset()
+	if len(bond.conn.closed) != 0 {
+		t.Error("error")
+		return
+	}
+	//not stripe channel is not used
+	links[0].stripe = false
+	if _, err := bond.conn.WriteFrame(buffer); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestProxyStripeNegotiate(t *testing.T) {
+	masterHandler := NewNormalAcessHandler("master", nil)
+	masterHandler.LoginAccess["caller"] = "abc"
+	master := NewProxy("master", masterHandler)
+	master.Capabilities = []string{CapHalfClose}
+	err := master.ListenMaster(":9242")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	caller := NewProxy("caller", NewNoneHandler())
+	defer caller.Close()
+	for i := 0; i < 2; i++ {
+		if _, _, err = caller.Login(xmap.M{"remote": "localhost:9242", "token": "abc", "index": i, "stripe": 1}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	//not striped when master is not capable
+	if selected, err := caller.SelectChannel("master"); err != nil || selected.Index() == -1 {
+		t.Errorf("%v,%v", err, selected)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	if selected, err := master.SelectChannel("caller"); err != nil || selected.Index() == -1 {
+		t.Errorf("%v,%v", err, selected)
+		return
+	}
+	//stripe is reset when all channel is removed
+	caller.channelLck.RLock()
+	bond := caller.channel["master"]
+	caller.channelLck.RUnlock()
+	bond.channelLck.Lock()
+	bond.channels[0].(*Channel).stripe = true
+	bond.updateStripe()
+	bond.channelLck.Unlock()
+	for _, channel := range []int{0, 1} {
+		bond.channelLck.RLock()
+		c := bond.channels[channel]
+		bond.channelLck.RUnlock()
+		c.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+	bond.channelLck.RLock()
+	stripe := bond.stripe
+	bond.channelLck.RUnlock()
+	if stripe {
+		t.Error("error")
+		return
+	}
+}
+
+func TestProxyDrain(t *testing.T) {
+	masterHandler := NewNormalAcessHandler("master", nil)
+	masterHandler.LoginAccess["slaver"] = "abc"
+	masterHandler.LoginAccess["caller"] = "abc"
+	masterHandler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", masterHandler)
+	err := master.ListenMaster(":9235")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	slaverHandler := NewNormalAcessHandler("slaver", DialRawF(func(sid uint64, uri string) (conn Conn, err error) {
+		conn = NewRawConn("echo", xio.NewEchoConn(), 1024, sid, uri)
+		return
+	}))
+	slaverHandler.DialAccess = [][]string{{".*", ".*"}}
+	slaver := NewProxy("slaver", slaverHandler)
+	defer slaver.Close()
+	caller := NewProxy("caller", NewNoneHandler())
+	defer caller.Close()
+	for i := 0; i < 2; i++ {
+		option := xmap.M{"remote": "localhost:9235", "token": "abc", "index": i}
+		_, _, err = slaver.Login(option)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _, err = caller.Login(option)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	conna, connb, _ := xio.Pipe()
+	_, err = caller.SyncDial("master->slaver->xx", connb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	drained := make(chan int, 1)
+	go func() {
+		drained <- master.Drain(3 * time.Second)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if !master.Draining() || master.Sessions() != 1 {
+		t.Error("error")
+		return
+	}
+	state := caller.State(xmap.M{"*": "*"})
+	if state.Value("channels/master/_0/draining") != true || state.Value("channels/master/_1/draining") != true {
+		t.Error(converter.JSON(state))
+		return
+	}
+	//new dial is rejected
+	_, conn, _ := xio.Pipe()
+	_, err = caller.SyncDial("master->slaver->xx", conn)
+	if err == nil || !strings.Contains(err.Error(), "draining") {
+		t.Error(err)
+		return
+	}
+	//exists session is working
+	fmt.Fprintf(conna, "abc")
+	back := make([]byte, 3)
+	err = xio.FullBuffer(conna, back, 3, nil)
+	if err != nil || string(back) != "abc" {
+		t.Error(err)
+		return
+	}
+	conna.Close()
+	select {
+	case remain := <-drained:
+		if remain != 0 {
+			t.Error(remain)
+			return
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("drain timeout")
+		return
+	}
+	//prefer not draining channel
+	c0, _ := caller.SelectChannel("master")
+	c0.(*Channel).draining = 0
+	for i := 0; i < 3; i++ {
+		if selected, _ := caller.SelectChannel("master"); selected != c0 {
+			t.Error("error")
+			return
+		}
+	}
+	if master.Drain(time.Millisecond) != 0 {
+		t.Error("error")
+		return
+	}
+}
+
+func TestProxyDrainReconnect(t *testing.T) {
+	handler := NewNormalAcessHandler("master", nil)
+	handler.LoginAccess["slaver"] = "abc"
+	handler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", handler)
+	err := master.ListenMaster(":9244")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	option := xmap.M{"remote": "localhost:9244", "token": "abc", "index": 0}
+	//old process
+	old := NewProxy("slaver", NewNoneHandler())
+	old.ReconnectDelay = 10 * time.Millisecond
+	defer old.Close()
+	_, _, err = old.Login(option)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//new process login after upgrade, the channel of old process is kicked by master
+	old.SetDraining(true)
+	upgraded := NewProxy("slaver", NewNoneHandler())
+	upgraded.ReconnectDelay = 10 * time.Millisecond
+	defer upgraded.Close()
+	channel, _, err := upgraded.Login(option)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	drained := make(chan int, 1)
+	go func() {
+		drained <- old.Drain(300 * time.Millisecond)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if _, err = old.SelectChannel("master"); err == nil {
+		t.Error("old is reconnected")
+		return
+	}
+	if current, _ := upgraded.SelectChannel("master"); current != channel {
+		t.Error("new is kicked")
+		return
+	}
+	<-drained
+	//new process is reconnecting as normal
+	channel.Close()
+	time.Sleep(100 * time.Millisecond)
+	if _, err = old.SelectChannel("master"); err == nil {
+		t.Error("old is reconnected")
+		return
+	}
+	if _, err = upgraded.SelectChannel("master"); err != nil {
+		t.Error(err)
+		return
+	}
+	//reconnect after draining is canceled
+	old.SetDraining(false)
+	if !old.reconnecting() {
+		t.Error("error")
+		return
+	}
+}
+
+func TestProxyHalfClose(t *testing.T) {
+	//the server read all request until EOF, then write response
+	dialServer := func(sid uint64, uri string) (conn Conn, err error) {
+		local, remote, _ := dialer.CreatePipedConn()
+		go func() {
+			data, _ := ioutil.ReadAll(remote)
+			fmt.Fprintf(remote, "len:%v", len(data))
+			remote.Close()
+		}()
+		conn = NewRawConn("server", local, 1024, sid, uri)
+		return
+	}
+	masterHandler := NewNormalAcessHandler("master", nil)
+	masterHandler.LoginAccess["slaver"] = "abc"
+	masterHandler.LoginAccess["caller"] = "abc"
+	masterHandler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", masterHandler)
+	err := master.ListenMaster(":9236")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	slaverHandler := NewNormalAcessHandler("slaver", DialRawF(dialServer))
+	slaverHandler.DialAccess = [][]string{{".*", ".*"}}
+	slaver := NewProxy("slaver", slaverHandler)
+	defer slaver.Close()
+	caller := NewProxy("caller", NewNormalAcessHandler("caller", DialRawF(dialServer)))
+	defer caller.Close()
+	option := xmap.M{"remote": "localhost:9236", "token": "abc", "index": 0}
+	if _, _, err = slaver.Login(option); err != nil {
+		t.Error(err)
+		return
+	}
+	channel, _, err := caller.Login(option)
+	if err != nil || !channel.Capable(CapHalfClose) {
+		t.Errorf("%v,%v", err, channel)
+		return
+	}
+	for _, uri := range []string{"master->slaver->xx", "xx"} {
+		conna, connb, _ := dialer.CreatePipedConn()
+		_, err = caller.SyncDial(uri, connb)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conna.Write(bytes.Repeat([]byte("x"), 10000))
+		conna.CloseWrite()
+		back, err := ioutil.ReadAll(conna)
+		if err != nil || string(back) != "len:10000" {
+			t.Errorf("%v,%v", err, string(back))
+			return
+		}
+		conna.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if master.Sessions() != 0 || slaver.Sessions() != 0 || caller.Sessions() != 0 {
+		t.Errorf("%v,%v,%v", master.Sessions(), slaver.Sessions(), caller.Sessions())
+		return
+	}
+	//next is not supported half-close
+	delete(channel.capabilities, CapHalfClose)
+	conna, connb, _ := dialer.CreatePipedConn()
+	_, err = caller.SyncDial("master->slaver->xx", connb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conna.CloseWrite()
+	back, err := ioutil.ReadAll(conna)
+	if err != nil || len(back) != 0 {
+		t.Errorf("%v,%v", err, string(back))
+		return
+	}
+	conna.Close()
+	//not supported raw
+	if rawCloseWriter(nil) != nil || rawCloseWriter(xio.NewEchoConn()) != nil || rawCloseWriter(NewWaitedPiper()) != nil {
+		t.Error("error")
+		return
+	}
+	raw := NewRawConn("echo", xio.NewEchoConn(), 1024, 1, "xx")
+	if raw.CloseWrite() == nil {
+		t.Error("error")
+		return
+	}
+	closeWriteOrClose(raw, nil)
+	raw.WaitWrite()
+}
+
+func TestProxyDialError(t *testing.T) {
+	masterHandler := NewNormalAcessHandler("master", nil)
+	masterHandler.LoginAccess["slaver"] = "abc"
+	masterHandler.LoginAccess["slaver2"] = "abc"
+	masterHandler.LoginAccess["caller"] = "abc"
+	masterHandler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", masterHandler)
+	err := master.ListenMaster(":9237")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	slaverHandler := NewNormalAcessHandler("slaver", DialRawF(func(sid uint64, uri string) (conn Conn, err error) {
+		raw, err := net.Dial("tcp", "127.0.0.1:1")
+		if err == nil {
+			conn = NewRawConn("raw", raw, 1024, sid, uri)
+		}
+		return
+	}))
+	slaverHandler.DialAccess = [][]string{{".*", ".*"}}
+	slaver := NewProxy("slaver", slaverHandler)
+	defer slaver.Close()
+	//slaver2 is not allowed to dial
+	slaver2 := NewProxy("slaver2", NewNormalAcessHandler("slaver2", nil))
+	defer slaver2.Close()
+	caller := NewProxy("caller", NewNoneHandler())
+	defer caller.Close()
+	option := xmap.M{"remote": "localhost:9237", "token": "abc", "index": 0}
+	if _, _, err = slaver.Login(option); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, _, err = slaver2.Login(option); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, _, err = caller.Login(option); err != nil {
+		t.Error(err)
+		return
+	}
+	dialCode := func(uri string) int {
+		_, conn, _ := xio.Pipe()
+		_, err := caller.SyncDial(uri, conn)
+		if dialErr, ok := err.(*DialError); ok {
+			return dialErr.ErrCode
+		}
+		return 0
+	}
+	if code := dialCode("master->none->xx"); code != DialErrNoChannel {
+		t.Error(code)
+		return
+	}
+	if code := dialCode("none->xx"); code != DialErrNoChannel {
+		t.Error(code)
+		return
+	}
+	if code := dialCode("master->slaver->tcp://127.0.0.1:1"); code != DialErrRefused {
+		t.Error(code)
+		return
+	}
+	if code := dialCode("master->slaver2->xx"); code != DialErrDenied {
+		t.Error(code)
+		return
+	}
+	master.Drain(0)
+	if code := dialCode("master->slaver->xx"); code != DialErrDraining {
+		t.Error(code)
+		return
+	}
+}
+
+func TestDialError(t *testing.T) {
+	for code, expect := range map[int][]int{
+		DialErrFail:        {0x01, http.StatusBadGateway},
+		DialErrInvalid:     {0x01, http.StatusBadGateway},
+		DialErrDenied:      {0x02, http.StatusForbidden},
+		DialErrNoChannel:   {0x03, http.StatusServiceUnavailable},
+		DialErrDraining:    {0x03, http.StatusServiceUnavailable},
+		DialErrRefused:     {0x05, http.StatusBadGateway},
+		DialErrUnreachable: {0x04, http.StatusBadGateway},
+		DialErrTimeout:     {0x04, http.StatusGatewayTimeout},
+	} {
+		err := newDialError(code, "test")
+		if int(err.Code()) != expect[0] || err.HTTPStatus() != expect[1] || err.Error() != "test" {
+			t.Errorf("%v,%v,%v", code, err.Code(), err.HTTPStatus())
+			return
+		}
+	}
+	//code by error
+	_, dnsErr := net.Dial("tcp", "none.invalid:80")
+	for err, code := range map[error]int{
+		fmt.Errorf("xx"):                        DialErrFail,
+		ErrRouteTimeout:                         DialErrTimeout,
+		&dialer.CodeError{ByteCode: 0x02}:       DialErrDenied,
+		&dialer.CodeError{ByteCode: 0x04}:       DialErrUnreachable,
+		&dialer.CodeError{ByteCode: 0x05}:       DialErrRefused,
+		&dialer.CodeError{ByteCode: 0x06}:       DialErrTimeout,
+		&dialer.CodeError{ByteCode: 0x01}:       DialErrFail,
+		&net.OpError{Err: syscall.ECONNREFUSED}: DialErrRefused,
+		&net.OpError{Err: syscall.EHOSTUNREACH}: DialErrUnreachable,
+		dnsErr:                                  DialErrUnreachable,
+	} {
+		if dialErrorCode(err) != code {
+			t.Errorf("%v,%v", err, dialErrorCode(err))
+			return
+		}
+	}
+	//parse dial back
+	if err := parseDialBack([]byte(`{"code":4,"message":"xx"}`)); err.ErrCode != DialErrNoChannel || err.Message != "xx" {
+		t.Error(err)
+		return
+	}
+	if err := parseDialBack([]byte("dial fail")); err.ErrCode != DialErrFail || err.Message != "dial fail" {
+		t.Error(err)
+		return
+	}
+}
+
+func TestProxyCapabilities(t *testing.T) {
+	masterHandler := NewNormalAcessHandler("master", nil)
+	masterHandler.LoginAccess["caller"] = "abc"
+	masterHandler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", masterHandler)
+	master.Router.Capabilities = []string{CapHalfClose, "unknown"}
+	err := master.ListenMaster(":9238")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	caller := NewProxy("caller", NewNoneHandler())
+	defer caller.Close()
+	channel, _, err := caller.Login(xmap.M{"remote": "localhost:9238", "token": "abc", "index": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if channel.Version() != ProtocolVersion || !channel.Capable(CapHalfClose) || channel.Capable(CapDialCode) || channel.Capable("unknown") {
+		t.Errorf("%v,%v", channel.Version(), channel.capabilities)
+		return
+	}
+	state := caller.State(xmap.M{"*": "*"})
+	if state.Value("channels/master/_0/version") != ProtocolVersion || len(state.ArrayStrDef(nil, "channels/master/_0/capabilities")) != 1 {
+		t.Error(converter.JSON(state))
+		return
+	}
+	//dial code is not supported by caller on master
+	_, conn, _ := xio.Pipe()
+	_, err = caller.SyncDial("master->none->xx", conn)
+	if dialErr, ok := err.(*DialError); !ok || dialErr.ErrCode != DialErrFail || !strings.Contains(dialErr.Message, "channel not exist") {
+		t.Error(err)
+		return
+	}
+	//remote without version
+	old := &Channel{}
+	old.negotiate(Capabilities, xmap.M{})
+	if old.Version() != 1 || old.Capable(CapHalfClose) {
+		t.Error("error")
+		return
+	}
+	if capable(nil, CapHalfClose) || capable(newBondChannel("x", 1).conn, CapHalfClose) {
+		t.Error("error")
+		return
+	}
+}
@@ -0,0 +1,235 @@
+package bsck
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+const (
+	//RouteModeOrder will try routes by configured order
+	RouteModeOrder = "order"
+	//RouteModeHealth will try routes by last dial used time
+	RouteModeHealth = "health"
+)
+
+//ErrRouteTimeout is the error when route dial back is not received in timeout
+var ErrRouteTimeout error = newDialError(DialErrTimeout, "route dial timeout")
+
+type routeHealth struct {
+	Failed  int64         //last failed time in ms
+	Used    time.Duration //last success dial used
+	Success int64         //success count
+	Fail    int64         //fail count
+	Error   string        //last error
+}
+
+//RouteGroup is the alternate routes by name, it will try next route when dial fail on remote or timeout
+//and delay the failed route to last in cooldown.
+type RouteGroup struct {
+	Routes   map[string][]string //the routes by name
+	Mode     string              //the mode to sort routes, order/health is supported
+	Cooldown time.Duration       //the delay time of failed route
+	Timeout  time.Duration       //the timeout of waiting dial back
+	health   map[string]*routeHealth
+	lck      sync.RWMutex
+}
+
+//NewRouteGroup will return new RouteGroup
+func NewRouteGroup() (group *RouteGroup) {
+	group = &RouteGroup{
+		Routes:   map[string][]string{},
+		Mode:     RouteModeOrder,
+		Cooldown: 30 * time.Second,
+		Timeout:  10 * time.Second,
+		health:   map[string]*routeHealth{},
+		lck:      sync.RWMutex{},
+	}
+	return
+}
+
+//Reload will replace all routes and keep the health of exists route
+func (r *RouteGroup) Reload(routes map[string][]string) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if routes == nil {
+		routes = map[string][]string{}
+	}
+	r.Routes = routes
+}
+
+//Find will return the routes to try by name, the failed route in cooldown is sorted to last
+func (r *RouteGroup) Find(name string) (routes []string, ok bool) {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	all, ok := r.Routes[name]
+	if !ok {
+		return
+	}
+	now := time.Now().UnixNano() / 1e6
+	cooldown := int64(r.Cooldown / time.Millisecond)
+	var normal, failed []string
+	for _, route := range all {
+		health := r.health[route]
+		if health != nil && now-health.Failed < cooldown {
+			failed = append(failed, route)
+		} else {
+			normal = append(normal, route)
+		}
+	}
+	if r.Mode == RouteModeHealth {
+		sort.SliceStable(normal, func(i, j int) bool {
+			return r.usedNoLock(normal[i]) < r.usedNoLock(normal[j])
+		})
+	}
+	sort.SliceStable(failed, func(i, j int) bool {
+		return r.health[failed[i]].Failed < r.health[failed[j]].Failed
+	})
+	routes = append(normal, failed...)
+	return
+}
+
+func (r *RouteGroup) usedNoLock(route string) time.Duration {
+	health := r.health[route]
+	if health == nil || health.Success < 1 {
+		return time.Duration(1<<63 - 1)
+	}
+	return health.Used
+}
+
+//Done will record the dial result of route
+func (r *RouteGroup) Done(route string, used time.Duration, err error) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	health := r.health[route]
+	if health == nil {
+		health = &routeHealth{}
+		r.health[route] = health
+	}
+	if err == nil {
+		health.Failed = 0
+		health.Used = used
+		health.Success++
+		health.Error = ""
+	} else {
+		health.Failed = time.Now().UnixNano() / 1e6
+		health.Fail++
+		health.Error = err.Error()
+	}
+}
+
+//State will return the route health state
+func (r *RouteGroup) State() (state xmap.M) {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	state = xmap.M{}
+	for name, routes := range r.Routes {
+		group := xmap.M{}
+		for _, route := range routes {
+			health := r.health[route]
+			if health == nil {
+				group[route] = xmap.M{}
+				continue
+			}
+			group[route] = xmap.M{
+				"failed":  health.Failed,
+				"used":    health.Used.Milliseconds(),
+				"success": health.Success,
+				"fail":    health.Fail,
+				"error":   health.Error,
+			}
+		}
+		state[name] = group
+	}
+	return
+}
+
+//routeWaiter is the raw wrapper to dial one route, it will not close raw before dial success,
+//so that raw can be dialed on next route when dial fail.
+type routeWaiter struct {
+	io.ReadWriteCloser
+	state  int //0 is pending, 1 is success, 2 is failed
+	failed error
+	done   chan int
+	lck    sync.Mutex
+}
+
+func newRouteWaiter(raw io.ReadWriteCloser) (waiter *routeWaiter) {
+	waiter = &routeWaiter{
+		ReadWriteCloser: raw,
+		done:            make(chan int),
+		lck:             sync.Mutex{},
+	}
+	return
+}
+
+func (r *routeWaiter) finish(state int, failed error) bool {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.state != 0 {
+		return false
+	}
+	r.state, r.failed = state, failed
+	close(r.done)
+	return true
+}
+
+//Ready is ReadyWaiter impl
+func (r *routeWaiter) Ready(failed error, next func(err error)) {
+	if failed != nil {
+		r.finish(2, failed)
+		return
+	}
+	if !r.finish(1, nil) {
+		//already timeout or closed, so reject the late dial back
+		if next != nil {
+			next(ErrRouteTimeout)
+		}
+		return
+	}
+	if waiter, ok := r.ReadWriteCloser.(ReadyWaiter); ok {
+		waiter.Ready(nil, next)
+	} else if next != nil {
+		go next(nil)
+	}
+}
+
+//Wait is ReadyWaiter impl
+func (r *routeWaiter) Wait() error {
+	<-r.done
+	return r.failed
+}
+
+//WaitTimeout will wait dial back in timeout
+func (r *routeWaiter) WaitTimeout(timeout time.Duration) error {
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		r.finish(2, ErrRouteTimeout)
+	}
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	return r.failed
+}
+
+//Close will close the raw after dial success, else only mark failed
+func (r *routeWaiter) Close() (err error) {
+	if r.finish(2, fmt.Errorf("closed")) {
+		return
+	}
+	r.lck.Lock()
+	state := r.state
+	r.lck.Unlock()
+	if state == 1 {
+		err = r.ReadWriteCloser.Close()
+	}
+	return
+}
+
+func (r *routeWaiter) String() string {
+	return fmt.Sprintf("%v", r.ReadWriteCloser)
+}
@@ -3,13 +3,19 @@ package bsck
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/codingeasygo/util/converter"
@@ -29,12 +35,46 @@ const (
 	CmdDialBack = 101
 	//CmdData is the command of transfter tcp data
 	CmdData = 110
+	//CmdStripe is the command of striped frame which is sequenced and spread on all channel of bond
+	CmdStripe = 111
 	//CmdClosed is the command of tcp closed.
 	CmdClosed = 120
+	//CmdCloseWrite is the command of tcp write side closed, the session is closed when both side is closed
+	CmdCloseWrite = 121
 	//CmdHeartbeat is the command of heartbeat on slaver/master
 	CmdHeartbeat = 130
 )
 
+//ProtocolVersion is the protocol version of router, it is sent by login and login back, the router without version is 1
+const ProtocolVersion = 2
+
+const (
+	//CapHalfClose is the capability of receiving CmdCloseWrite
+	CapHalfClose = "half_close"
+	//CapDialCode is the capability of receiving dial back with error code
+	CapDialCode = "dial_code"
+	//CapDialSource is the capability of receiving dial with the originating router and client
+	CapDialSource = "dial_source"
+	//CapStripe is the capability of receiving CmdStripe, the channel is striped only when both side is capable
+	CapStripe = "stripe"
+)
+
+//Capabilities is the default capabilities of router
+var Capabilities = []string{CapHalfClose, CapDialCode, CapDialSource, CapStripe}
+
+const (
+	//SelectUsed is the channel select strategy by least used count
+	SelectUsed = "used"
+	//SelectActive is the channel select strategy by least active session
+	SelectActive = "active"
+	//SelectRTT is the channel select strategy by lowest heartbeat round trip time
+	SelectRTT = "rtt"
+	//SelectWeight is the channel select strategy by used count weighted by channel option
+	SelectWeight = "weight"
+	//SelectSticky is the channel select strategy by pinning the client to one channel
+	SelectSticky = "sticky"
+)
+
 const (
 	//ConnTypeRaw is the type of raw connection
 	ConnTypeRaw = 100
@@ -54,8 +94,12 @@ func cmdString(cmd byte) string {
 		return "DialBack"
 	case CmdData:
 		return "Data"
+	case CmdStripe:
+		return "Stripe"
 	case CmdClosed:
 		return "Closed"
+	case CmdCloseWrite:
+		return "CloseWrite"
 	case CmdHeartbeat:
 		return "Heartbeat"
 	default:
@@ -101,6 +145,35 @@ type writeDeadlinable interface {
 	SetWriteDeadline(t time.Time) error
 }
 
+type closeWriter interface {
+	CloseWrite() error
+}
+
+//rawCloseWriter will return the closeWriter of raw connection, return nil if half-close is not supported
+func rawCloseWriter(raw interface{}) closeWriter {
+	for raw != nil {
+		switch r := raw.(type) {
+		case *routeWaiter:
+			raw = r.ReadWriteCloser
+		case *WaitedPiper:
+			raw = r.Base
+		case closeWriter:
+			return r
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+//closeWriteOrClose will close the write side of raw connection when err is nil and half-close is supported, else close it
+func closeWriteOrClose(raw io.ReadWriteCloser, err error) {
+	if writer := rawCloseWriter(raw); err == nil && writer != nil && writer.CloseWrite() == nil {
+		return
+	}
+	raw.Close()
+}
+
 //RawConn is an implementation of the Conn interface for raw network connections.
 type RawConn struct {
 	//the raw connection
@@ -114,6 +187,8 @@ type RawConn struct {
 	writeTimeout time.Duration
 	ready        int
 	closed       int
+	writeClosed  chan int
+	cipher       *e2eCipher
 	failed       error
 	readyLocker  sync.RWMutex
 	closeLocker  sync.RWMutex
@@ -128,8 +203,9 @@ func NewRawConn(name string, raw io.ReadWriteCloser, bufferSize int, sid uint64,
 		uri:             uri,
 		readyLocker:     sync.RWMutex{},
 		closeLocker:     sync.RWMutex{},
-		buffer:          make([]byte, bufferSize),
+		buffer:          Buffers.Get(bufferSize),
 		context:         xmap.M{},
+		writeClosed:     make(chan int),
 	}
 	conn.readyLocker.Lock()
 	return
@@ -149,13 +225,34 @@ func (r *RawConn) Read(b []byte) (n int, err error) {
 
 //ReadFrame will read frame from raw
 func (r *RawConn) ReadFrame() (frame []byte, err error) {
+	size := len(r.buffer)
+	if r.cipher != nil {
+		if !r.cipher.Sent() {
+			frame = r.makeFrame(copy(r.buffer[13:], r.cipher.Hello()))
+			return
+		}
+		err = r.cipher.Wait()
+		if err != nil {
+			return
+		}
+		size -= r.cipher.send.Overhead()
+	}
 	if timeout, ok := r.ReadWriteCloser.(readDeadlinable); r.readTimeout > 0 && ok {
 		timeout.SetReadDeadline(time.Now().Add(r.readTimeout))
 	}
-	n, err := r.ReadWriteCloser.Read(r.buffer[13:])
+	n, err := r.ReadWriteCloser.Read(r.buffer[13:size])
 	if err != nil {
 		return
 	}
+	if r.cipher != nil {
+		n = len(r.cipher.Seal(r.buffer[13:13], r.buffer[13:13+n]))
+	}
+	frame = r.makeFrame(n)
+	return
+}
+
+//makeFrame will return the data frame of n bytes payload in read buffer
+func (r *RawConn) makeFrame(n int) (frame []byte) {
 	binary.BigEndian.PutUint32(r.buffer, uint32(n+13))
 	r.buffer[4] = CmdData
 	binary.BigEndian.PutUint64(r.buffer[5:], r.sid)
@@ -169,14 +266,43 @@ func (r *RawConn) WriteFrame(buffer []byte) (n int, err error) {
 		err = fmt.Errorf("error frame")
 		return
 	}
+	payload := buffer[13:]
+	if r.cipher != nil {
+		if !r.cipher.Received() {
+			err = r.cipher.Handshake(payload)
+			if err != nil {
+				WarnLog("RawConn(%v) e2e handshake fail with %v", r.sid, err)
+				r.Close()
+				return
+			}
+			n = len(buffer)
+			return
+		}
+		payload, err = r.cipher.Open(payload[:0], payload)
+		if err != nil {
+			WarnLog("RawConn(%v) e2e open frame fail with %v", r.sid, err)
+			return
+		}
+	}
 	if timeout, ok := r.ReadWriteCloser.(writeDeadlinable); r.writeTimeout > 0 && ok {
 		timeout.SetWriteDeadline(time.Now().Add(r.readTimeout))
 	}
-	n, err = r.ReadWriteCloser.Write(buffer[13:])
-	n += 13
+	n, err = r.ReadWriteCloser.Write(payload)
+	n += len(buffer) - len(payload)
 	return
 }
 
+//ReleaseBuffer will put read buffer back to pool, it must be called after reader is stopped
+func (r *RawConn) ReleaseBuffer() {
+	r.closeLocker.Lock()
+	buffer := r.buffer
+	r.buffer = nil
+	r.closeLocker.Unlock()
+	if buffer != nil {
+		Buffers.Put(buffer)
+	}
+}
+
 //SetReadTimeout is read timeout setter
 func (r *RawConn) SetReadTimeout(timeout time.Duration) {
 	r.readTimeout = timeout
@@ -193,8 +319,41 @@ func (r *RawConn) SetTimeout(timeout time.Duration) {
 	r.writeTimeout = timeout
 }
 
+//CloseWrite will close the write side of raw connection, return error if raw connection is not supported
+func (r *RawConn) CloseWrite() (err error) {
+	writer := rawCloseWriter(r.ReadWriteCloser)
+	if writer == nil {
+		err = fmt.Errorf("half-close is not supported")
+		return
+	}
+	err = writer.CloseWrite()
+	if err == nil {
+		r.doneWrite()
+	}
+	return
+}
+
+func (r *RawConn) doneWrite() {
+	r.closeLocker.Lock()
+	select {
+	case <-r.writeClosed:
+	default:
+		close(r.writeClosed)
+	}
+	r.closeLocker.Unlock()
+}
+
+//WaitWrite will wait the write side is closed by CloseWrite or Close
+func (r *RawConn) WaitWrite() {
+	<-r.writeClosed
+}
+
 //Close will close the raw connection
 func (r *RawConn) Close() (err error) {
+	r.doneWrite()
+	if r.cipher != nil {
+		r.cipher.Close()
+	}
 	if _, ok := r.ReadWriteCloser.(ReadyWaiter); ok {
 		return r.ReadWriteCloser.Close()
 	}
@@ -214,14 +373,19 @@ func (r *RawConn) Close() (err error) {
 	return
 }
 
-//Wait is ConnectedWaiter impl
-func (r *RawConn) Wait() error {
+//Wait is ConnectedWaiter impl, it will wait e2e handshake done also when session is encrypted
+func (r *RawConn) Wait() (err error) {
 	if waiter, ok := r.ReadWriteCloser.(ReadyWaiter); ok {
-		return waiter.Wait()
+		err = waiter.Wait()
+	} else {
+		r.readyLocker.Lock()
+		r.readyLocker.Unlock()
+		err = r.failed
 	}
-	r.readyLocker.Lock()
-	r.readyLocker.Unlock()
-	return r.failed
+	if err == nil && r.cipher != nil {
+		err = r.cipher.Wait()
+	}
+	return
 }
 
 //Ready is ConnectedWaiter impl
@@ -293,7 +457,15 @@ type Channel struct {
 	name                  string
 	index                 int
 	context               xmap.M
-	Heartbeat             int64
+	stripe                bool
+	weight                int64
+	active                int64
+	Heartbeat             int64 //the last heartbeat time in millisecond, it is accessed by atomic
+	RTT                   int64 //the heartbeat round trip time in nanosecond, it is accessed by atomic
+	draining              int32
+	writer                *channelWriter
+	version               int
+	capabilities          map[string]bool
 }
 
 //ID is an implementation of Conn
@@ -321,47 +493,462 @@ func (c *Channel) Context() xmap.M {
 	return c.context
 }
 
-// func (c *Channel) Close() (err error) {
-// 	err = c.ReadWriteCloser.Close()
-// 	DebugLog("Channel %v is closed by \n%v", c, debug.CallStack())
-// 	return
-// }
+//WriteFrame will write frame by channel writer if writer is started, else write to raw directly
+func (c *Channel) WriteFrame(buffer []byte) (n int, err error) {
+	if c.writer == nil {
+		n, err = c.ReadWriteCloser.WriteFrame(buffer)
+	} else {
+		n, err = c.writer.WriteFrame(buffer)
+	}
+	return
+}
+
+//Close will flush the pending frame on channel writer and close the raw connection
+func (c *Channel) Close() (err error) {
+	if c.writer != nil {
+		c.writer.Close()
+	}
+	err = c.ReadWriteCloser.Close()
+	return
+}
+
+//Version will return the protocol version of remote router
+func (c *Channel) Version() int {
+	return c.version
+}
+
+//Capable will return if the capability is supported by both local and remote router
+func (c *Channel) Capable(name string) bool {
+	return c.capabilities[name]
+}
+
+//negotiate will set the remote version and the capabilities which is supported by both local and remote
+func (c *Channel) negotiate(local []string, remote xmap.M) {
+	c.version = remote.IntDef(1, "version")
+	supported := map[string]bool{}
+	for _, name := range remote.ArrayStrDef(nil, "capabilities") {
+		supported[name] = true
+	}
+	c.capabilities = map[string]bool{}
+	for _, name := range local {
+		if supported[name] {
+			c.capabilities[name] = true
+		}
+	}
+}
 
 func (c *Channel) String() string {
 	return fmt.Sprintf("channel{name:%v,index:%v,cid:%v,info:%v}", c.name, c.index, c.cid, c.ReadWriteCloser)
 }
 
 type bondChannel struct {
+	name       string
 	channels   map[int]Conn
 	used       map[int]uint64
+	stripe     bool
+	conn       *bondConn
+	priority   map[uint64]int
 	channelLck sync.RWMutex
 }
 
-func newBondChannel() *bondChannel {
-	return &bondChannel{
+func newBondChannel(name string, id uint64) (bond *bondChannel) {
+	bond = &bondChannel{
+		name:       name,
 		channels:   map[int]Conn{},
 		used:       map[int]uint64{},
+		priority:   map[uint64]int{},
 		channelLck: sync.RWMutex{},
 	}
+	bond.conn = newBondConn(bond, id)
+	return
+}
+
+//SetPriority will set the priority class of striped session on all channels of bond,
+//the class is kept and set on the channel which is added to bond later
+func (b *bondChannel) SetPriority(sid uint64, class int) {
+	b.channelLck.Lock()
+	if class == prioNormal {
+		delete(b.priority, sid)
+	} else {
+		b.priority[sid] = class
+	}
+	for _, channel := range b.channels {
+		priorityChannel(channel, sid, class)
+	}
+	b.channelLck.Unlock()
+}
+
+//updateStripe will update stripe flag by channels, it must be called with channelLck locked
+func (b *bondChannel) updateStripe() {
+	b.stripe = false
+	for _, channel := range b.channels {
+		if c, ok := channel.(*Channel); ok && c.stripe {
+			b.stripe = true
+			break
+		}
+	}
 }
 
-//TableRouter is the router table item
-type TableRouter []interface{}
+const (
+	//stripeMaxPending is the max bytes of out-of-order frames cached on one striped session,
+	//it must be larger than the bytes in flight on one channel which is pending on writer and socket
+	stripeMaxPending = 16 << 20
+	//stripeAckSize is the number of processed frames on one striped session to send ack back
+	stripeAckSize = 16
+	//stripeMaxClosed is the max number of closed striped session remembered to drop the re-sent frame after closed
+	stripeMaxClosed = 1024
+	//stripeAck is the inner command of stripe ack, the sequence of ack is the next sequence to receive
+	stripeAck = CmdStripe
+)
+
+type stripeReceiver struct {
+	next    uint64
+	acked   uint64
+	size    int
+	pending map[uint64][]byte
+	locker  sync.Mutex
+}
 
-//Next will return next connection and session id
-func (t TableRouter) Next(conn Conn) (target Conn, sid uint64) {
-	if t[0] == conn {
-		target = t[2].(Conn)
-		sid = t[3].(uint64)
-	} else if t[2] == conn {
-		target = t[0].(Conn)
-		sid = t[1].(uint64)
+type stripeSent struct {
+	seq     uint64
+	frame   []byte
+	channel Conn
+}
+
+//bondConn is an implementation of the Conn interface for striping session frames on all channels of bond.
+//
+//every frame writed to bondConn is wrapped as CmdStripe with sequence and sent by channels in round robin,
+//the receiver reorders frames by sequence and processes them as received from bondConn.
+//the receiver acks the next sequence every stripeAckSize frames, when session is closed and when re-sent frame is received,
+//the sender keeps the frames not acked and re-sends them on other channels when the sent channel is removed.
+type bondConn struct {
+	bond      *bondChannel
+	id        uint64
+	maxFrame  int
+	next      uint64
+	sending   map[uint64]uint64
+	unacked   map[uint64][]*stripeSent
+	receiving map[uint64]*stripeReceiver
+	closed    map[uint64]bool
+	closedSid []uint64
+	stripeLck sync.RWMutex
+}
+
+func newBondConn(bond *bondChannel, id uint64) *bondConn {
+	return &bondConn{
+		bond:      bond,
+		id:        id,
+		sending:   map[uint64]uint64{},
+		unacked:   map[uint64][]*stripeSent{},
+		receiving: map[uint64]*stripeReceiver{},
+		closed:    map[uint64]bool{},
+		stripeLck: sync.RWMutex{},
+	}
+}
+
+//sortedChannels will return the stripe channels of bond sorted by index
+func (b *bondConn) sortedChannels() (channels []Conn) {
+	b.bond.channelLck.RLock()
+	indexes := []int{}
+	for idx, channel := range b.bond.channels {
+		if c, ok := channel.(*Channel); ok && !c.stripe {
+			continue
+		}
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		channels = append(channels, b.bond.channels[idx])
 	}
+	b.bond.channelLck.RUnlock()
 	return
 }
 
-func (t TableRouter) String() string {
-	return fmt.Sprintf("%v %v <-> %v %v", t[0], t[1], t[2], t[3])
+//WriteFrame will wrap the frame as CmdStripe and write it to one channel of bond,
+//the data frame is split when the striped frame is larger than maxFrame
+func (b *bondConn) WriteFrame(buffer []byte) (n int, err error) {
+	if len(buffer) < 13 {
+		err = fmt.Errorf("error frame")
+		return
+	}
+	sid := binary.BigEndian.Uint64(buffer[5:])
+	payload := buffer[13:]
+	for {
+		part := payload
+		if buffer[4] == CmdData && b.maxFrame > 22 && len(part)+22 > b.maxFrame {
+			part = part[:b.maxFrame-22]
+		}
+		err = b.writeStripe(sid, buffer[4], part)
+		payload = payload[len(part):]
+		if err != nil || len(payload) < 1 {
+			break
+		}
+	}
+	if err == nil {
+		n = len(buffer)
+	}
+	return
+}
+
+func (b *bondConn) writeStripe(sid uint64, cmd byte, payload []byte) (err error) {
+	striped := make([]byte, len(payload)+22)
+	striped[4] = CmdStripe
+	binary.BigEndian.PutUint64(striped[5:], sid)
+	striped[21] = cmd
+	copy(striped[22:], payload)
+	b.stripeLck.Lock()
+	channels := b.sortedChannels()
+	if len(channels) < 1 {
+		b.stripeLck.Unlock()
+		err = fmt.Errorf("channel not exist by name(%v)", b.bond.name)
+		return
+	}
+	seq := b.sending[sid]
+	b.sending[sid] = seq + 1
+	if cmd == CmdClosed {
+		delete(b.sending, sid)
+	}
+	binary.BigEndian.PutUint64(striped[13:], seq)
+	b.next++
+	channel := channels[int(b.next)%len(channels)]
+	b.unacked[sid] = append(b.unacked[sid], &stripeSent{seq: seq, frame: striped, channel: channel})
+	b.stripeLck.Unlock()
+	_, writeErr := channel.WriteFrame(striped)
+	if writeErr != nil {
+		//the frame is kept as not acked and it will be re-sent when channel is removed
+		DebugLog("bond(%v) write stripe frame to %v fail with %v, will re-send on other channel", b.bond.name, channel, writeErr)
+	}
+	return
+}
+
+//resend will re-send the frames which are sent by channel and not acked to other channels of bond
+func (b *bondConn) resend(channel Conn) {
+	b.stripeLck.Lock()
+	channels := []Conn{}
+	for _, c := range b.sortedChannels() {
+		if c != channel {
+			channels = append(channels, c)
+		}
+	}
+	resent := []*stripeSent{}
+	for _, frames := range b.unacked {
+		for _, sent := range frames {
+			if sent.channel != channel || len(channels) < 1 {
+				continue
+			}
+			b.next++
+			sent.channel = channels[int(b.next)%len(channels)]
+			resent = append(resent, &stripeSent{seq: sent.seq, frame: sent.frame, channel: sent.channel})
+		}
+	}
+	b.stripeLck.Unlock()
+	if len(resent) > 0 {
+		InfoLog("bond(%v) re-send %v stripe frame of %v to other channels", b.bond.name, len(resent), channel)
+	}
+	for _, sent := range resent {
+		sent.channel.WriteFrame(sent.frame)
+	}
+}
+
+//ack will remove the frames which sequence is less than next on session
+func (b *bondConn) ack(sid, next uint64) {
+	b.stripeLck.Lock()
+	frames := b.unacked[sid]
+	acked := 0
+	for acked < len(frames) && frames[acked].seq < next {
+		acked++
+	}
+	if acked == len(frames) {
+		delete(b.unacked, sid)
+	} else if acked > 0 {
+		b.unacked[sid] = append([]*stripeSent{}, frames[acked:]...)
+	}
+	b.stripeLck.Unlock()
+}
+
+//closeSession will remove the session state and remember it is closed, it must be called with stripeLck locked
+func (b *bondConn) closeSession(sid uint64) {
+	delete(b.receiving, sid)
+	delete(b.unacked, sid)
+	if b.closed[sid] {
+		return
+	}
+	b.closed[sid] = true
+	b.closedSid = append(b.closedSid, sid)
+	if len(b.closedSid) > stripeMaxClosed {
+		delete(b.closed, b.closedSid[0])
+		b.closedSid = b.closedSid[1:]
+	}
+}
+
+//reset will clear all session state, it is called when all channels of bond are removed
+func (b *bondConn) reset() {
+	b.stripeLck.Lock()
+	b.sending = map[uint64]uint64{}
+	b.unacked = map[uint64][]*stripeSent{}
+	b.receiving = map[uint64]*stripeReceiver{}
+	b.closed = map[uint64]bool{}
+	b.closedSid = nil
+	b.stripeLck.Unlock()
+}
+
+//Receive will reorder the striped frame which is received from channel and call process by sequence
+func (b *bondConn) Receive(channel Conn, buffer []byte, process func(frame []byte) error) (err error) {
+	if len(buffer) < 22 {
+		err = fmt.Errorf("error stripe frame")
+		return
+	}
+	sid := binary.BigEndian.Uint64(buffer[5:])
+	seq := binary.BigEndian.Uint64(buffer[13:])
+	cmd := buffer[21]
+	if cmd == stripeAck {
+		b.ack(sid, seq)
+		return
+	}
+	b.stripeLck.Lock()
+	if b.closed[sid] {
+		b.stripeLck.Unlock()
+		//the frame is re-sent after session closed, the ack may be lost with removed channel
+		writeStripeAck(channel, sid, seq+1)
+		return
+	}
+	receiver := b.receiving[sid]
+	if receiver == nil {
+		receiver = &stripeReceiver{pending: map[uint64][]byte{}}
+		b.receiving[sid] = receiver
+	}
+	b.stripeLck.Unlock()
+	receiver.locker.Lock()
+	frame := buffer[9:]
+	frame[4] = cmd
+	binary.BigEndian.PutUint64(frame[5:], sid)
+	if seq < receiver.next {
+		//re-sent frame which is received, the ack may be lost with removed channel
+		next := receiver.next
+		receiver.locker.Unlock()
+		writeStripeAck(channel, sid, next)
+		return
+	}
+	if seq != receiver.next {
+		if _, ok := receiver.pending[seq]; !ok {
+			if receiver.size+len(frame) > stripeMaxPending {
+				receiver.locker.Unlock()
+				err = fmt.Errorf("stripe session(%v) pending frame is overflow", sid)
+				return
+			}
+			receiver.pending[seq] = append([]byte{}, frame...)
+			receiver.size += len(frame)
+		}
+		receiver.locker.Unlock()
+		return
+	}
+	closed := false
+	for {
+		receiver.next++
+		closed = frame[4] == CmdClosed
+		err = process(frame)
+		if err != nil || closed {
+			closed = true
+			b.stripeLck.Lock()
+			b.closeSession(sid)
+			b.stripeLck.Unlock()
+			break
+		}
+		frame = receiver.pending[receiver.next]
+		if frame == nil {
+			break
+		}
+		delete(receiver.pending, receiver.next)
+		receiver.size -= len(frame)
+	}
+	var ack uint64
+	if closed || receiver.next-receiver.acked >= stripeAckSize {
+		receiver.acked = receiver.next
+		ack = receiver.next
+	}
+	receiver.locker.Unlock()
+	if ack > 0 {
+		writeStripeAck(channel, sid, ack)
+	}
+	return
+}
+
+//writeStripeAck will send the ack of next sequence on session to channel
+func writeStripeAck(channel Conn, sid, next uint64) {
+	ack := make([]byte, 22)
+	ack[4] = CmdStripe
+	binary.BigEndian.PutUint64(ack[5:], sid)
+	binary.BigEndian.PutUint64(ack[13:], next)
+	ack[21] = stripeAck
+	channel.WriteFrame(ack)
+}
+
+//ReadFrame is not supported, the frame is read by channels of bond
+func (b *bondConn) ReadFrame() (frame []byte, err error) {
+	err = fmt.Errorf("not supported")
+	return
+}
+
+func (b *bondConn) Read(p []byte) (n int, err error) {
+	err = fmt.Errorf("not supported")
+	return
+}
+
+func (b *bondConn) Write(p []byte) (n int, err error) {
+	err = fmt.Errorf("not supported")
+	return
+}
+
+//SetReadTimeout is empty
+func (b *bondConn) SetReadTimeout(timeout time.Duration) {
+}
+
+//SetWriteTimeout is empty
+func (b *bondConn) SetWriteTimeout(timeout time.Duration) {
+}
+
+//SetTimeout is empty
+func (b *bondConn) SetTimeout(timeout time.Duration) {
+}
+
+//Close is empty, the bond is closed when all channels are closed
+func (b *bondConn) Close() (err error) {
+	return
+}
+
+//ID is an implementation of Conn
+func (b *bondConn) ID() uint64 {
+	return b.id
+}
+
+//Name is an implementation of Conn
+func (b *bondConn) Name() string {
+	return b.bond.name
+}
+
+//Index is an implementation of Conn
+func (b *bondConn) Index() int {
+	return -1
+}
+
+//Type is an implementation of Conn
+func (b *bondConn) Type() int {
+	return ConnTypeChannel
+}
+
+//Context will return the context of first channel in bond
+func (b *bondConn) Context() (context xmap.M) {
+	channels := b.sortedChannels()
+	if len(channels) > 0 {
+		context = channels[0].Context()
+	} else {
+		context = xmap.M{}
+	}
+	return
+}
+
+func (b *bondConn) String() string {
+	return fmt.Sprintf("bond{name:%v,id:%v}", b.bond.name, b.id)
 }
 
 //Handler is the interface that wraps the handler of Router.
@@ -376,34 +963,48 @@ type Handler interface {
 	OnConnClose(raw Conn) error
 }
 
+//DialCanceler is the interface to cancel the raw dial which is running by sid
+type DialCanceler interface {
+	CancelDial(sid uint64)
+}
+
 //Router is an implementation of the router control
 type Router struct {
-	Name            string        //current router name
-	BufferSize      int           //buffer size of connection runner
-	Heartbeat       time.Duration //the delay of heartbeat
-	Handler         Handler       //the router handler
+	Name            string            //current router name
+	BufferSize      int               //buffer size of connection runner
+	Heartbeat       time.Duration     //the delay of heartbeat
+	Handler         Handler           //the router handler
+	Strategy        map[string]string //the channel select strategy by channel name, * is default
+	Capabilities    []string          //the capabilities to negotiate with remote router
+	E2E             *E2EKeys          //the pinned keys of end-to-end encrypted session, it is disabled when nil
+	Forwarders      []string          //the channel names which is trusted to forward the originating router of dial
 	connectSequence uint64
 	channel         map[string]*bondChannel
 	channelLck      sync.RWMutex
-	table           map[string]TableRouter
-	tableLck        sync.RWMutex
+	table           *sessionTable
 	rawConn         map[string][]io.ReadWriteCloser
 	rawLck          sync.RWMutex
+	dialing         map[uint64]map[uint64]uint64 //the running raw dial by channel id and sid to raw sid
+	dialingLck      sync.RWMutex
+	draining        int32
 }
 
 //NewRouter will return new Router by name
 func NewRouter(name string) (router *Router) {
 	router = &Router{
-		Name:       name,
-		channel:    map[string]*bondChannel{},
-		channelLck: sync.RWMutex{},
-		table:      map[string]TableRouter{},
-		tableLck:   sync.RWMutex{},
-		rawConn:    map[string][]io.ReadWriteCloser{},
-		rawLck:     sync.RWMutex{},
-		BufferSize: 1024,
-		Heartbeat:  5 * time.Second,
-		Handler:    nil,
+		Name:         name,
+		channel:      map[string]*bondChannel{},
+		channelLck:   sync.RWMutex{},
+		table:        newSessionTable(),
+		rawConn:      map[string][]io.ReadWriteCloser{},
+		rawLck:       sync.RWMutex{},
+		dialing:      map[uint64]map[uint64]uint64{},
+		dialingLck:   sync.RWMutex{},
+		BufferSize:   1024,
+		Heartbeat:    5 * time.Second,
+		Handler:      nil,
+		Strategy:     map[string]string{},
+		Capabilities: append([]string{}, Capabilities...),
 	}
 	return
 }
@@ -423,6 +1024,7 @@ func (r *Router) Accept(raw frame.ReadWriteCloser) {
 		ReadWriteCloser: raw,
 		cid:             atomic.AddUint64(&r.connectSequence, 1),
 		context:         xmap.M{},
+		writer:          newChannelWriter(raw),
 	}
 	go r.loopReadRaw(channel)
 }
@@ -443,11 +1045,16 @@ func (r *Router) addChannel(channel Conn) {
 	r.channelLck.Lock()
 	bond := r.channel[channel.Name()]
 	if bond == nil {
-		bond = newBondChannel()
+		bond = newBondChannel(channel.Name(), atomic.AddUint64(&r.connectSequence, 1))
+		bond.conn.maxFrame = r.BufferSize
 	}
 	bond.channelLck.Lock()
 	old, _ := bond.channels[channel.Index()]
 	bond.channels[channel.Index()] = channel
+	bond.updateStripe()
+	for sid, class := range bond.priority {
+		priorityChannel(channel, sid, class)
+	}
 	bond.channelLck.Unlock()
 	r.channel[channel.Name()] = bond
 	r.channelLck.Unlock()
@@ -466,51 +1073,171 @@ func (r *Router) UniqueSid() (sid uint64) {
 
 //SelectChannel will pick one channel by name.
 func (r *Router) SelectChannel(name string) (dst Conn, err error) {
+	dst, err = r.selectChannel(name, "")
+	return
+}
+
+func (r *Router) strategy(name string) (strategy string) {
+	strategy = r.Strategy[name]
+	if len(strategy) < 1 {
+		strategy = r.Strategy["*"]
+	}
+	if len(strategy) < 1 {
+		strategy = SelectUsed
+	}
+	return
+}
+
+//selectChannel will pick one channel by name and the strategy configured to name, the client is the sticky key of originating client
+func (r *Router) selectChannel(name, client string) (dst Conn, err error) {
 	r.channelLck.RLock()
 	bond := r.channel[name]
 	if bond == nil || len(bond.channels) < 1 {
-		err = fmt.Errorf("channel not exist by name(%v)", name)
+		err = newDialError(DialErrNoChannel, "channel not exist by name(%v)", name)
 		r.channelLck.RUnlock()
 		return
 	}
 	r.channelLck.RUnlock()
 	bond.channelLck.Lock()
 	defer bond.channelLck.Unlock()
-	var index int
-	var min uint64
-	for i, c := range bond.channels {
-		used := bond.used[i]
-		if dst != nil && used > min {
-			continue
+	if bond.stripe {
+		dst = bond.conn
+		return
+	}
+	indexes := []int{}
+	for i := range bond.channels {
+		indexes = append(indexes, i)
+	}
+	if len(indexes) < 1 {
+		err = newDialError(DialErrNoChannel, "channel not exist by name(%v)", name)
+		return
+	}
+	sort.Ints(indexes)
+	info := func(i int) (active int64, rtt time.Duration, weight int64) {
+		active, rtt, weight = 0, time.Duration(math.MaxInt64), 1
+		if c, ok := bond.channels[i].(*Channel); ok {
+			active = atomic.LoadInt64(&c.active)
+			if v := atomic.LoadInt64(&c.RTT); v > 0 {
+				rtt = time.Duration(v)
+			}
+			if c.weight > 0 {
+				weight = c.weight
+			}
 		}
-		dst = c
-		min = used
-		index = i
+		return
 	}
+	var less func(a, b int) bool
+	switch r.strategy(name) {
+	case SelectActive:
+		less = func(a, b int) bool {
+			activeA, _, _ := info(a)
+			activeB, _, _ := info(b)
+			return activeA < activeB || (activeA == activeB && bond.used[a] < bond.used[b])
+		}
+	case SelectRTT:
+		less = func(a, b int) bool {
+			_, rttA, _ := info(a)
+			_, rttB, _ := info(b)
+			return rttA < rttB || (rttA == rttB && bond.used[a] < bond.used[b])
+		}
+	case SelectWeight:
+		less = func(a, b int) bool {
+			_, _, weightA := info(a)
+			_, _, weightB := info(b)
+			return bond.used[a]*uint64(weightB) < bond.used[b]*uint64(weightA)
+		}
+	case SelectSticky:
+		hash := fnv.New32a()
+		hash.Write([]byte(client))
+		sticky := indexes[int(hash.Sum32()%uint32(len(indexes)))]
+		less = func(a, b int) bool {
+			return a == sticky && b != sticky
+		}
+	default:
+		less = func(a, b int) bool {
+			return bond.used[a] < bond.used[b]
+		}
+	}
+	draining := func(i int) bool {
+		c, ok := bond.channels[i].(*Channel)
+		return ok && atomic.LoadInt32(&c.draining) > 0
+	}
+	index := indexes[0]
+	for _, i := range indexes[1:] {
+		drainingI, drainingIndex := draining(i), draining(index)
+		if (drainingIndex && !drainingI) || (drainingIndex == drainingI && less(i, index)) {
+			index = i
+		}
+	}
+	dst = bond.channels[index]
 	bond.used[index]++
 	return
 }
 
-func (r *Router) addTable(src Conn, srcSid uint64, dst Conn, dstSid uint64, conn string) {
-	r.tableLck.Lock()
-	router := []interface{}{src, srcSid, dst, dstSid, conn}
-	r.table[fmt.Sprintf("%v-%v", src.ID(), srcSid)] = router
-	r.table[fmt.Sprintf("%v-%v", dst.ID(), dstSid)] = router
-	r.tableLck.Unlock()
+func activeChannel(conn Conn, delta int64) {
+	if c, ok := conn.(*Channel); ok {
+		atomic.AddInt64(&c.active, delta)
+	}
+}
+
+func priorityChannel(conn Conn, sid uint64, class int) {
+	switch c := conn.(type) {
+	case *Channel:
+		if c.writer != nil {
+			c.writer.SetPriority(sid, class)
+		}
+	case *bondConn:
+		c.bond.SetPriority(sid, class)
+	}
+}
+
+//SessionSource will return the originating router name of the raw session by session id,
+//it will return router name when the session is dialed by local, and empty when the session is not found.
+func (r *Router) SessionSource(sid uint64) (name string) {
+	router := r.table.Find(sid, sid)
+	if router != nil {
+		if router.Dst.ID() == sid && router.Dst.Type() == ConnTypeRaw {
+			name = router.Source
+		}
+		return
+	}
+	r.rawLck.RLock()
+	defer r.rawLck.RUnlock()
+	for _, pair := range r.rawConn {
+		if conn, ok := pair[0].(Conn); ok && conn.ID() == sid && conn.Type() == ConnTypeRaw {
+			name = r.Name
+			break
+		}
+	}
 	return
 }
 
-func (r *Router) removeTable(conn Conn, sid uint64) TableRouter {
-	r.tableLck.Lock()
-	defer r.tableLck.Unlock()
-	return r.removeTableNoLock(conn, sid)
+func (r *Router) addTable(src Conn, srcSid uint64, dst Conn, dstSid uint64, conn string) {
+	r.addTableSource(src, srcSid, dst, dstSid, conn, "")
+}
+
+func (r *Router) addTableSource(src Conn, srcSid uint64, dst Conn, dstSid uint64, conn, source string) {
+	r.removeTable(src, srcSid)
+	r.removeTable(dst, dstSid)
+	r.table.Add(&TableRouter{Src: src, SrcSid: srcSid, Dst: dst, DstSid: dstSid, URI: conn, Source: source})
+	activeChannel(src, 1)
+	activeChannel(dst, 1)
+	if class := uriPriority(conn); class != prioNormal {
+		priorityChannel(src, srcSid, class)
+		priorityChannel(dst, dstSid, class)
+	}
+	return
 }
 
-func (r *Router) removeTableNoLock(conn Conn, sid uint64) TableRouter {
-	router := r.table[fmt.Sprintf("%v-%v", conn.ID(), sid)]
+func (r *Router) removeTable(conn Conn, sid uint64) *TableRouter {
+	router := r.table.Remove(conn.ID(), sid)
 	if router != nil {
-		delete(r.table, fmt.Sprintf("%v-%v", router[0].(Conn).ID(), router[1]))
-		delete(r.table, fmt.Sprintf("%v-%v", router[2].(Conn).ID(), router[3]))
+		activeChannel(router.Src, -1)
+		activeChannel(router.Dst, -1)
+		if uriPriority(router.URI) != prioNormal {
+			priorityChannel(router.Src, router.SrcSid, prioNormal)
+			priorityChannel(router.Dst, router.DstSid, prioNormal)
+		}
 	}
 	return router
 }
@@ -531,28 +1258,19 @@ func (r *Router) loopReadRaw(channel Conn) {
 		if ShowLog > 1 {
 			DebugLog("Router(%v) read one command(%v,%v) from %v", r.Name, cmdString(buf[4]), len(buf), channel)
 		}
-		switch buf[4] {
-		case CmdLogin:
-			err = r.procLogin(channel, buf)
-		case CmdDial:
-			err = r.procDial(channel, buf)
-		case CmdDialBack:
-			err = r.procDialBack(channel, buf)
-		case CmdData:
-			err = r.procChannelData(channel, buf)
-		case CmdClosed:
-			err = r.procClosed(channel, buf)
-		case CmdHeartbeat:
-			err = r.procHeartbeat(channel, buf)
-		default:
-			err = fmt.Errorf("not supported cmd(%v)", buf[4])
-		}
+		err = r.procFrame(channel, buf)
 		if err != nil {
 			break
 		}
 	}
+	if raw, ok := channel.(*RawConn); ok && err == io.EOF && r.closeWrite(raw) {
+		InfoLog("Router(%v) the reader(%v) is half-closed, waiting write side closed", r.Name, channel)
+		raw.WaitWrite()
+	}
 	channel.Close()
+	releaseBuffer(channel)
 	InfoLog("Router(%v) the reader(%v) is stopped by %v", r.Name, channel, err)
+	var removed *bondChannel
 	if channel.Type() == ConnTypeChannel {
 		r.channelLck.Lock()
 		bond := r.channel[channel.Name()]
@@ -561,57 +1279,93 @@ func (r *Router) loopReadRaw(channel Conn) {
 			if bond.channels[channel.Index()] == channel {
 				delete(bond.channels, channel.Index())
 			}
-			bond.channelLck.Unlock()
+			bond.updateStripe()
 			if len(bond.channels) < 1 {
 				delete(r.channel, channel.Name())
+				removed = bond
 			}
+			bond.channelLck.Unlock()
 			InfoLog("Router(%v) remove channel(%v) success", r.Name, channel)
 		}
 		r.channelLck.Unlock()
+		if bond != nil && removed == nil {
+			bond.conn.resend(channel)
+		}
 	}
-	//
+	r.closeSessions(channel, err)
+	if removed != nil {
+		r.closeSessions(removed.conn, err)
+		removed.conn.reset()
+	}
+	r.Handler.OnConnClose(channel)
+}
+
+func (r *Router) procFrame(channel Conn, buf []byte) (err error) {
+	switch buf[4] {
+	case CmdLogin:
+		err = r.procLogin(channel, buf)
+	case CmdDial:
+		err = r.procDial(channel, buf)
+	case CmdDialBack:
+		err = r.procDialBack(channel, buf)
+	case CmdData:
+		err = r.procChannelData(channel, buf)
+	case CmdStripe:
+		err = r.procStripe(channel, buf)
+	case CmdClosed:
+		err = r.procClosed(channel, buf)
+	case CmdCloseWrite:
+		err = r.procCloseWrite(channel, buf)
+	case CmdHeartbeat:
+		err = r.procHeartbeat(channel, buf)
+	default:
+		//ignore the command which is added by newer version
+		WarnLog("Router(%v) ignore not supported cmd(%v) from %v", r.Name, buf[4], channel)
+	}
+	return
+}
+
+//closeSessions will close all session which is running on channel
+func (r *Router) closeSessions(channel Conn, err error) {
 	running := []io.Closer{}
-	r.tableLck.Lock()
 	if channel.Type() == ConnTypeRaw {
-		// router := r.table[fmt.Sprintf("%v-%v", channel.ID(), channel.ID())]
-		router := r.removeTableNoLock(channel, channel.ID())
+		router := r.removeTable(channel, channel.ID())
 		if router != nil {
 			target, sid := router.Next(channel)
 			writeCmd(target, nil, CmdClosed, sid, []byte(err.Error()))
 		}
 	} else {
-		for _, router := range r.table {
+		r.cancelDial(channel.ID())
+		r.table.Range(func(router *TableRouter) bool {
 			target, sid := router.Next(channel)
-			if target == nil {
-				continue
+			if target == nil || r.removeTable(target, sid) != router {
+				return true
 			}
 			if target.Type() == ConnTypeRaw {
 				running = append(running, target)
 			} else {
 				writeCmd(target, nil, CmdClosed, sid, []byte(err.Error()))
 			}
-			r.removeTableNoLock(target, sid)
-		}
+			return true
+		})
 	}
-	r.tableLck.Unlock()
 	for _, closer := range running {
 		closer.Close()
 	}
-	r.Handler.OnConnClose(channel)
 }
 
 func (r *Router) loopHeartbeat() {
-	data := []byte("ping...")
-	length := uint32(len(data) + 13)
+	length := uint32(13 + 12)
 	buf := make([]byte, length)
 	binary.BigEndian.PutUint32(buf, length-4)
 	buf[4] = CmdHeartbeat
 	binary.BigEndian.PutUint32(buf[5:], 0)
-	copy(buf[13:], data)
+	copy(buf[13:], []byte("ping"))
 	last := time.Now().Local().UnixNano() / 1e6
 	showed := false
 	for {
 		now := time.Now().Local().UnixNano() / 1e6
+		binary.BigEndian.PutUint64(buf[17:], uint64(time.Now().UnixNano()))
 		all := []Conn{}
 		r.channelLck.RLock()
 		for name, bond := range r.channel {
@@ -659,28 +1413,74 @@ func (r *Router) procLogin(conn Conn, buf []byte) (err error) {
 	if result == nil {
 		result = xmap.M{}
 	}
+	option := xmap.M{}
+	json.Unmarshal(buf[13:], &option)
 	channel.name = name
 	channel.index = index
+	channel.weight = option.Int64Def(1, "weight")
+	channel.negotiate(r.Capabilities, option)
+	channel.stripe = option.IntDef(0, "stripe") > 0 && channel.Capable(CapStripe)
 	result["name"] = r.Name
 	result["code"] = 0
+	result["version"] = ProtocolVersion
+	result["capabilities"] = r.Capabilities
 	r.addChannel(channel)
 	message := converter.JSON(result)
 	writeCmd(channel, nil, CmdLoginBack, 0, []byte(message))
+	if r.Draining() {
+		writeCmd(channel, nil, CmdHeartbeat, 0, []byte("drain"))
+	}
 	InfoLog("Router(%v) the channel(%v,%v) is login success on %v", r.Name, name, index, channel)
 	return
 }
 
-func (r *Router) procRawDial(channel Conn, sid uint64, conn, uri string) (err error) {
+func (r *Router) procRawDial(channel Conn, sid uint64, conn, uri string, source *dialSource) (err error) {
+	var cipher *e2eCipher
+	if uriE2E(uri) {
+		cipher, err = r.e2eCipher("")
+		if err != nil {
+			DebugLog("Router(%v) dial(%v) to %v fail on channel(%v) by %v", r.Name, sid, conn, channel, err)
+			err = writeDialBack(channel, sid, err)
+			return
+		}
+	}
 	dstSid := atomic.AddUint64(&r.connectSequence, 1)
-	raw, rawError := r.Handler.DialRaw(dstSid, uri)
+	r.dialingLck.Lock()
+	if r.dialing[channel.ID()] == nil {
+		r.dialing[channel.ID()] = map[uint64]uint64{}
+	}
+	r.dialing[channel.ID()][sid] = dstSid
+	r.dialingLck.Unlock()
+	raw, rawError := r.Handler.DialRaw(dstSid, uriStrip(uri, "e2e", "prio"))
+	r.dialingLck.Lock()
+	if dialing := r.dialing[channel.ID()]; dialing[sid] == dstSid {
+		delete(dialing, sid)
+		if len(dialing) < 1 {
+			delete(r.dialing, channel.ID())
+		}
+	}
+	r.dialingLck.Unlock()
 	if rawError != nil {
-		DebugLog("Router(%v) dial(%v) to %v fail on channel(%v) by %v", r.Name, sid, conn, channel, rawError)
-		message := []byte(fmt.Sprintf("dial to uri(%v) fail with %v", uri, rawError))
-		err = writeCmd(channel, nil, CmdDialBack, sid, message)
+		code := dialErrorCode(rawError)
+		if code == DialErrDenied {
+			WarnLog("Router(%v) dial(%v->%v) to %v from router(%v) client(%v) is denied on channel(%v) by %v", r.Name, sid, dstSid, conn, source.Router, source.Client, channel, rawError)
+		} else {
+			DebugLog("Router(%v) dial(%v) to %v fail on channel(%v) by %v", r.Name, sid, conn, channel, rawError)
+		}
+		err = writeDialBack(channel, sid, newDialError(code, "dial to uri(%v) fail with %v", uri, rawError))
 		return
 	}
+	if cipher != nil {
+		rawConn, ok := raw.(*RawConn)
+		if !ok {
+			raw.Close()
+			err = writeDialBack(channel, sid, newDialError(DialErrDenied, "e2e is not supported by %v", raw))
+			return
+		}
+		rawConn.cipher = cipher
+	}
 	DebugLog("Router(%v) dial(%v-%v->%v-%v) to %v success on channel(%v)", r.Name, channel.ID(), sid, raw.ID(), dstSid, conn, channel)
-	r.addTable(channel, sid, raw, dstSid, conn)
+	r.addTableSource(channel, sid, raw, dstSid, conn, source.Router)
 	err = writeCmd(channel, nil, CmdDialBack, sid, []byte("OK"))
 	if err != nil {
 		raw.Close()
@@ -693,38 +1493,41 @@ func (r *Router) procRawDial(channel Conn, sid uint64, conn, uri string) (err er
 
 func (r *Router) procDial(channel Conn, buf []byte) (err error) {
 	sid := binary.BigEndian.Uint64(buf[5:])
-	conn := string(buf[13:])
+	conn, source := readDialSource(channel, string(buf[13:]), r.Forwarders)
 	DebugLog("Router(%v) proc dial(%v) to %v on channel(%v)", r.Name, sid, conn, channel)
+	if r.Draining() {
+		WarnLog("Router(%v) proc dial to %v on channel(%v) fail with router is draining", r.Name, conn, channel)
+		err = writeDialBack(channel, sid, newDialError(DialErrDraining, "router is draining"))
+		return
+	}
 	path := strings.SplitN(conn, "@", 2)
 	if len(path) < 2 {
 		WarnLog("Router(%v) proc dial to %v on channel(%v) fail with invalid uri", r.Name, conn, channel)
-		err = writeCmd(channel, nil, CmdDialBack, sid, []byte(fmt.Sprintf("invalid uri(%v)", conn)))
+		err = writeDialBack(channel, sid, newDialError(DialErrInvalid, "invalid uri(%v)", conn))
 		return
 	}
 	parts := strings.SplitN(path[1], "->", 2)
 	err = r.Handler.OnConnDialURI(channel, conn, parts)
 	if err != nil {
 		WarnLog("Router(%v) process dial uri event to %v on channel(%v) fail with %v", r.Name, conn, channel, err)
-		err = writeCmd(channel, nil, CmdDialBack, sid, []byte(fmt.Sprintf("%v", err)))
+		err = writeDialBack(channel, sid, newDialError(DialErrDenied, "%v", err))
 		return
 	}
 	if len(parts) < 2 {
-		go r.procRawDial(channel, sid, conn, parts[0])
+		go r.procRawDial(channel, sid, conn, parts[0], source)
 		return
 	}
 	next := parts[0]
 	if channel.Name() == next {
-		err = fmt.Errorf("self dial error")
+		err = newDialError(DialErrInvalid, "self dial error")
 		DebugLog("Router(%v) proc dial to %v on channel(%v) fail with select channel error %v", r.Name, conn, channel, err)
-		message := err.Error()
-		err = writeCmd(channel, nil, CmdDialBack, sid, []byte(message))
+		err = writeDialBack(channel, sid, err)
 		return
 	}
-	dst, err := r.SelectChannel(next)
+	dst, err := r.selectChannel(next, source.Key())
 	if err != nil {
 		DebugLog("Router(%v) proc dial to %v on channel(%v) fail with select channel error %v", r.Name, conn, channel, err)
-		message := err.Error()
-		err = writeCmd(channel, nil, CmdDialBack, sid, []byte(message))
+		err = writeDialBack(channel, sid, err)
 		return
 	}
 	dstSid := atomic.AddUint64(&r.connectSequence, 1)
@@ -732,11 +1535,10 @@ func (r *Router) procDial(channel Conn, buf []byte) (err error) {
 		DebugLog("Router(%v) forwarding dial(%v-%v->%v-%v) %v to channel(%v)", r.Name, channel.ID(), sid, dst.ID(), dstSid, conn, dst)
 	}
 	r.addTable(channel, sid, dst, dstSid, conn)
-	writeError := writeCmd(dst, nil, CmdDial, dstSid, []byte(path[0]+"->"+next+"@"+parts[1]))
+	writeError := writeDial(dst, dstSid, path[0]+"->"+next+"@"+parts[1], source)
 	if writeError != nil {
 		WarnLog("Router(%v) send dial to channel(%v) fail with %v", r.Name, dst, writeError)
-		message := writeError.Error()
-		err = writeCmd(channel, nil, CmdDialBack, sid, []byte(message))
+		err = writeDialBack(channel, sid, writeError)
 		r.removeTable(channel, sid)
 	}
 	return
@@ -745,9 +1547,7 @@ func (r *Router) procDial(channel Conn, buf []byte) (err error) {
 func (r *Router) procDialBack(channel Conn, buf []byte) (err error) {
 	sid := binary.BigEndian.Uint64(buf[5:])
 	DebugLog("Router(%v) proc dial back by %v on channel(%v)", r.Name, sid, channel)
-	r.tableLck.RLock()
-	router := r.table[fmt.Sprintf("%v-%v", channel.ID(), sid)]
-	r.tableLck.RUnlock()
+	router := r.table.Find(channel.ID(), sid)
 	if router == nil {
 		err = writeCmd(channel, nil, CmdClosed, sid, []byte("closed"))
 		return
@@ -757,7 +1557,7 @@ func (r *Router) procDialBack(channel Conn, buf []byte) (err error) {
 		msg := string(buf[13:])
 		if msg == "OK" {
 			InfoLog("Router(%v) dial to %v success", r.Name, target)
-			r.addTable(channel, sid, target, target.ID(), router[4].(string))
+			r.addTable(channel, sid, target, target.ID(), router.URI)
 			if waiter, ok := target.(ReadyWaiter); ok {
 				waiter.Ready(nil, func(err error) {
 					if err == nil {
@@ -776,13 +1576,19 @@ func (r *Router) procDialBack(channel Conn, buf []byte) (err error) {
 			InfoLog("Router(%v) dial to %v fail with %v", r.Name, target, msg)
 			r.removeTable(channel, sid)
 			if waiter, ok := target.(ReadyWaiter); ok {
-				waiter.Ready(fmt.Errorf("%v", msg), nil)
+				waiter.Ready(parseDialBack(buf[13:]), nil)
 			}
 			target.Close()
 		}
 	} else {
-		binary.BigEndian.PutUint64(buf[5:], targetID)
-		_, writeError := target.WriteFrame(buf)
+		var writeError error
+		if msg := string(buf[13:]); msg != "OK" && !capable(target, CapDialCode) {
+			//remote is not supported dial code, send message only
+			writeError = writeCmd(target, nil, CmdDialBack, targetID, []byte(parseDialBack(buf[13:]).Message))
+		} else {
+			binary.BigEndian.PutUint64(buf[5:], targetID)
+			_, writeError = target.WriteFrame(buf)
+		}
 		if writeError != nil {
 			err = writeCmd(channel, nil, CmdClosed, sid, []byte("closed"))
 		}
@@ -792,9 +1598,7 @@ func (r *Router) procDialBack(channel Conn, buf []byte) (err error) {
 
 func (r *Router) procChannelData(channel Conn, buf []byte) (err error) {
 	sid := binary.BigEndian.Uint64(buf[5:])
-	r.tableLck.RLock()
-	router := r.table[fmt.Sprintf("%v-%v", channel.ID(), sid)]
-	r.tableLck.RUnlock()
+	router := r.table.Find(channel.ID(), sid)
 	if router == nil {
 		if channel.Type() == ConnTypeRaw {
 			err = fmt.Errorf("not router")
@@ -803,13 +1607,19 @@ func (r *Router) procChannelData(channel Conn, buf []byte) (err error) {
 	}
 	target, targetID := router.Next(channel)
 	if ShowLog > 1 {
-		DebugLog("Router(%v) forwaring %v bytes by %v-%v->%v-%v, source:%v, next:%v, uri:%v", r.Name, len(buf)-13, channel.ID(), sid, target.ID(), targetID, channel, target, router[4])
+		DebugLog("Router(%v) forwaring %v bytes by %v-%v->%v-%v, source:%v, next:%v, uri:%v", r.Name, len(buf)-13, channel.ID(), sid, target.ID(), targetID, channel, target, router.URI)
 	}
 	binary.BigEndian.PutUint64(buf[5:], targetID)
 	_, writeError := target.WriteFrame(buf)
 	if writeError != nil {
 		if channel.Type() == ConnTypeRaw {
 			err = writeError
+		} else if target.Type() == ConnTypeRaw {
+			//the raw may be waiting write side closed after half-close, or e2e handshake is failed
+			if r.removeTable(channel, sid) != nil {
+				writeCmd(channel, nil, CmdClosed, sid, []byte(writeError.Error()))
+			}
+			target.Close()
 		}
 	}
 	return
@@ -828,13 +1638,216 @@ func (r *Router) procClosed(channel Conn, buf []byte) (err error) {
 			binary.BigEndian.PutUint64(buf[5:], targetID)
 			target.WriteFrame(buf)
 		}
+	} else {
+		r.cancelDial(channel.ID(), sid)
+	}
+	return
+}
+
+//cancelDial will cancel the running raw dial on channel by sid, all running raw dial on channel is canceled when sids is empty
+func (r *Router) cancelDial(cid uint64, sids ...uint64) {
+	canceler, ok := r.Handler.(DialCanceler)
+	if !ok {
+		return
+	}
+	var canceled []uint64
+	r.dialingLck.RLock()
+	dialing := r.dialing[cid]
+	if len(sids) < 1 {
+		for _, dstSid := range dialing {
+			canceled = append(canceled, dstSid)
+		}
+	}
+	for _, sid := range sids {
+		if dstSid, ok := dialing[sid]; ok {
+			canceled = append(canceled, dstSid)
+		}
+	}
+	r.dialingLck.RUnlock()
+	for _, dstSid := range canceled {
+		DebugLog("Router(%v) cancel dial(%v) on channel(%v)", r.Name, dstSid, cid)
+		canceler.CancelDial(dstSid)
+	}
+}
+
+//dialSource is the originating router and client of dial, it is carried by dial when channel is capable of CapDialSource
+type dialSource struct {
+	Router string //the originating router name
+	Client string //the client host on originating router
+}
+
+//Key will return the sticky key of originating client
+func (d *dialSource) Key() string {
+	return d.Router + "/" + d.Client
+}
+
+//clientHost will return the host of remote address on raw, it is empty when raw is not net connection
+func clientHost(raw interface{}) (host string) {
+	conn, ok := raw.(net.Conn)
+	if !ok || conn.RemoteAddr() == nil {
+		return
+	}
+	host = conn.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return
+}
+
+//writeDial will send dial to channel, the source is appended to message as query by new line when channel is capable
+func writeDial(channel Conn, sid uint64, conn string, source *dialSource) (err error) {
+	message := conn
+	if capable(channel, CapDialSource) {
+		message += "\n" + url.Values{"router": {source.Router}, "client": {source.Client}}.Encode()
+	}
+	err = writeCmd(channel, nil, CmdDial, sid, []byte(message))
+	return
+}
+
+//readDialSource will split the source from dial message, the source router is trusted only when dial is forwarded by
+//previous router on path and the channel is in forwarders, else it is the name of channel which the dial comes from
+func readDialSource(channel Conn, message string, forwarders []string) (conn string, source *dialSource) {
+	conn = message
+	source = &dialSource{Router: channel.Name()}
+	if !capable(channel, CapDialSource) {
+		return
+	}
+	index := strings.LastIndex(message, "\n")
+	if index < 0 {
+		return
+	}
+	conn = message[:index]
+	args, err := url.ParseQuery(message[index+1:])
+	if err != nil {
+		return
+	}
+	source.Client = args.Get("client")
+	path := strings.Split(strings.SplitN(conn, "@", 2)[0], "->")
+	if router := args.Get("router"); len(path) > 1 && path[len(path)-2] == channel.Name() && len(router) > 0 && forwarder(forwarders, channel.Name()) {
+		source.Router = router
+	}
+	return
+}
+
+//forwarder will return if the channel name is in trusted forwarders
+func forwarder(forwarders []string, name string) bool {
+	for _, f := range forwarders {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+//capable will return if the capability is supported by channel or all channel in bond
+func capable(conn interface{}, name string) bool {
+	switch c := conn.(type) {
+	case *Channel:
+		return c.Capable(name)
+	case *bondConn:
+		c.bond.channelLck.RLock()
+		defer c.bond.channelLck.RUnlock()
+		for _, channel := range c.bond.channels {
+			if !capable(channel, name) {
+				return false
+			}
+		}
+		return len(c.bond.channels) > 0
+	default:
+		return false
+	}
+}
+
+//closeWrite will send half-close to next of raw connection, return false if next is not supported
+func (r *Router) closeWrite(raw *RawConn) bool {
+	router := r.table.Find(raw.ID(), raw.ID())
+	if router == nil {
+		return false
+	}
+	target, sid := router.Next(raw)
+	if target == nil || !capable(target, CapHalfClose) {
+		return false
+	}
+	return writeCmd(target, nil, CmdCloseWrite, sid, []byte("EOF")) == nil
+}
+
+func (r *Router) procCloseWrite(channel Conn, buf []byte) (err error) {
+	sid := binary.BigEndian.Uint64(buf[5:])
+	DebugLog("Router(%v) the session(%v) is half-closed on channel(%v)", r.Name, sid, channel)
+	router := r.table.Find(channel.ID(), sid)
+	if router == nil {
+		return
+	}
+	target, targetID := router.Next(channel)
+	if target.Type() == ConnTypeRaw {
+		if raw, ok := target.(*RawConn); !ok || raw.CloseWrite() != nil {
+			target.Close()
+		}
+		return
+	}
+	if capable(target, CapHalfClose) {
+		binary.BigEndian.PutUint64(buf[5:], targetID)
+		if _, writeError := target.WriteFrame(buf); writeError == nil {
+			return
+		}
+	}
+	//next is not supported half-close, close session on both side
+	if r.removeTable(channel, sid) != nil {
+		writeCmd(target, nil, CmdClosed, targetID, []byte("closed"))
+		writeCmd(channel, nil, CmdClosed, sid, []byte("closed"))
+	}
+	return
+}
+
+func (r *Router) procStripe(channel Conn, buf []byte) (err error) {
+	r.channelLck.RLock()
+	bond := r.channel[channel.Name()]
+	r.channelLck.RUnlock()
+	if bond == nil {
+		err = fmt.Errorf("bond not exist by name(%v)", channel.Name())
+		return
+	}
+	stripeError := bond.conn.Receive(channel, buf, func(frame []byte) error {
+		return r.procFrame(bond.conn, frame)
+	})
+	if stripeError != nil {
+		WarnLog("Router(%v) proc stripe frame on channel(%v) fail with %v", r.Name, channel, stripeError)
+		sid := binary.BigEndian.Uint64(buf[5:])
+		router := r.removeTable(bond.conn, sid)
+		if router != nil {
+			target, targetID := router.Next(bond.conn)
+			if target.Type() == ConnTypeRaw {
+				target.Close()
+			} else {
+				writeCmd(target, nil, CmdClosed, targetID, []byte(stripeError.Error()))
+			}
+			writeCmd(bond.conn, nil, CmdClosed, sid, []byte(stripeError.Error()))
+		}
 	}
 	return
 }
 
 func (r *Router) procHeartbeat(conn Conn, buf []byte) (err error) {
-	if channel, ok := conn.(*Channel); ok {
-		channel.Heartbeat = time.Now().Local().UnixNano() / 1e6
+	channel, ok := conn.(*Channel)
+	if !ok {
+		return
+	}
+	atomic.StoreInt64(&channel.Heartbeat, time.Now().Local().UnixNano()/1e6)
+	if string(buf[13:]) == "drain" {
+		InfoLog("Router(%v) the channel(%v) is draining", r.Name, channel)
+		atomic.StoreInt32(&channel.draining, 1)
+		return
+	}
+	if len(buf) != 13+12 {
+		return
+	}
+	switch string(buf[13:17]) {
+	case "ping":
+		copy(buf[13:], []byte("pong"))
+		_, err = channel.WriteFrame(buf)
+	case "pong":
+		sended := int64(binary.BigEndian.Uint64(buf[17:]))
+		atomic.StoreInt64(&channel.RTT, time.Now().UnixNano()-sended)
 	}
 	return
 }
@@ -866,6 +1879,7 @@ func (r *Router) DialConn(uri string, raw io.ReadWriteCloser) (sid uint64, conn
 		sid = r.UniqueSid()
 		conn, err = r.Handler.DialRaw(sid, uri)
 		if err != nil {
+			err = newDialError(dialErrorCode(err), "%v", err)
 			if waiter, ok := raw.(ReadyWaiter); ok {
 				waiter.Ready(err, nil)
 			}
@@ -888,11 +1902,20 @@ func (r *Router) DialConn(uri string, raw io.ReadWriteCloser) (sid uint64, conn
 				conn.Close()
 				return
 			}
+			done := make(chan int, 1)
 			go func() {
-				_, err = io.CopyBuffer(raw, conn, make([]byte, r.BufferSize))
-				raw.Close()
+				buffer := Buffers.Get(r.BufferSize)
+				_, copyErr := io.CopyBuffer(raw, conn, buffer)
+				Buffers.Put(buffer)
+				closeWriteOrClose(raw, copyErr)
+				done <- 1
 			}()
-			_, err = io.CopyBuffer(conn, raw, make([]byte, r.BufferSize))
+			buffer := Buffers.Get(r.BufferSize)
+			_, err = io.CopyBuffer(conn, raw, buffer)
+			Buffers.Put(buffer)
+			closeWriteOrClose(conn, err)
+			<-done
+			raw.Close()
 			conn.Close()
 		}
 		if waiter, ok := conn.(ReadyWaiter); ok {
@@ -905,24 +1928,176 @@ func (r *Router) DialConn(uri string, raw io.ReadWriteCloser) (sid uint64, conn
 		}
 		return
 	}
-	channel, err := r.SelectChannel(parts[0])
+	var cipher *e2eCipher
+	if uriE2E(uri) {
+		routers := strings.Split(uri, "->")
+		cipher, err = r.e2eCipher(routers[len(routers)-2])
+		if err != nil {
+			return
+		}
+	}
+	source := &dialSource{Router: r.Name, Client: clientHost(raw)}
+	channel, err := r.selectChannel(parts[0], source.Key())
 	if err != nil {
 		return
 	}
 	sid = atomic.AddUint64(&r.connectSequence, 1)
-	conn = NewRawConn(fmt.Sprintf("%v", sid), raw, r.BufferSize, sid, uri)
+	rawConn := NewRawConn(fmt.Sprintf("%v", sid), raw, r.BufferSize, sid, uri)
+	rawConn.cipher = cipher
+	conn = rawConn
 	DebugLog("Router(%v) start dial(%v-%v->%v-%v) to %v on channel(%v)", r.Name, conn.ID(), sid, channel.ID(), sid, uri, channel)
 	r.addTable(channel, sid, conn, sid, uri)
-	err = writeCmd(channel, nil, CmdDial, sid, []byte(fmt.Sprintf("%v@%v", parts[0], parts[1])))
+	err = writeDial(channel, sid, fmt.Sprintf("%v@%v", parts[0], parts[1]), source)
 	if err != nil {
 		r.removeTable(channel, sid)
 	}
 	return
 }
 
+//LoginError is the error of login is rejected by remote
+type LoginError struct {
+	Code    int
+	Message string
+}
+
+func (l *LoginError) Error() string {
+	return l.Message
+}
+
+const (
+	//DialErrFail is the dial error code of general failure
+	DialErrFail = 1
+	//DialErrInvalid is the dial error code of invalid uri
+	DialErrInvalid = 2
+	//DialErrDenied is the dial error code of access denied
+	DialErrDenied = 3
+	//DialErrNoChannel is the dial error code of channel not exist
+	DialErrNoChannel = 4
+	//DialErrDraining is the dial error code of router is draining
+	DialErrDraining = 5
+	//DialErrRefused is the dial error code of connection refused by remote
+	DialErrRefused = 6
+	//DialErrUnreachable is the dial error code of host unreachable or not found
+	DialErrUnreachable = 7
+	//DialErrTimeout is the dial error code of dial or dial back timeout
+	DialErrTimeout = 8
+)
+
+//DialError is the error of dial fail with code, it is sent by CmdDialBack as json {"code":1,"message":"xx"}
+type DialError struct {
+	ErrCode int
+	Message string
+}
+
+func newDialError(code int, format string, args ...interface{}) *DialError {
+	return &DialError{ErrCode: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (d *DialError) Error() string {
+	return d.Message
+}
+
+//Code will return the SOCKS5 reply code, it is socks.Codable impl
+func (d *DialError) Code() byte {
+	switch d.ErrCode {
+	case DialErrDenied:
+		return 0x02
+	case DialErrNoChannel, DialErrDraining:
+		return 0x03
+	case DialErrUnreachable, DialErrTimeout:
+		return 0x04
+	case DialErrRefused:
+		return 0x05
+	default:
+		return 0x01
+	}
+}
+
+//HTTPStatus will return the http status code for http forward
+func (d *DialError) HTTPStatus() int {
+	switch d.ErrCode {
+	case DialErrDenied:
+		return http.StatusForbidden
+	case DialErrNoChannel, DialErrDraining:
+		return http.StatusServiceUnavailable
+	case DialErrTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+type byteCodable interface {
+	Code() byte
+}
+
+//dialErrorCode will return the dial error code by error
+func dialErrorCode(err error) int {
+	var dialErr *DialError
+	if errors.As(err, &dialErr) {
+		return dialErr.ErrCode
+	}
+	if coded, ok := err.(byteCodable); ok {
+		switch coded.Code() {
+		case 0x02:
+			return DialErrDenied
+		case 0x03, 0x04:
+			return DialErrUnreachable
+		case 0x05:
+			return DialErrRefused
+		case 0x06:
+			return DialErrTimeout
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return DialErrTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return DialErrRefused
+	}
+	var dnsErr *net.DNSError
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) || errors.As(err, &dnsErr) {
+		return DialErrUnreachable
+	}
+	return DialErrFail
+}
+
+//writeDialBack will send dial fail back by code and message, only message is sent when dial code is not supported by remote
+func writeDialBack(w frame.Writer, sid uint64, err error) error {
+	message := err.Error()
+	if capable(w, CapDialCode) {
+		message = converter.JSON(xmap.M{"code": dialErrorCode(err), "message": err.Error()})
+	}
+	return writeCmd(w, nil, CmdDialBack, sid, []byte(message))
+}
+
+//parseDialBack will parse the dial back message to DialError, the message is not json when it is sent by older version
+func parseDialBack(message []byte) (err *DialError) {
+	result := xmap.M{}
+	if json.Unmarshal(message, &result) == nil && result.IntDef(0, "code") > 0 {
+		err = &DialError{ErrCode: result.IntDef(0, "code"), Message: result.Str("message")}
+	} else {
+		err = &DialError{ErrCode: DialErrFail, Message: string(message)}
+	}
+	return
+}
+
 //JoinConn will add channel by the connected connection
 func (r *Router) JoinConn(conn frame.ReadWriteCloser, index int, args interface{}) (channel *Channel, result xmap.M, err error) {
+	channel, result, err = r.joinConn(conn, index, args, xmap.M{})
+	return
+}
+
+//joinConn will add channel by the connected connection with context, the context must be ready before channel is registered
+func (r *Router) joinConn(conn frame.ReadWriteCloser, index int, args interface{}, context xmap.M) (channel *Channel, result xmap.M, err error) {
 	data, _ := json.Marshal(args)
+	option := xmap.M{}
+	if json.Unmarshal(data, &option) == nil && option != nil {
+		option["version"] = ProtocolVersion
+		option["capabilities"] = r.Capabilities
+		data, _ = json.Marshal(option)
+	}
 	DebugLog("Router(%v) login join connection %v by options %v", r.Name, conn, string(data))
 	err = writeCmd(conn, nil, CmdLogin, 0, data)
 	if err != nil {
@@ -936,7 +2111,12 @@ func (r *Router) JoinConn(conn frame.ReadWriteCloser, index int, args interface{
 	}
 	result = xmap.M{}
 	err = json.Unmarshal(buf[13:], &result)
-	if err != nil || result.Int("code") != 0 || len(result.Str("name")) < 1 {
+	if err == nil && result.Int("code") != 0 {
+		err = &LoginError{Code: result.Int("code"), Message: string(buf[13:])}
+		WarnLog("Router(%v) login to %v is rejected with %v", r.Name, conn, err)
+		return
+	}
+	if err != nil || len(result.Str("name")) < 1 {
 		err = fmt.Errorf("%v", string(buf[13:]))
 		WarnLog("Router(%v) login to %v fail with %v", r.Name, conn, err)
 		return
@@ -947,13 +2127,65 @@ func (r *Router) JoinConn(conn frame.ReadWriteCloser, index int, args interface{
 		cid:             atomic.AddUint64(&r.connectSequence, 1),
 		name:            remoteName,
 		index:           index,
-		context:         xmap.M{},
+		context:         context,
+		weight:          option.Int64Def(1, "weight"),
+		writer:          newChannelWriter(conn),
 	}
+	channel.negotiate(r.Capabilities, result)
+	channel.stripe = option.IntDef(0, "stripe") > 0 && channel.Capable(CapStripe)
 	r.Register(channel)
 	InfoLog("Router(%v) login to %v success, bind to %v,%v", r.Name, conn, remoteName, index)
 	return
 }
 
+//Draining will return if router is draining
+func (r *Router) Draining() bool {
+	return atomic.LoadInt32(&r.draining) > 0
+}
+
+//Sessions will return the count of session on router
+func (r *Router) Sessions() (count int) {
+	r.table.Range(func(router *TableRouter) bool {
+		count++
+		return true
+	})
+	r.rawLck.RLock()
+	count += len(r.rawConn) / 2
+	r.rawLck.RUnlock()
+	return
+}
+
+//Drain will stop accepting new dial through router and notify all channels to prefer alternate channel,
+//then wait all sessions done or timeout, return the remaining session count
+func (r *Router) Drain(timeout time.Duration) (remain int) {
+	if atomic.CompareAndSwapInt32(&r.draining, 0, 1) {
+		InfoLog("Router(%v) router is draining", r.Name)
+		all := []Conn{}
+		r.channelLck.RLock()
+		for _, bond := range r.channel {
+			bond.channelLck.RLock()
+			for _, channel := range bond.channels {
+				all = append(all, channel)
+			}
+			bond.channelLck.RUnlock()
+		}
+		r.channelLck.RUnlock()
+		for _, channel := range all {
+			writeCmd(channel, nil, CmdHeartbeat, 0, []byte("drain"))
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		remain = r.Sessions()
+		if remain < 1 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	InfoLog("Router(%v) router drain is done with %v session remain", r.Name, remain)
+	return
+}
+
 //Close all channel
 func (r *Router) Close() (err error) {
 	all := []io.Closer{}
@@ -966,16 +2198,10 @@ func (r *Router) Close() (err error) {
 		bond.channelLck.Unlock()
 	}
 	r.channelLck.Unlock()
-	r.tableLck.Lock()
-	for _, table := range r.table {
-		if closer, ok := table[0].(io.Closer); ok {
-			all = append(all, closer)
-		}
-		if closer, ok := table[2].(io.Closer); ok {
-			all = append(all, closer)
-		}
-	}
-	r.tableLck.Unlock()
+	r.table.Range(func(router *TableRouter) bool {
+		all = append(all, router.Src, router.Dst)
+		return true
+	})
 	r.rawLck.Lock()
 	for _, entry := range r.rawConn {
 		if entry[0] != nil {
@@ -1012,44 +2238,52 @@ func (r *Router) State(args ...interface{}) (state xmap.M) {
 		if queryType != "info" && queryType != "*" {
 			continue
 		}
-		channel := xmap.M{}
+		channel := xmap.M{
+			"strategy": r.strategy(name),
+		}
+		bond.channelLck.RLock()
 		for idx, con := range bond.channels {
 			info := xmap.M{
 				"connect": fmt.Sprintf("%v", con),
 				"used":    bond.used[idx],
 			}
 			if c, ok := con.(*Channel); ok {
-				info["heartbeat"] = c.Heartbeat
+				info["heartbeat"] = atomic.LoadInt64(&c.Heartbeat)
+				info["active"] = atomic.LoadInt64(&c.active)
+				info["rtt"] = time.Duration(atomic.LoadInt64(&c.RTT)).Milliseconds()
+				info["weight"] = c.weight
+				info["draining"] = atomic.LoadInt32(&c.draining) > 0
+				info["version"] = c.version
+				capabilities := []string{}
+				for name := range c.capabilities {
+					capabilities = append(capabilities, name)
+				}
+				sort.Strings(capabilities)
+				info["capabilities"] = capabilities
 			}
 			channel[fmt.Sprintf("_%v", idx)] = info
 		}
+		bond.channelLck.RUnlock()
 		channels[name] = channel
 	}
 	r.channelLck.RUnlock()
 	state["channels"] = channels
+	state["draining"] = r.Draining()
 	//
 	table := []string{}
-	r.tableLck.RLock()
-	added := map[string]bool{}
-	for _, t := range r.table {
-		queryType := query.Str(t[0].(Conn).Name())
+	r.table.Range(func(t *TableRouter) bool {
+		queryType := query.Str(t.Src.Name())
 		if len(queryType) < 1 {
-			queryType = query.Str(t[2].(Conn).Name())
+			queryType = query.Str(t.Dst.Name())
 		}
 		if len(queryType) < 1 {
 			queryType = query.Str("*")
 		}
-		if queryType != "table" && queryType != "*" {
-			continue
+		if queryType == "table" || queryType == "*" {
+			table = append(table, t.String())
 		}
-		key := fmt.Sprintf("%p", t)
-		if added[key] {
-			continue
-		}
-		added[key] = true
-		table = append(table, t.String())
-	}
-	r.tableLck.RUnlock()
+		return true
+	})
 	state["table"] = table
 	return
 }
@@ -1067,7 +2301,8 @@ func (r *Router) StateH(w http.ResponseWriter, req *http.Request) {
 
 func writeCmd(w frame.Writer, buffer []byte, cmd byte, sid uint64, msg []byte) (err error) {
 	if buffer == nil {
-		buffer = make([]byte, len(msg)+13)
+		buffer = Buffers.Get(len(msg) + 13)
+		defer Buffers.Put(buffer)
 	}
 	buffer[4] = cmd
 	binary.BigEndian.PutUint64(buffer[5:], sid)
@@ -1,8 +1,18 @@
 package bsck
 
 import (
+	"encoding/binary"
+	"fmt"
+	"net"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/converter"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xio/frame"
+	"github.com/codingeasygo/util/xmap"
 )
 
 func TestRawConnError(t *testing.T) {
@@ -11,3 +21,346 @@ func TestRawConnError(t *testing.T) {
 		t.Error("error")
 	}
 }
+
+func TestSelectChannel(t *testing.T) {
+	router := NewRouter("test")
+	_, err := router.SelectChannel("none")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	c0 := &Channel{cid: 1, name: "n", index: 0, context: xmap.M{}, weight: 1, RTT: int64(10 * time.Millisecond)}
+	c1 := &Channel{cid: 2, name: "n", index: 1, context: xmap.M{}, weight: 3, RTT: int64(5 * time.Millisecond)}
+	router.addChannel(c0)
+	router.addChannel(c1)
+	pick := func(strategy, client string) int {
+		router.Strategy["n"] = strategy
+		dst, err := router.selectChannel("n", client)
+		if err != nil {
+			t.Error(err)
+			return -1
+		}
+		return dst.Index()
+	}
+	if pick(SelectUsed, "") != 0 || pick(SelectUsed, "") != 1 || pick(SelectUsed, "") != 0 {
+		t.Error("error")
+		return
+	}
+	if pick(SelectRTT, "") != 1 || pick(SelectRTT, "") != 1 {
+		t.Error("error")
+		return
+	}
+	router.addTable(c0, 100, NewRawConn("", nil, 1024, 100, "x"), 100, "x")
+	if pick(SelectActive, "") != 1 {
+		t.Error("error")
+		return
+	}
+	router.removeTable(c0, 100)
+	if c0.active != 0 {
+		t.Error("error")
+		return
+	}
+	//used is 2:4 now, weight is 1:3
+	if pick(SelectWeight, "") != 1 || pick(SelectWeight, "") != 1 || pick(SelectWeight, "") != 0 {
+		t.Error("error")
+		return
+	}
+	sticky := pick(SelectSticky, "client1")
+	for i := 0; i < 10; i++ {
+		if pick(SelectSticky, "client1") != sticky {
+			t.Error("error")
+			return
+		}
+	}
+	//sticky by client host
+	conna, connb := net.Pipe()
+	defer conna.Close()
+	defer connb.Close()
+	if clientHost(conna) != "pipe" || clientHost(&net.TCPConn{}) != "" || clientHost("xx") != "" {
+		t.Error("error")
+		return
+	}
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+		if clientHost(conn) != "127.0.0.1" {
+			t.Error(clientHost(conn))
+			return
+		}
+	}
+	state := router.State(xmap.M{"*": "*"})
+	if state.Str("channels/n/strategy") != SelectSticky {
+		t.Errorf("%v", converter.JSON(state))
+		return
+	}
+}
+
+func TestHeartbeatRTT(t *testing.T) {
+	router := NewRouter("test")
+	conna, connb, _ := xio.Pipe()
+	channel := &Channel{ReadWriteCloser: frame.NewReadWriteCloser(conna, 1024), cid: 1, name: "n", context: xmap.M{}}
+	remote := frame.NewReadWriteCloser(connb, 1024)
+	ping := make([]byte, 13+12)
+	ping[4] = CmdHeartbeat
+	copy(ping[13:], []byte("ping"))
+	binary.BigEndian.PutUint64(ping[17:], uint64(time.Now().Add(-time.Millisecond).UnixNano()))
+	go router.procHeartbeat(channel, ping)
+	pong, err := remote.ReadFrame()
+	if err != nil || string(pong[13:17]) != "pong" {
+		t.Errorf("err:%v,%v", err, pong)
+		return
+	}
+	router.procHeartbeat(channel, pong)
+	if channel.RTT < int64(time.Millisecond) || channel.Heartbeat < 1 {
+		t.Error("error")
+		return
+	}
+	//old heartbeat
+	router.procHeartbeat(channel, append(make([]byte, 13), []byte("ping...")...))
+}
+
+func TestSessionTable(t *testing.T) {
+	table := newSessionTable()
+	conna := NewRawConn("a", xio.NewEchoConn(), 1024, 1, "a")
+	connb := &Channel{cid: 100, name: "b"}
+	routerA := &TableRouter{Src: conna, SrcSid: 1, Dst: connb, DstSid: 10, URI: "a"}
+	table.Add(routerA)
+	if table.Find(1, 1) != routerA || table.Find(100, 10) != routerA || table.Find(100, 1) != nil {
+		t.Error("error")
+		return
+	}
+	if target, sid := routerA.Next(conna); target != connb || sid != 10 {
+		t.Error("error")
+		return
+	}
+	if target, sid := routerA.Next(connb); target != conna || sid != 1 {
+		t.Error("error")
+		return
+	}
+	if target, _ := routerA.Next(&Channel{}); target != nil {
+		t.Error("error")
+		return
+	}
+	routerB := &TableRouter{Src: connb, SrcSid: 11, Dst: connb, DstSid: 12, URI: "b"}
+	table.Add(routerB)
+	count := 0
+	table.Range(func(router *TableRouter) bool {
+		count++
+		return true
+	})
+	if count != 2 {
+		t.Error(count)
+		return
+	}
+	count = 0
+	table.Range(func(router *TableRouter) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Error(count)
+		return
+	}
+	if table.Remove(100, 10) != routerA || table.Remove(1, 1) != nil || table.Find(1, 1) != nil {
+		t.Error("error")
+		return
+	}
+	//replaced one key
+	routerC := &TableRouter{Src: connb, SrcSid: 11, Dst: conna, DstSid: 2, URI: "c"}
+	table.Add(routerC)
+	if table.Remove(100, 12) != routerB || table.Find(100, 11) != routerC {
+		t.Error("error")
+		return
+	}
+	if !strings.Contains(routerC.String(), " 11 <-> ") {
+		t.Error(routerC.String())
+		return
+	}
+}
+
+func BenchmarkSessionTable(b *testing.B) {
+	table := newSessionTable()
+	for i := uint64(0); i < 1024; i++ {
+		table.Add(&TableRouter{Src: &Channel{cid: i}, SrcSid: i, Dst: &Channel{cid: i + 1024}, DstSid: i})
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := uint64(0)
+		for pb.Next() {
+			if table.Find(i%1024, i%1024) == nil {
+				panic("not found")
+			}
+			i++
+		}
+	})
+}
+
+//BenchmarkStringTable is the benchmark of legacy string keyed table for comparing
+func BenchmarkStringTable(b *testing.B) {
+	table := map[string]*TableRouter{}
+	tableLck := sync.RWMutex{}
+	for i := uint64(0); i < 1024; i++ {
+		router := &TableRouter{Src: &Channel{cid: i}, SrcSid: i, Dst: &Channel{cid: i + 1024}, DstSid: i}
+		table[fmt.Sprintf("%v-%v", router.Src.ID(), router.SrcSid)] = router
+		table[fmt.Sprintf("%v-%v", router.Dst.ID(), router.DstSid)] = router
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := uint64(0)
+		for pb.Next() {
+			tableLck.RLock()
+			router := table[fmt.Sprintf("%v-%v", i%1024, i%1024)]
+			tableLck.RUnlock()
+			if router == nil {
+				panic("not found")
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkSessionTableAddRemove(b *testing.B) {
+	table := newSessionTable()
+	src, dst := &Channel{cid: 1}, &Channel{cid: 2}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sid := uint64(i)
+		table.Add(&TableRouter{Src: src, SrcSid: sid, Dst: dst, DstSid: sid})
+		table.Remove(1, sid)
+	}
+}
+
+type cancelDialHandler struct {
+	canceled chan uint64
+}
+
+func (c *cancelDialHandler) DialRaw(sid uint64, uri string) (raw Conn, err error) {
+	canceled := <-c.canceled
+	err = fmt.Errorf("dial(%v) is canceled", canceled)
+	return
+}
+
+func (c *cancelDialHandler) OnConnDialURI(channel Conn, conn string, parts []string) (err error) {
+	return
+}
+
+func (c *cancelDialHandler) OnConnLogin(channel Conn, args string) (name string, index int, result xmap.M, err error) {
+	return
+}
+
+func (c *cancelDialHandler) OnConnClose(raw Conn) error {
+	return nil
+}
+
+func (c *cancelDialHandler) CancelDial(sid uint64) {
+	c.canceled <- sid
+}
+
+func TestCancelDial(t *testing.T) {
+	router := NewRouter("test")
+	handler := &cancelDialHandler{canceled: make(chan uint64, 10)}
+	router.Handler = handler
+	conna, connb, _ := xio.Pipe()
+	channel := &Channel{ReadWriteCloser: frame.NewReadWriteCloser(conna, 1024), cid: 1, name: "n", context: xmap.M{}}
+	remote := frame.NewReadWriteCloser(connb, 1024)
+	go router.procRawDial(channel, 10, "conn", "tcp://127.0.0.1:80", &dialSource{Router: "n"})
+	time.Sleep(10 * time.Millisecond)
+	//cancel by session closed
+	closed := make([]byte, 13)
+	closed[4] = CmdClosed
+	binary.BigEndian.PutUint64(closed[5:], 10)
+	router.procClosed(channel, closed)
+	back, err := remote.ReadFrame()
+	if err != nil || back[4] != CmdDialBack || !strings.Contains(string(back[13:]), "canceled") {
+		t.Errorf("%v,%v", err, string(back))
+		return
+	}
+	//cancel by channel closed
+	go router.procRawDial(channel, 11, "conn", "tcp://127.0.0.1:80", &dialSource{Router: "n"})
+	time.Sleep(10 * time.Millisecond)
+	router.closeSessions(channel, fmt.Errorf("closed"))
+	back, err = remote.ReadFrame()
+	if err != nil || back[4] != CmdDialBack || !strings.Contains(string(back[13:]), "canceled") {
+		t.Errorf("%v,%v", err, string(back))
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	if len(router.dialing) != 0 {
+		t.Errorf("%v", router.dialing)
+		return
+	}
+	//not running
+	router.cancelDial(1, 12)
+	router.cancelDial(2)
+	if len(handler.canceled) != 0 {
+		t.Error("error")
+		return
+	}
+}
+
+func TestDialSource(t *testing.T) {
+	conna, connb, _ := xio.Pipe()
+	capable := &Channel{ReadWriteCloser: frame.NewReadWriteCloser(conna, 1024), cid: 1, name: "hub", context: xmap.M{}, capabilities: map[string]bool{CapDialSource: true}}
+	older := &Channel{cid: 2, name: "hub", context: xmap.M{}}
+	remote := frame.NewReadWriteCloser(connb, 1024)
+	source := &dialSource{Router: "caller", Client: "10.0.0.1"}
+	go writeDial(capable, 1, "hub->node@tcp://xx", source)
+	buf, err := remote.ReadFrame()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	message := string(buf[13:])
+	forwarders := []string{"hub"}
+	//forwarded by previous router on path
+	conn, parsed := readDialSource(capable, message, forwarders)
+	if conn != "hub->node@tcp://xx" || parsed.Router != "caller" || parsed.Client != "10.0.0.1" || parsed.Key() != source.Key() {
+		t.Errorf("%v,%v", conn, parsed)
+		return
+	}
+	//not trusted forwarder
+	if conn, parsed = readDialSource(capable, message, nil); conn != "hub->node@tcp://xx" || parsed.Router != "hub" {
+		t.Errorf("%v,%v", conn, parsed)
+		return
+	}
+	if _, parsed = readDialSource(capable, message, []string{"other"}); parsed.Router != "hub" {
+		t.Errorf("%v", parsed)
+		return
+	}
+	//first router on path
+	conn, parsed = readDialSource(capable, "node@tcp://xx\nrouter=other&client=x", forwarders)
+	if conn != "node@tcp://xx" || parsed.Router != "hub" || parsed.Client != "x" {
+		t.Errorf("%v,%v", conn, parsed)
+		return
+	}
+	//not forwarded by channel
+	if _, parsed = readDialSource(capable, "other->node@tcp://xx\nrouter=other", forwarders); parsed.Router != "hub" {
+		t.Errorf("%v", parsed)
+		return
+	}
+	//invalid
+	if conn, parsed = readDialSource(capable, "node@tcp://xx\n%zz", forwarders); conn != "node@tcp://xx" || parsed.Router != "hub" {
+		t.Errorf("%v,%v", conn, parsed)
+		return
+	}
+	if conn, parsed = readDialSource(capable, "node@tcp://xx", forwarders); conn != "node@tcp://xx" || parsed.Router != "hub" {
+		t.Errorf("%v,%v", conn, parsed)
+		return
+	}
+	//older
+	if conn, parsed = readDialSource(older, message, forwarders); conn != message || parsed.Router != "hub" {
+		t.Errorf("%v,%v", conn, parsed)
+		return
+	}
+	conna.Close()
+	connb.Close()
+}
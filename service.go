@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"regexp"
@@ -23,6 +24,7 @@ import (
 	"time"
 
 	"github.com/codingeasygo/bsck/dialer"
+	"github.com/codingeasygo/util/converter"
 	"github.com/codingeasygo/util/proxy"
 	"github.com/codingeasygo/util/xhttp"
 	"github.com/codingeasygo/util/xio"
@@ -78,21 +80,32 @@ type Web struct {
 
 //Config is struct for all configure
 type Config struct {
-	Name      string            `json:"name"`
-	Cert      string            `json:"cert"`
-	Key       string            `json:"key"`
-	Listen    string            `json:"listen"`
-	ACL       map[string]string `json:"acl"`
-	Access    [][]string        `json:"access"`
-	Console   string            `json:"console"`
-	Web       Web               `json:"web"`
-	Log       int               `json:"log"`
-	Forwards  map[string]string `json:"forwards"`
-	Channels  []xmap.M          `json:"channels"`
-	Dialer    xmap.M            `json:"dialer"`
-	Reconnect int64             `json:"reconnect"`
-	RDPDir    string            `json:"rdp_dir"`
-	VNCDir    string            `json:"vnc_dir"`
+	Name         string              `json:"name"`
+	Cert         string              `json:"cert"`
+	Key          string              `json:"key"`
+	Listen       string              `json:"listen"`
+	ACL          map[string]string   `json:"acl"`
+	Access       [][]string          `json:"access"`
+	Console      string              `json:"console"`
+	Web          Web                 `json:"web"`
+	Log          int                 `json:"log"`
+	Forwards     map[string]string   `json:"forwards"`
+	Channels     []xmap.M            `json:"channels"`
+	Strategy     map[string]string   `json:"strategy"`
+	Forwarders   []string            `json:"forwarders"`
+	Routes       map[string][]string `json:"routes"`
+	RouteMode    string              `json:"route_mode"`
+	Cooldown     int64               `json:"route_cooldown"`
+	Timeout      int64               `json:"route_timeout"`
+	Dialer       xmap.M              `json:"dialer"`
+	Reconnect    int64               `json:"reconnect"`
+	ReconnectMax int64               `json:"reconnect_max"`
+	Drain        int64               `json:"drain"`
+	E2EKey       string              `json:"e2e_key"`
+	E2EPeers     map[string]string   `json:"e2e_peers"`
+	SSHKey       *SSHKey             `json:"ssh_key"`
+	RDPDir       string              `json:"rdp_dir"`
+	VNCDir       string              `json:"vnc_dir"`
 }
 
 //ReadConfig will read configure from file
@@ -143,8 +156,10 @@ type Service struct {
 	Node       *Proxy
 	Console    *proxy.Server
 	Web        net.Listener
+	console    net.Listener
 	Forward    *Forward
 	Dialer     *dialer.Pool
+	Routes     *RouteGroup
 	Handler    ProxyHandler
 	Finder     ForwardFinder
 	Config     *Config
@@ -166,6 +181,7 @@ func NewService() (s *Service) {
 		alias:      map[string]string{},
 		aliasLock:  sync.RWMutex{},
 		Webs:       map[string]http.Handler{},
+		Routes:     NewRouteGroup(),
 	}
 	client := &http.Client{
 		Transport: &http.Transport{
@@ -214,6 +230,8 @@ func (s *Service) ReloadConfig() (err error) {
 		}
 	}
 	config.Forwards = newConfig.Forwards
+	config.Routes = newConfig.Routes
+	s.Routes.Reload(newConfig.Routes)
 	s.configLast = newLast
 	return
 }
@@ -394,6 +412,10 @@ func (s *Service) dialOne(uri string, raw io.ReadWriteCloser, sync bool) (sid ui
 	dialURI := uri
 	if !strings.Contains(uri, "->") && !regexp.MustCompile("^[A-Za-z0-9]*://.*$").MatchString(uri) {
 		parts := strings.SplitN(uri, "?", 2)
+		if routes, ok := s.Routes.Find(parts[0]); ok {
+			sid, err = s.dialRoutes(parts[0], routes, parts[1:], raw)
+			return
+		}
 		s.aliasLock.Lock()
 		target, ok := s.alias[parts[0]]
 		s.aliasLock.Unlock()
@@ -405,14 +427,7 @@ func (s *Service) dialOne(uri string, raw io.ReadWriteCloser, sync bool) (sid ui
 			}
 			target = router[1]
 		}
-		dialURI = target
-		if len(parts) > 1 {
-			if strings.Contains(dialURI, "?") {
-				dialURI += "&" + parts[1]
-			} else {
-				dialURI += "?" + parts[1]
-			}
-		}
+		dialURI = appendURIArgs(target, parts[1:])
 	}
 	if sync {
 		sid, err = s.Node.SyncDial(dialURI, raw)
@@ -422,6 +437,49 @@ func (s *Service) dialOne(uri string, raw io.ReadWriteCloser, sync bool) (sid ui
 	return
 }
 
+//dialRoutes will try dial all routes in group until one success, the dial back is always waited
+func (s *Service) dialRoutes(name string, routes []string, args []string, raw io.ReadWriteCloser) (sid uint64, err error) {
+	err = fmt.Errorf("route group %v is empty", name)
+	for _, route := range routes {
+		dialURI := appendURIArgs(route, args)
+		waiter := newRouteWaiter(raw)
+		begin := time.Now()
+		var conn Conn
+		sid, conn, err = s.Node.DialConn(dialURI, waiter)
+		if err == nil {
+			err = waiter.WaitTimeout(s.Routes.Timeout)
+			if err != nil {
+				//abandon the session on route, so the late dial back will be rejected
+				s.Node.closeSessions(conn, err)
+				conn.Close()
+			}
+		} else {
+			waiter.finish(2, err)
+		}
+		s.Routes.Done(route, time.Since(begin), err)
+		if err == nil {
+			DebugLog("Server(%v) dial route group %v by %v success", s.Name, name, route)
+			return
+		}
+		WarnLog("Server(%v) dial route group %v by %v fail with %v", s.Name, name, route, err)
+	}
+	if waiter, ok := raw.(ReadyWaiter); ok {
+		waiter.Ready(err, nil)
+	}
+	raw.Close()
+	return
+}
+
+func appendURIArgs(uri string, args []string) string {
+	if len(args) < 1 {
+		return uri
+	}
+	if strings.Contains(uri, "?") {
+		return uri + "&" + args[0]
+	}
+	return uri + "?" + args[0]
+}
+
 //DialRaw is router dial implemnet
 func (s *Service) DialRaw(sid uint64, uri string) (conn Conn, err error) {
 	raw, err := s.Dialer.Dial(sid, uri, nil)
@@ -431,6 +489,11 @@ func (s *Service) DialRaw(sid uint64, uri string) (conn Conn, err error) {
 	return
 }
 
+//CancelDial will cancel the raw dial which is waiting in dialer pool
+func (s *Service) CancelDial(sid uint64) {
+	s.Dialer.Cancel(sid)
+}
+
 //DialNet is net dialer to router
 func (s *Service) DialNet(network, addr string) (conn net.Conn, err error) {
 	addr = strings.TrimSuffix(addr, ":80")
@@ -483,6 +546,53 @@ func (s *Service) DialPiper(uri string, bufferSize int) (raw xio.Piper, err erro
 	return
 }
 
+//RoutesH is http handler to show route group health
+func (s *Service) RoutesH(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json;charset=utf-8")
+	fmt.Fprintf(w, "%v", converter.JSON(s.Routes.State()))
+}
+
+//StateH return the current state of node, the state of balanced dialer is appended when query having dialers or *
+func (s *Service) StateH(w http.ResponseWriter, req *http.Request) {
+	var query = xmap.M{}
+	for key := range req.URL.Query() {
+		query[key] = req.URL.Query().Get(key)
+	}
+	state := xmap.M{}
+	if s.Node != nil {
+		state = s.Node.State(query)
+	}
+	if s.Dialer != nil && (len(query.Str("dialers")) > 0 || len(query.Str("*")) > 0) {
+		state["dialers"] = s.Dialer.State(query)
+	}
+	w.Header().Add("Content-Type", "application/json;charset=utf-8")
+	fmt.Fprintf(w, "%v", converter.JSON(state))
+}
+
+//DialerH will restore the removed sub dialer of balanced dialer by /restore?id=xx&name=xx, all removed is restored when name is empty
+func (s *Service) DialerH(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json;charset=utf-8")
+	if req.URL.Path != "/restore" || s.Dialer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "%v", converter.JSON(xmap.M{"code": http.StatusNotFound, "message": "not found"}))
+		return
+	}
+	var names []string
+	for _, name := range strings.Split(req.URL.Query().Get("name"), ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	restored, err := s.Dialer.Restore(req.URL.Query().Get("id"), names...)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "%v", converter.JSON(xmap.M{"code": http.StatusNotFound, "message": err.Error()}))
+		return
+	}
+	InfoLog("Server(%v) restore dialer %v on %v", s.Name, restored, req.URL.Query().Get("id"))
+	fmt.Fprintf(w, "%v", converter.JSON(xmap.M{"code": 0, "restored": restored}))
+}
+
 //Start will start service
 func (s *Service) Start() (err error) {
 	if len(s.ConfigPath) > 0 {
@@ -511,7 +621,7 @@ func (s *Service) Start() (err error) {
 		if len(s.Config.Access) > 0 {
 			handler.DialAccess = s.Config.Access
 		}
-		handler.Dialer = DialRawF(s.DialRaw)
+		handler.Dialer = s
 		s.Handler = handler
 	}
 	s.Node = NewProxy(s.Config.Name, s.Handler)
@@ -523,12 +633,47 @@ func (s *Service) Start() (err error) {
 		s.Config.Key = filepath.Join(filepath.Dir(s.ConfigPath), s.Config.Key)
 	}
 	s.Node.Cert, s.Node.Key = s.Config.Cert, s.Config.Key
+	if len(s.Config.Strategy) > 0 {
+		s.Node.Strategy = s.Config.Strategy
+	}
+	s.Node.Forwarders = s.Config.Forwarders
+	if len(s.Config.E2EKey) > 0 {
+		s.Node.E2E, err = NewE2EKeys(s.Config.E2EKey, s.Config.E2EPeers)
+		if err != nil {
+			ErrorLog("Server(%v) load e2e keys fail with %v", s.Name, err)
+			return
+		}
+	}
+	s.Routes.Reload(s.Config.Routes)
+	if len(s.Config.RouteMode) > 0 {
+		s.Routes.Mode = s.Config.RouteMode
+	}
+	if s.Config.Cooldown > 0 {
+		s.Routes.Cooldown = time.Duration(s.Config.Cooldown) * time.Millisecond
+	}
+	if s.Config.Timeout > 0 {
+		s.Routes.Timeout = time.Duration(s.Config.Timeout) * time.Millisecond
+	}
+	s.Node.Dialer = s.DialAll
 	if s.Config.Reconnect > 0 {
 		s.Node.ReconnectDelay = time.Duration(s.Config.Reconnect) * time.Millisecond
 	}
-	s.Webs["state"] = http.HandlerFunc(s.Node.Router.StateH)
+	if s.Config.ReconnectMax > 0 {
+		s.Node.ReconnectMax = time.Duration(s.Config.ReconnectMax) * time.Millisecond
+	}
+	s.Webs["state"] = http.HandlerFunc(s.StateH)
+	s.Webs["routes"] = http.HandlerFunc(s.RoutesH)
+	s.Webs["dialer"] = http.HandlerFunc(s.DialerH)
+	if s.Config.SSHKey != nil {
+		s.Webs["ssh-key"], err = NewSSHKeyProvider(s.Name, s.Node.Router, s.Config.SSHKey)
+		if err != nil {
+			ErrorLog("Server(%v) create ssh key provider fail with %v", s.Name, err)
+			return
+		}
+	}
 	s.Dialer = dialer.NewPool(s.Config.Name)
 	s.Dialer.Webs = s.Webs
+	s.Dialer.RouterDial = s.DialNet
 	err = s.Dialer.Bootstrap(s.Config.Dialer)
 	if err != nil {
 		return
@@ -553,7 +698,10 @@ func (s *Service) Start() (err error) {
 		}
 	}
 	if len(s.Config.Console) > 0 {
-		_, err = s.Console.Start(s.Config.Console)
+		s.console, err = Listen("console", "tcp", s.Config.Console)
+		if err == nil {
+			go s.Console.ProcAccept(s.console)
+		}
 		if err != nil {
 			ErrorLog("Server(%v) start console on %v fail with %v\n", s.Name, s.Config.Console, err)
 			s.Node.Close()
@@ -571,7 +719,7 @@ func (s *Service) Start() (err error) {
 	s.Forward.WebSuffix = s.Config.Web.Suffix
 	server := &http.Server{Addr: s.Config.Web.Listen, Handler: mux}
 	if len(s.Config.Web.Listen) > 0 {
-		s.Web, err = net.Listen("tcp", s.Config.Web.Listen)
+		s.Web, err = Listen("web", "tcp", s.Config.Web.Listen)
 		if err != nil {
 			ErrorLog("Server(%v) start web on %v fail with %v\n", s.Name, s.Config.Console, err)
 			return err
@@ -580,6 +728,82 @@ func (s *Service) Start() (err error) {
 			server.Serve(s.Web)
 		}()
 	}
+	CloseInherited()
+	return
+}
+
+//Listeners will return all master/console/web/forward listener by name
+func (s *Service) Listeners() (listeners map[string]net.Listener) {
+	listeners = map[string]net.Listener{}
+	if s.Node != nil {
+		listeners = s.Node.Listeners()
+	}
+	if s.console != nil {
+		listeners["console"] = s.console
+	}
+	if s.Web != nil {
+		listeners["web"] = s.Web
+	}
+	return
+}
+
+//Upgrade will start new process by executable and args with all listeners inherited,
+//the new process will use inherited listener when Start, so Drain and Stop should be called after upgrade.
+func (s *Service) Upgrade(executable string, args ...string) (process *os.Process, err error) {
+	files, env, err := ListenerFiles(s.Listeners())
+	if err != nil {
+		ErrorLog("Server(%v) get listener files fail with %v", s.Name, err)
+		return
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	//stop reconnect before new process login, the new process will kick the channel of this process by same name and index
+	if s.Node != nil {
+		s.Node.SetDraining(true)
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), env)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	err = cmd.Start()
+	if err != nil {
+		if s.Node != nil {
+			s.Node.SetDraining(false)
+		}
+		ErrorLog("Server(%v) start upgrade process %v fail with %v", s.Name, executable, err)
+		return
+	}
+	process = cmd.Process
+	InfoLog("Server(%v) start upgrade process %v success by pid %v with %v", s.Name, executable, process.Pid, env)
+	return
+}
+
+//Drain will stop accepting new connection and wait exists session done in configured drain timeout(default 30s),
+//it will not stop service, so Stop should be called after drain.
+func (s *Service) Drain() (remain int) {
+	InfoLog("Server(%v) is draining", s.Name)
+	if s.console != nil {
+		s.console.Close()
+		s.console = nil
+	}
+	if s.Console != nil {
+		s.Console.Close()
+		s.Console = nil
+	}
+	if s.Web != nil {
+		s.Web.Close()
+		s.Web = nil
+	}
+	timeout := 30 * time.Second
+	if s.Config.Drain > 0 {
+		timeout = time.Duration(s.Config.Drain) * time.Millisecond
+	}
+	if s.Node != nil {
+		remain = s.Node.Drain(timeout)
+	}
 	return
 }
 
@@ -590,6 +814,10 @@ func (s *Service) Stop() (err error) {
 		s.Node.Close()
 		s.Node = nil
 	}
+	if s.console != nil {
+		s.console.Close()
+		s.console = nil
+	}
 	if s.Console != nil {
 		s.Console.Close()
 		s.Console = nil
@@ -598,5 +826,8 @@ func (s *Service) Stop() (err error) {
 		s.Web.Close()
 		s.Web = nil
 	}
+	if s.Dialer != nil {
+		s.Dialer.Shutdown()
+	}
 	return
 }
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -438,3 +441,212 @@ func TestState(t *testing.T) {
 	master.Stop()
 	time.Sleep(100 * time.Millisecond)
 }
+
+func TestServiceRoutes(t *testing.T) {
+	masterHandler := NewNormalAcessHandler("master", DialRawF(func(sid uint64, uri string) (conn Conn, err error) {
+		if uri == "tcp://slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		if uri != "tcp://echo" && uri != "tcp://slow" {
+			err = fmt.Errorf("not supported %v", uri)
+			return
+		}
+		conn = NewRawConn("echo", xio.NewEchoConn(), 1024, sid, uri)
+		return
+	}))
+	masterHandler.LoginAccess["caller"] = "abc"
+	masterHandler.DialAccess = [][]string{{".*", ".*"}}
+	master := NewProxy("master", masterHandler)
+	err := master.ListenMaster(":9233")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer master.Close()
+	service := NewService()
+	service.Config = &Config{
+		Name: "caller",
+		Channels: []xmap.M{
+			{"enable": 1, "remote": "localhost:9233", "token": "abc", "index": 0},
+		},
+		Forwards: map[string]string{
+			"r0~tcp://:9234": "db",
+		},
+		Routes: map[string][]string{
+			"db":    {"none->tcp://echo", "master->tcp://bad", "master->tcp://echo"},
+			"slow":  {"master->tcp://slow", "master->tcp://echo"},
+			"empty": {},
+		},
+		Timeout: 100,
+		Dialer:  xmap.M{"standard": 1},
+	}
+	err = service.Start()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer service.Stop()
+	time.Sleep(200 * time.Millisecond)
+	transfer := func(conn io.ReadWriteCloser) {
+		defer conn.Close()
+		for i := 0; i < 10; i++ {
+			data := []byte(fmt.Sprintf("data->%v", i))
+			conn.Write(data)
+			back := make([]byte, len(data))
+			err := xio.FullBuffer(conn, back, uint32(len(back)), nil)
+			if err != nil || !bytes.Equal(data, back) {
+				t.Errorf("err:%v,data:%v,back:%v", err, string(data), string(back))
+				return
+			}
+		}
+	}
+	//dial by route group
+	conna, connb, _ := xio.Pipe()
+	_, err = service.SyncDialAll("db", connb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	transfer(conna)
+	routes, _ := service.Routes.Find("db")
+	if routes[0] != "master->tcp://echo" || routes[2] != "master->tcp://bad" {
+		t.Error(routes)
+		return
+	}
+	state := service.Routes.State()["db"].(xmap.M)
+	if state["master->tcp://bad"].(xmap.M)["fail"] != int64(1) || state["master->tcp://echo"].(xmap.M)["success"] != int64(1) {
+		t.Error(converter.JSON(state))
+		return
+	}
+	//failover by timeout
+	table := func(router *Proxy) []string {
+		return router.State(xmap.M{"*": "table"})["table"].([]string)
+	}
+	time.Sleep(100 * time.Millisecond)
+	running, masterRunning := len(table(service.Node)), len(table(master))
+	conna, connb, _ = xio.Pipe()
+	_, err = service.SyncDialAll("slow", connb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//only the session on echo route is added
+	if len(table(service.Node)) != running+1 {
+		t.Error(converter.JSON(table(service.Node)))
+		return
+	}
+	transfer(conna)
+	time.Sleep(500 * time.Millisecond)
+	if len(table(service.Node)) != running || len(table(master)) != masterRunning {
+		t.Errorf("%v,%v", converter.JSON(table(service.Node)), converter.JSON(table(master)))
+		return
+	}
+	//dial by forward
+	conn, err := net.Dial("tcp", "localhost:9234")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	transfer(conn)
+	//health mode
+	service.Routes.Mode = RouteModeHealth
+	service.Routes.Cooldown = 0
+	routes, _ = service.Routes.Find("db")
+	if routes[0] != "master->tcp://echo" {
+		t.Error(routes)
+		return
+	}
+	//all fail
+	_, connb, _ = xio.Pipe()
+	_, err = service.SyncDialAll("empty", connb)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	piper := NewWaitedPiper()
+	service.Routes.Routes["bad"] = []string{"master->tcp://bad"}
+	_, err = service.DialAll("bad", piper, false)
+	if err == nil || piper.Wait() == nil {
+		t.Error(err)
+		return
+	}
+	//timeout
+	waiter := newRouteWaiter(connb)
+	if waiter.WaitTimeout(10*time.Millisecond) != ErrRouteTimeout {
+		t.Error("error")
+		return
+	}
+	waiter.Ready(nil, func(err error) {
+		if err != ErrRouteTimeout {
+			t.Error(err)
+		}
+	})
+	waiter.Close()
+	fmt.Printf("waiter:%v\n", waiter)
+}
+
+func TestServiceDialerState(t *testing.T) {
+	service := NewService()
+	service.Config = &Config{
+		Name: "test",
+		Dialer: xmap.M{
+			"dialers": []xmap.M{
+				{
+					"type":    "balance",
+					"id":      "b1",
+					"matcher": "^tcp://.*$",
+					"timeout": 100,
+					"delay":   10,
+					"dialers": []xmap.M{
+						{"type": "socks", "id": "s0", "address": "127.0.0.1:1", "fail_remove": 1},
+					},
+				},
+			},
+		},
+	}
+	err := service.Start()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer service.Stop()
+	if _, err = service.Dialer.Dial(1, "tcp://127.0.0.1:80", nil); err == nil {
+		t.Error(err)
+		return
+	}
+	request := func(handler http.Handler, uri string) (code int, result xmap.M) {
+		req, _ := http.NewRequest("GET", uri, nil)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		code = recorder.Code
+		result, _ = xmap.MapVal(recorder.Body.String())
+		return
+	}
+	_, state := request(service.Webs["state"], "http://state?dialers=1")
+	if state.Value("dialers/b1/dialers/s0/removed") != true {
+		t.Error(converter.JSON(state))
+		return
+	}
+	if _, state = request(service.Webs["state"], "http://state"); state.Value("dialers") != nil {
+		t.Error(converter.JSON(state))
+		return
+	}
+	code, result := request(service.Webs["dialer"], "http://dialer/restore?id=b1&name=s0,")
+	if code != http.StatusOK || len(result.ArrayStrDef(nil, "restored")) != 1 {
+		t.Errorf("%v,%v", code, converter.JSON(result))
+		return
+	}
+	_, state = request(service.Webs["state"], "http://state?*=*")
+	if state.Value("dialers/b1/dialers/s0/removed") != false {
+		t.Error(converter.JSON(state))
+		return
+	}
+	if code, _ = request(service.Webs["dialer"], "http://dialer/restore?id=none"); code != http.StatusNotFound {
+		t.Error(code)
+		return
+	}
+	if code, _ = request(service.Webs["dialer"], "http://dialer/xx"); code != http.StatusNotFound {
+		t.Error(code)
+		return
+	}
+}
@@ -0,0 +1,211 @@
+package bsck
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//SSHKeyEntry is struct for ssh key provider entry configure
+type SSHKeyEntry struct {
+	URI        string   `json:"uri"`        //the regexp to match ssh target uri
+	Access     []string `json:"access"`     //the regexp of channel names which is authorized
+	Key        string   `json:"key"`        //the private key file, the new key is generated when it is empty and ca is configured
+	Principals []string `json:"principals"` //the principals of certificate signed by ca
+}
+
+//SSHKey is struct for ssh key provider configure
+type SSHKey struct {
+	Keys []SSHKeyEntry `json:"keys"` //the key entries
+	CA   string        `json:"ca"`   //the ca private key file to sign short-lived certificate
+	TTL  int64         `json:"ttl"`  //the certificate ttl in milliseconds, default is 300000
+}
+
+type sshKeyEntry struct {
+	uri        *regexp.Regexp
+	access     []*regexp.Regexp
+	key        []byte
+	signer     ssh.Signer
+	principals []string
+}
+
+//SSHKeyProvider is an implementation of the http.Handler interface to provide ssh key for bs-ssh by uri argument,
+//the key is only provided to authorized channel which the request comes from,
+//and short-lived certificate is signed and appended after key when ca is configured.
+type SSHKeyProvider struct {
+	Name    string
+	Router  *Router
+	CA      ssh.Signer
+	TTL     time.Duration
+	entries []*sshKeyEntry
+}
+
+//NewSSHKeyProvider will return new SSHKeyProvider by configure
+func NewSSHKeyProvider(name string, router *Router, conf *SSHKey) (provider *SSHKeyProvider, err error) {
+	provider = &SSHKeyProvider{
+		Name:   name,
+		Router: router,
+		TTL:    5 * time.Minute,
+	}
+	if conf.TTL > 0 {
+		provider.TTL = time.Duration(conf.TTL) * time.Millisecond
+	}
+	if len(conf.CA) > 0 {
+		provider.CA, err = readSSHSigner(conf.CA)
+		if err != nil {
+			err = fmt.Errorf("read ca key fail with %v", err)
+			return
+		}
+	}
+	for _, c := range conf.Keys {
+		entry := &sshKeyEntry{principals: c.Principals}
+		entry.uri, err = regexp.Compile(c.URI)
+		if err != nil {
+			return
+		}
+		for _, access := range c.Access {
+			var matcher *regexp.Regexp
+			matcher, err = regexp.Compile(access)
+			if err != nil {
+				return
+			}
+			entry.access = append(entry.access, matcher)
+		}
+		if len(c.Key) > 0 {
+			entry.key, err = ioutil.ReadFile(c.Key)
+			if err == nil {
+				entry.signer, err = ssh.ParsePrivateKey(entry.key)
+			}
+			if err != nil {
+				err = fmt.Errorf("read key %v fail with %v", c.Key, err)
+				return
+			}
+		} else if provider.CA == nil || len(c.Principals) < 1 {
+			err = fmt.Errorf("the key or ca/principals is required on entry %v", c.URI)
+			return
+		}
+		provider.entries = append(provider.entries, entry)
+	}
+	return
+}
+
+func readSSHSigner(path string) (signer ssh.Signer, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+	return
+}
+
+//source will return the originating router name which the request comes from, it is empty when source is unknown
+func (s *SSHKeyProvider) source(req *http.Request) (name string) {
+	addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return
+	}
+	sid, err := strconv.ParseUint(addr.Network(), 10, 64)
+	if err != nil {
+		return
+	}
+	name = s.Router.SessionSource(sid)
+	return
+}
+
+func (s *sshKeyEntry) authorized(name string) bool {
+	for _, access := range s.access {
+		if access.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+//sign will return the private key and certificate signed by ca
+func (s *SSHKeyProvider) sign(entry *sshKeyEntry, name string) (key []byte, cert []byte, err error) {
+	key, signer := entry.key, entry.signer
+	if signer == nil {
+		var private *ecdsa.PrivateKey
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return
+		}
+		var der []byte
+		der, err = x509.MarshalECPrivateKey(private)
+		if err != nil {
+			return
+		}
+		key = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		signer, err = ssh.NewSignerFromKey(private)
+		if err != nil {
+			return
+		}
+	}
+	if s.CA == nil || len(entry.principals) < 1 {
+		return
+	}
+	now := time.Now()
+	certificate := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("%v@%v", name, s.Name),
+		ValidPrincipals: entry.principals,
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(s.TTL).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":              "",
+				"permit-port-forwarding":  "",
+				"permit-agent-forwarding": "",
+			},
+		},
+	}
+	err = certificate.SignCert(rand.Reader, s.CA)
+	if err == nil {
+		cert = ssh.MarshalAuthorizedKey(certificate)
+	}
+	return
+}
+
+func (s *SSHKeyProvider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	uri := req.URL.Query().Get("uri")
+	name := s.source(req)
+	var matched *sshKeyEntry
+	status := http.StatusNotFound
+	for _, entry := range s.entries {
+		if !entry.uri.MatchString(uri) {
+			continue
+		}
+		status = http.StatusForbidden
+		if len(name) > 0 && entry.authorized(name) {
+			matched = entry
+			break
+		}
+	}
+	if matched == nil {
+		WarnLog("SSHKeyProvider(%v) provide key of %v to %v fail with %v", s.Name, uri, name, http.StatusText(status))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%v", http.StatusText(status))
+		return
+	}
+	key, cert, err := s.sign(matched, name)
+	if err != nil {
+		ErrorLog("SSHKeyProvider(%v) sign key of %v to %v fail with %v", s.Name, uri, name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "%v", err)
+		return
+	}
+	InfoLog("SSHKeyProvider(%v) provide key of %v to %v, certificate:%v", s.Name, uri, name, len(cert) > 0)
+	w.Write(key)
+	w.Write(cert)
+}
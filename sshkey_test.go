@@ -0,0 +1,239 @@
+package bsck
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingeasygo/bsck/dialer"
+	"github.com/codingeasygo/util/xhttp"
+	"github.com/codingeasygo/util/xmap"
+	"golang.org/x/crypto/ssh"
+)
+
+func writeTestSSHKey(path string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func TestSSHKeyProvider(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sshkey")
+	defer os.RemoveAll(dir)
+	writeTestSSHKey(filepath.Join(dir, "ca"))
+	writeTestSSHKey(filepath.Join(dir, "id"))
+	ioutil.WriteFile(filepath.Join(dir, "invalid"), []byte("xx"), 0600)
+	//the forwarders is configured before listen, it is not changed on running router
+	newMaster := func(name, listen string, forwarders []string) (master *Proxy, provider *SSHKeyProvider, web *dialer.WebDialer, err error) {
+		master = NewProxy(name, nil)
+		master.Forwarders = forwarders
+		provider, err = NewSSHKeyProvider(name, master.Router, &SSHKey{
+			CA:  filepath.Join(dir, "ca"),
+			TTL: 60000,
+			Keys: []SSHKeyEntry{
+				{URI: "^.*tcp://host1:22$", Access: []string{"^caller$"}, Key: filepath.Join(dir, "id")},
+				{URI: "^.*tcp://host2:22$", Access: []string{"^caller$", "^master$"}, Principals: []string{"root"}},
+				{URI: "^.*tcp://host3:22$", Access: []string{"^other$"}, Key: filepath.Join(dir, "id")},
+				{URI: "^.*tcp://host5:22$", Access: []string{"^caller2$"}, Key: filepath.Join(dir, "id")},
+				{URI: "^.*tcp://host6:22$", Access: []string{"^hub$"}, Key: filepath.Join(dir, "id")},
+			},
+		})
+		if err != nil {
+			return
+		}
+		web = dialer.NewWebDialer("ssh-key", provider)
+		web.Bootstrap(nil)
+		masterHandler := NewNormalAcessHandler(name, DialRawF(func(sid uint64, uri string) (conn Conn, err error) {
+			raw, err := web.Dial(sid, uri, nil)
+			if err == nil {
+				conn = NewRawConn("web", raw, 1024, sid, uri)
+			}
+			return
+		}))
+		masterHandler.LoginAccess["caller"] = "abc"
+		masterHandler.LoginAccess["hub"] = "abc"
+		masterHandler.DialAccess = [][]string{{".*", ".*"}}
+		master.Handler = masterHandler
+		err = master.ListenMaster(listen)
+		return
+	}
+	master, provider, web, err := newMaster("master", ":9240", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer web.Shutdown()
+	defer master.Close()
+	//master2 is trusted hub to forward originating router
+	master2, _, web2, err := newMaster("master2", ":9243", []string{"hub"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer web2.Shutdown()
+	defer master2.Close()
+	caller := NewProxy("caller", NewNormalAcessHandler("caller", nil))
+	defer caller.Close()
+	_, _, err = caller.Login(xmap.M{"remote": "localhost:9240", "token": "abc", "index": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//caller2 is connected to master by hub
+	hubHandler := NewNormalAcessHandler("hub", nil)
+	hubHandler.LoginAccess["caller2"] = "abc"
+	hubHandler.DialAccess = [][]string{{".*", ".*"}}
+	hub := NewProxy("hub", hubHandler)
+	err = hub.ListenMaster(":9241")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer hub.Close()
+	_, _, err = hub.Login(xmap.M{"remote": "localhost:9240", "token": "abc", "index": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _, err = hub.Login(xmap.M{"remote": "localhost:9243", "token": "abc", "index": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	caller2 := NewProxy("caller2", NewNormalAcessHandler("caller2", nil))
+	defer caller2.Close()
+	_, _, err = caller2.Login(xmap.M{"remote": "localhost:9241", "token": "abc", "index": 0})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	newClient := func(router *Proxy, uri string) *xhttp.Client {
+		return xhttp.NewClient(&http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (conn net.Conn, err error) {
+					conn, raw, _ := dialer.CreatePipedConn()
+					_, err = router.SyncDial(uri, raw)
+					return
+				},
+			},
+		})
+	}
+	client := newClient(caller, "master->http://ssh-key")
+	//key file
+	data, err := client.GetBytes("http://ssh-key?uri=%v", "master->tcp://host1:22")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key, cert := splitSSHKey(data)
+	if _, err = ssh.ParsePrivateKey(key); err != nil || len(cert) > 0 {
+		t.Errorf("%v,%v", err, string(data))
+		return
+	}
+	//signed certificate
+	data, err = client.GetBytes("http://ssh-key?uri=%v", "master->tcp://host2:22")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key, cert = splitSSHKey(data)
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey(cert)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	certificate := public.(*ssh.Certificate)
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(provider.CA.PublicKey().Marshal())
+		},
+	}
+	if err = checker.CheckCert("root", certificate); err != nil || string(certificate.Key.Marshal()) != string(signer.PublicKey().Marshal()) || certificate.KeyId != "caller@master" {
+		t.Errorf("%v,%v", err, certificate.KeyId)
+		return
+	}
+	//not authorized
+	if _, err = client.GetBytes("http://ssh-key?uri=%v", "master->tcp://host3:22"); err == nil {
+		t.Error(err)
+		return
+	}
+	//not found
+	if _, err = client.GetBytes("http://ssh-key?uri=%v", "master->tcp://host4:22"); err == nil {
+		t.Error(err)
+		return
+	}
+	//originating router spoofed by not trusted forwarder
+	client2 := newClient(caller2, "hub->master->http://ssh-key")
+	if _, err = client2.GetBytes("http://ssh-key?uri=%v", "master->tcp://host5:22"); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = client2.GetBytes("http://ssh-key?uri=%v", "master->tcp://host6:22"); err != nil {
+		t.Error(err)
+		return
+	}
+	//originating router behind trusted forwarder
+	client2 = newClient(caller2, "hub->master2->http://ssh-key")
+	if _, err = client2.GetBytes("http://ssh-key?uri=%v", "master2->tcp://host5:22"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err = client2.GetBytes("http://ssh-key?uri=%v", "master2->tcp://host6:22"); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = client2.GetBytes("http://ssh-key?uri=%v", "master2->tcp://host1:22"); err == nil {
+		t.Error(err)
+		return
+	}
+	//local
+	data, err = newClient(master, "http://ssh-key").GetBytes("http://ssh-key?uri=%v", "tcp://host2:22")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, cert = splitSSHKey(data); len(cert) < 1 {
+		t.Error(string(data))
+		return
+	}
+	if master.SessionSource(10000) != "" || provider.source(&http.Request{}) != "" {
+		t.Error("error")
+		return
+	}
+	//split
+	if key, cert = splitSSHKey([]byte("xx")); string(key) != "xx" || cert != nil {
+		t.Error("error")
+		return
+	}
+	if key, cert = splitSSHKey([]byte("-----END")); string(key) != "-----END" || cert != nil {
+		t.Error("error")
+		return
+	}
+	//configure error
+	for _, conf := range []*SSHKey{
+		{CA: filepath.Join(dir, "none")},
+		{CA: filepath.Join(dir, "invalid")},
+		{Keys: []SSHKeyEntry{{URI: "["}}},
+		{Keys: []SSHKeyEntry{{URI: ".*", Access: []string{"["}}}},
+		{Keys: []SSHKeyEntry{{URI: ".*", Key: filepath.Join(dir, "invalid")}}},
+		{Keys: []SSHKeyEntry{{URI: ".*"}}},
+	} {
+		if _, err = NewSSHKeyProvider("test", master.Router, conf); err == nil {
+			t.Error(conf)
+			return
+		}
+	}
+}
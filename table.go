@@ -0,0 +1,154 @@
+package bsck
+
+import (
+	"fmt"
+	"sync"
+)
+
+//TableRouter is the router table item
+type TableRouter struct {
+	Src    Conn   //the source connection
+	SrcSid uint64 //the session id on source
+	Dst    Conn   //the destination connection
+	DstSid uint64 //the session id on destination
+	URI    string //the dial uri
+	Source string //the originating router name of raw session dialed by remote
+}
+
+//Next will return next connection and session id
+func (t *TableRouter) Next(conn Conn) (target Conn, sid uint64) {
+	if t.Src == conn {
+		target = t.Dst
+		sid = t.DstSid
+	} else if t.Dst == conn {
+		target = t.Src
+		sid = t.SrcSid
+	}
+	return
+}
+
+func (t *TableRouter) String() string {
+	return fmt.Sprintf("%v %v <-> %v %v", t.Src, t.SrcSid, t.Dst, t.DstSid)
+}
+
+//tableShards is the shard count of session table, it must be power of 2
+const tableShards = 64
+
+type tableKey struct {
+	cid uint64
+	sid uint64
+}
+
+type tableShard struct {
+	routers map[tableKey]*TableRouter
+	lck     sync.RWMutex
+}
+
+//sessionTable is the sharded session table keyed by connection id and session id
+type sessionTable struct {
+	shards [tableShards]*tableShard
+}
+
+func newSessionTable() (table *sessionTable) {
+	table = &sessionTable{}
+	for i := range table.shards {
+		table.shards[i] = &tableShard{
+			routers: map[tableKey]*TableRouter{},
+			lck:     sync.RWMutex{},
+		}
+	}
+	return
+}
+
+func (s *sessionTable) index(key tableKey) int {
+	return int((key.cid*0x9E3779B97F4A7C15 ^ key.sid) & (tableShards - 1))
+}
+
+//lockPair will lock the shards of two key by order
+func (s *sessionTable) lockPair(a, b tableKey) {
+	ia, ib := s.index(a), s.index(b)
+	if ia > ib {
+		ia, ib = ib, ia
+	}
+	s.shards[ia].lck.Lock()
+	if ia != ib {
+		s.shards[ib].lck.Lock()
+	}
+}
+
+//unlockPair will unlock the shards of two key which is locked by lockPair
+func (s *sessionTable) unlockPair(a, b tableKey) {
+	ia, ib := s.index(a), s.index(b)
+	if ia != ib {
+		s.shards[ib].lck.Unlock()
+	}
+	s.shards[ia].lck.Unlock()
+}
+
+//Find will return the router by connection id and session id
+func (s *sessionTable) Find(cid, sid uint64) (router *TableRouter) {
+	key := tableKey{cid: cid, sid: sid}
+	shard := s.shards[s.index(key)]
+	shard.lck.RLock()
+	router = shard.routers[key]
+	shard.lck.RUnlock()
+	return
+}
+
+//Add will add router on both source and destination key
+func (s *sessionTable) Add(router *TableRouter) {
+	src := tableKey{cid: router.Src.ID(), sid: router.SrcSid}
+	dst := tableKey{cid: router.Dst.ID(), sid: router.DstSid}
+	s.lockPair(src, dst)
+	s.shards[s.index(src)].routers[src] = router
+	s.shards[s.index(dst)].routers[dst] = router
+	s.unlockPair(src, dst)
+}
+
+//Remove will remove router on both source and destination key by one key, return nil if not exists
+func (s *sessionTable) Remove(cid, sid uint64) (router *TableRouter) {
+	router = s.Find(cid, sid)
+	if router == nil {
+		return
+	}
+	src := tableKey{cid: router.Src.ID(), sid: router.SrcSid}
+	dst := tableKey{cid: router.Dst.ID(), sid: router.DstSid}
+	s.lockPair(src, dst)
+	srcShard, dstShard := s.shards[s.index(src)], s.shards[s.index(dst)]
+	if srcShard.routers[src] != router && dstShard.routers[dst] != router {
+		//removed by other
+		s.unlockPair(src, dst)
+		router = nil
+		return
+	}
+	if srcShard.routers[src] == router {
+		delete(srcShard.routers, src)
+	}
+	if dstShard.routers[dst] == router {
+		delete(dstShard.routers, dst)
+	}
+	s.unlockPair(src, dst)
+	return
+}
+
+//Range will call f on all unique router until f return false
+func (s *sessionTable) Range(f func(router *TableRouter) bool) {
+	added := map[*TableRouter]bool{}
+	all := []*TableRouter{}
+	for _, shard := range s.shards {
+		shard.lck.RLock()
+		for _, router := range shard.routers {
+			if added[router] {
+				continue
+			}
+			added[router] = true
+			all = append(all, router)
+		}
+		shard.lck.RUnlock()
+	}
+	for _, router := range all {
+		if !f(router) {
+			break
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package bsck
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingeasygo/util/xio/frame"
+)
+
+const (
+	//prioControl is the class of control frame, it is always written first
+	prioControl = iota
+	//prioHigh is the class of interactive session frame
+	prioHigh
+	//prioNormal is the class of normal data frame
+	prioNormal
+	//prioBulk is the class of bulk transfer session frame
+	prioBulk
+	prioClasses
+)
+
+//parsePriority will return the priority class by name of high/normal/bulk
+func parsePriority(name string) (class int, ok bool) {
+	switch name {
+	case "high":
+		class, ok = prioHigh, true
+	case "normal":
+		class, ok = prioNormal, true
+	case "bulk":
+		class, ok = prioBulk, true
+	}
+	return
+}
+
+//uriArgs will return the arguments on the last part of uri, it is empty when arguments is not found or invalid
+func uriArgs(uri string) (args url.Values) {
+	args = url.Values{}
+	parts := strings.Split(uri, "->")
+	last := parts[len(parts)-1]
+	index := strings.Index(last, "?")
+	if index < 0 {
+		return
+	}
+	if parsed, err := url.ParseQuery(last[index+1:]); err == nil {
+		args = parsed
+	}
+	return
+}
+
+//uriStrip will remove the arguments by keys on the last part of uri, it is used to remove router arguments before dial
+func uriStrip(uri string, keys ...string) string {
+	parts := strings.Split(uri, "->")
+	last := parts[len(parts)-1]
+	index := strings.Index(last, "?")
+	if index < 0 {
+		return uri
+	}
+	args, err := url.ParseQuery(last[index+1:])
+	if err != nil {
+		return uri
+	}
+	stripped := false
+	for _, key := range keys {
+		if _, ok := args[key]; ok {
+			args.Del(key)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return uri
+	}
+	last = last[:index]
+	if query := args.Encode(); len(query) > 0 {
+		last += "?" + query
+	}
+	parts[len(parts)-1] = last
+	return strings.Join(parts, "->")
+}
+
+//uriPriority will return the priority class by prio argument on the last part of uri,
+//it is normal when prio argument is not found or invalid
+func uriPriority(uri string) (class int) {
+	class = prioNormal
+	if c, ok := parsePriority(uriArgs(uri).Get("prio")); ok {
+		class = c
+	}
+	return
+}
+
+const (
+	channelMaxPending   = 1 << 20
+	channelMaxBatch     = 64 * 1024
+	channelFlushTimeout = time.Second
+)
+
+//ErrWriterClosed is the error when channel writer is closed
+var ErrWriterClosed = fmt.Errorf("%v", "writer is closed")
+
+type channelFrame struct {
+	buf   []byte
+	sid   uint64
+	class int
+}
+
+//channelWriter is the per channel writer which queue frames and coalesce them to one write,
+//the control frame is written before data frame when the session have no data frame in queue,
+//the data frame is written by session priority class and lower class is guaranteed one frame in each batch.
+type channelWriter struct {
+	writer     frame.Writer
+	raw        *frame.BaseWriter
+	queues     [prioClasses][]channelFrame
+	pending    [prioClasses]int
+	maxPending int
+	sessions   map[uint64]int
+	priority   map[uint64]int
+	closed     bool
+	err        error
+	buffers    net.Buffers
+	cond       *sync.Cond
+	lck        sync.Mutex
+	done       chan int
+}
+
+func newChannelWriter(writer frame.Writer) (w *channelWriter) {
+	w = &channelWriter{
+		writer:     writer,
+		raw:        baseWriter(writer),
+		maxPending: channelMaxPending,
+		sessions:   map[uint64]int{},
+		priority:   map[uint64]int{},
+		lck:        sync.Mutex{},
+		done:       make(chan int),
+	}
+	w.cond = sync.NewCond(&w.lck)
+	go w.loopWrite()
+	return
+}
+
+//baseWriter will return the frame.BaseWriter of connection to write coalesced frames directly
+func baseWriter(writer interface{}) *frame.BaseWriter {
+	for writer != nil {
+		switch w := writer.(type) {
+		case *InfoRWC:
+			writer = w.ReadWriteCloser
+		case *FrameConn:
+			return w.BaseWriter
+		case *frame.BaseReadWriteCloser:
+			return w.BaseWriter
+		case *frame.BaseWriter:
+			return w
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isControl(cmd byte) bool {
+	return cmd == CmdDialBack || cmd == CmdClosed || cmd == CmdHeartbeat
+}
+
+//WriteFrame will copy frame to queue, it will be blocked when too many data frame is pending
+func (w *channelWriter) WriteFrame(buffer []byte) (n int, err error) {
+	if len(buffer) < 13 {
+		err = fmt.Errorf("error frame")
+		return
+	}
+	sid := binary.BigEndian.Uint64(buffer[5:])
+	w.lck.Lock()
+	defer w.lck.Unlock()
+	class, ok := w.priority[sid]
+	if !ok {
+		class = prioNormal
+	}
+	if isControl(buffer[4]) && w.sessions[sid] < 1 {
+		class = prioControl
+	}
+	for class != prioControl && w.pending[class] > w.maxPending && !w.closed {
+		w.cond.Wait()
+	}
+	if w.closed {
+		err = w.err
+		if err == nil {
+			err = ErrWriterClosed
+		}
+		return
+	}
+	buf := Buffers.Get(len(buffer))
+	copy(buf, buffer)
+	w.queues[class] = append(w.queues[class], channelFrame{buf: buf, sid: sid, class: class})
+	if class != prioControl {
+		w.pending[class] += len(buf)
+		w.sessions[sid]++
+	}
+	w.cond.Broadcast()
+	n = len(buffer)
+	return
+}
+
+func (w *channelWriter) emptyNoLock() bool {
+	for _, queue := range w.queues {
+		if len(queue) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *channelWriter) popNoLock(batch []channelFrame) []channelFrame {
+	size := 0
+	for class := range w.queues {
+		queue := w.queues[class]
+		taken := 0
+		for taken < len(queue) && (size < channelMaxBatch || (class != prioControl && taken < 1)) {
+			f := queue[taken]
+			batch = append(batch, f)
+			size += len(f.buf)
+			taken++
+			if f.class != prioControl {
+				w.pending[f.class] -= len(f.buf)
+				if w.sessions[f.sid]--; w.sessions[f.sid] < 1 {
+					delete(w.sessions, f.sid)
+				}
+			}
+		}
+		w.queues[class] = append(queue[:0], queue[taken:]...)
+	}
+	return batch
+}
+
+func (w *channelWriter) writeBatch(batch []channelFrame) (err error) {
+	if w.raw == nil {
+		for _, f := range batch {
+			_, err = w.writer.WriteFrame(f.buf)
+			if err != nil {
+				break
+			}
+		}
+		return
+	}
+	buffers := w.buffers[:0]
+	for _, f := range batch {
+		binary.BigEndian.PutUint32(f.buf, uint32(len(f.buf)))
+		f.buf[0] = byte(rand.Intn(255))
+		buffers = append(buffers, f.buf)
+	}
+	w.buffers = buffers
+	if conn, ok := w.raw.Raw.(writeDeadlinable); w.raw.Timeout > 0 && ok {
+		conn.SetWriteDeadline(time.Now().Add(w.raw.Timeout))
+	}
+	_, err = buffers.WriteTo(w.raw.Raw)
+	for i := range w.buffers {
+		w.buffers[i] = nil
+	}
+	return
+}
+
+func (w *channelWriter) loopWrite() {
+	defer close(w.done)
+	batch := []channelFrame{}
+	for {
+		w.lck.Lock()
+		for w.emptyNoLock() && !w.closed {
+			w.cond.Wait()
+		}
+		if w.emptyNoLock() {
+			w.lck.Unlock()
+			break
+		}
+		batch = w.popNoLock(batch[:0])
+		w.cond.Broadcast()
+		w.lck.Unlock()
+		err := w.writeBatch(batch)
+		for _, f := range batch {
+			Buffers.Put(f.buf)
+		}
+		if err != nil {
+			w.lck.Lock()
+			w.closed, w.err = true, err
+			for class, queue := range w.queues {
+				for _, f := range queue {
+					Buffers.Put(f.buf)
+				}
+				w.queues[class] = nil
+			}
+			w.cond.Broadcast()
+			w.lck.Unlock()
+			break
+		}
+	}
+}
+
+//SetPriority will set the priority class of session, the normal class is used when class is not set
+func (w *channelWriter) SetPriority(sid uint64, class int) {
+	w.lck.Lock()
+	if class == prioNormal {
+		delete(w.priority, sid)
+	} else {
+		w.priority[sid] = class
+	}
+	w.lck.Unlock()
+}
+
+//Close will stop accept new frame and wait pending frame flushed in timeout
+func (w *channelWriter) Close() (err error) {
+	w.lck.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.lck.Unlock()
+	select {
+	case <-w.done:
+	case <-time.After(channelFlushTimeout):
+		err = fmt.Errorf("flush timeout")
+	}
+	return
+}
@@ -0,0 +1,344 @@
+package bsck
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xio/frame"
+)
+
+type blockFrameWriter struct {
+	frame.Writer
+	frames [][]byte
+	block  chan int
+	fail   error
+	lck    sync.Mutex
+}
+
+func newBlockFrameWriter() *blockFrameWriter {
+	return &blockFrameWriter{block: make(chan int, 100), lck: sync.Mutex{}}
+}
+
+func (b *blockFrameWriter) WriteFrame(buffer []byte) (n int, err error) {
+	<-b.block
+	if b.fail != nil {
+		err = b.fail
+		return
+	}
+	b.lck.Lock()
+	b.frames = append(b.frames, append([]byte{}, buffer...))
+	b.lck.Unlock()
+	n = len(buffer)
+	return
+}
+
+func (b *blockFrameWriter) Frames() (frames [][]byte) {
+	b.lck.Lock()
+	frames = append(frames, b.frames...)
+	b.lck.Unlock()
+	return
+}
+
+func (b *blockFrameWriter) String() string {
+	return "block"
+}
+
+func frameCmd(buffer []byte) string {
+	return fmt.Sprintf("%v:%v", buffer[4], binary.BigEndian.Uint64(buffer[5:]))
+}
+
+func TestChannelWriterOrder(t *testing.T) {
+	raw := newBlockFrameWriter()
+	writer := &Channel{writer: newChannelWriter(raw)}
+	writeCmd(writer, nil, CmdData, 1, []byte("1"))
+	time.Sleep(10 * time.Millisecond) //wait writer blocked on first frame
+	writeCmd(writer, nil, CmdData, 1, []byte("2"))
+	writeCmd(writer, nil, CmdData, 2, []byte("3"))
+	writeCmd(writer, nil, CmdClosed, 1, []byte("closed"))
+	writeCmd(writer, nil, CmdHeartbeat, 0, []byte("ping"))
+	writeCmd(writer, nil, CmdDialBack, 3, []byte("OK"))
+	writeCmd(writer, nil, CmdClosed, 4, []byte("closed"))
+	for i := 0; i < 7; i++ {
+		raw.block <- 1
+	}
+	writer.writer.Close()
+	frames := raw.Frames()
+	expect := []string{
+		fmt.Sprintf("%v:1", CmdData),
+		fmt.Sprintf("%v:0", CmdHeartbeat),
+		fmt.Sprintf("%v:3", CmdDialBack),
+		fmt.Sprintf("%v:4", CmdClosed),
+		fmt.Sprintf("%v:1", CmdData),
+		fmt.Sprintf("%v:2", CmdData),
+		fmt.Sprintf("%v:1", CmdClosed),
+	}
+	if len(frames) != len(expect) {
+		t.Errorf("%v", len(frames))
+		return
+	}
+	for i, f := range frames {
+		if frameCmd(f) != expect[i] {
+			t.Errorf("%v: %v!=%v", i, frameCmd(f), expect[i])
+		}
+	}
+	//closed
+	err := writeCmd(writer, nil, CmdData, 1, []byte("1"))
+	if err != ErrWriterClosed {
+		t.Error(err)
+		return
+	}
+	_, err = writer.WriteFrame([]byte("xx"))
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestChannelWriterBackpressure(t *testing.T) {
+	raw := newBlockFrameWriter()
+	writer := &Channel{writer: newChannelWriter(raw)}
+	writer.writer.maxPending = 10
+	writeCmd(writer, nil, CmdData, 1, []byte("1"))
+	time.Sleep(10 * time.Millisecond) //wait writer blocked on first frame
+	writeCmd(writer, nil, CmdData, 1, []byte("12345"))
+	written := make(chan int, 1)
+	go func() {
+		writeCmd(writer, nil, CmdData, 1, []byte("12345"))
+		written <- 1
+	}()
+	select {
+	case <-written:
+		t.Error("not blocked")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+	//control frame is not blocked
+	writeCmd(writer, nil, CmdHeartbeat, 0, []byte("ping"))
+	raw.block <- 1
+	<-written
+	for i := 0; i < 3; i++ {
+		raw.block <- 1
+	}
+	writer.writer.Close()
+	if len(raw.Frames()) != 4 {
+		t.Errorf("%v", len(raw.Frames()))
+		return
+	}
+	//write error
+	raw = newBlockFrameWriter()
+	raw.fail = fmt.Errorf("mock error")
+	raw.block <- 1
+	writer = &Channel{writer: newChannelWriter(raw)}
+	writeCmd(writer, nil, CmdData, 1, []byte("1"))
+	time.Sleep(10 * time.Millisecond)
+	err := writeCmd(writer, nil, CmdData, 1, []byte("1"))
+	if err != raw.fail {
+		t.Error(err)
+		return
+	}
+	writer.writer.Close()
+	//flush timeout
+	raw = newBlockFrameWriter()
+	writer = &Channel{writer: newChannelWriter(raw)}
+	writeCmd(writer, nil, CmdData, 1, []byte("1"))
+	if writer.writer.Close() == nil {
+		t.Error("error")
+		return
+	}
+	raw.block <- 1
+}
+
+func TestChannelWriterCoalesce(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		writer := &Channel{writer: newChannelWriter(NewInfoRWC(frame.NewReadWriteCloser(conn, 1024), "test"))}
+		for i := 0; i < 100; i++ {
+			writeCmd(writer, nil, CmdData, uint64(i), []byte(fmt.Sprintf("data-%v", i)))
+		}
+		writer.writer.Close()
+		conn.Close()
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	reader := frame.NewReadWriteCloser(conn, 1024)
+	for i := 0; i < 100; i++ {
+		data, err := reader.ReadFrame()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sid := binary.BigEndian.Uint64(data[5:])
+		if data[4] != CmdData || sid != uint64(i) || string(data[13:]) != fmt.Sprintf("data-%v", i) {
+			t.Errorf("%v,%v,%v", data[4], sid, string(data[13:]))
+			return
+		}
+	}
+	if baseWriter(nil) != nil || baseWriter("xx") != nil || baseWriter(frame.NewBaseWriter(conn)) == nil || baseWriter(NewFrameConn(conn, 64)) == nil {
+		t.Error("error")
+		return
+	}
+}
+
+func BenchmarkChannelWriter(b *testing.B) {
+	msg := make([]byte, 512)
+	b.Run("direct", func(b *testing.B) {
+		writer := frame.NewBaseWriter(ioutil.Discard)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			writeCmd(writer, nil, CmdData, uint64(i), msg)
+		}
+	})
+	b.Run("queued", func(b *testing.B) {
+		writer := &Channel{writer: newChannelWriter(frame.NewBaseWriter(ioutil.Discard))}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			writeCmd(writer, nil, CmdData, uint64(i), msg)
+		}
+		writer.writer.Close()
+	})
+}
+
+func TestChannelWriterPriority(t *testing.T) {
+	raw := newBlockFrameWriter()
+	writer := &Channel{writer: newChannelWriter(raw)}
+	writer.writer.SetPriority(1, prioBulk)
+	writer.writer.SetPriority(2, prioHigh)
+	writer.writer.SetPriority(3, prioBulk)
+	writer.writer.SetPriority(3, prioNormal)
+	writeCmd(writer, nil, CmdData, 0, []byte("0"))
+	time.Sleep(10 * time.Millisecond) //wait writer blocked on first frame
+	writeCmd(writer, nil, CmdData, 1, []byte("1"))
+	writeCmd(writer, nil, CmdData, 3, []byte("3"))
+	writeCmd(writer, nil, CmdData, 2, []byte("2"))
+	writeCmd(writer, nil, CmdClosed, 2, []byte("closed"))
+	writeCmd(writer, nil, CmdClosed, 1, []byte("closed"))
+	for i := 0; i < 6; i++ {
+		raw.block <- 1
+	}
+	writer.writer.Close()
+	frames := raw.Frames()
+	expect := []string{
+		fmt.Sprintf("%v:0", CmdData),
+		fmt.Sprintf("%v:2", CmdData),
+		fmt.Sprintf("%v:2", CmdClosed),
+		fmt.Sprintf("%v:3", CmdData),
+		fmt.Sprintf("%v:1", CmdData),
+		fmt.Sprintf("%v:1", CmdClosed),
+	}
+	if len(frames) != len(expect) {
+		t.Errorf("%v", len(frames))
+		return
+	}
+	for i, f := range frames {
+		if frameCmd(f) != expect[i] {
+			t.Errorf("%v: %v!=%v", i, frameCmd(f), expect[i])
+		}
+	}
+	//not starved
+	writer.writer = &channelWriter{sessions: map[uint64]int{}}
+	for i := 0; i < 10; i++ {
+		writer.writer.queues[prioHigh] = append(writer.writer.queues[prioHigh], channelFrame{buf: make([]byte, 32*1024), sid: 2, class: prioHigh})
+	}
+	writer.writer.queues[prioBulk] = append(writer.writer.queues[prioBulk], channelFrame{buf: make([]byte, 32*1024), sid: 1, class: prioBulk})
+	batch := writer.writer.popNoLock(nil)
+	if len(batch) != 3 || batch[2].class != prioBulk {
+		t.Errorf("%v", len(batch))
+		return
+	}
+}
+
+func TestURIPriority(t *testing.T) {
+	for uri, class := range map[string]int{
+		"tcp://127.0.0.1:22":                        prioNormal,
+		"tcp://127.0.0.1:22?prio=high":              prioHigh,
+		"node1->tcp://127.0.0.1:22?prio=bulk":       prioBulk,
+		"node1?prio=high->tcp://127.0.0.1:22":       prioNormal,
+		"node1->tcp://127.0.0.1:22?prio=normal":     prioNormal,
+		"node1->tcp://127.0.0.1:22?prio=xxx":        prioNormal,
+		"node1->tcp://127.0.0.1:22?prio=%zz":        prioNormal,
+		"x@node1->node2->tcp://127.0.0.1?prio=high": prioHigh,
+	} {
+		if uriPriority(uri) != class {
+			t.Errorf("%v,%v", uri, uriPriority(uri))
+		}
+	}
+}
+
+func TestRouterPriority(t *testing.T) {
+	router := NewRouter("test")
+	raw := newBlockFrameWriter()
+	channel := &Channel{cid: 100, writer: newChannelWriter(raw)}
+	conn := NewRawConn("a", xio.NewEchoConn(), 1024, 1, "a")
+	router.addTable(channel, 10, conn, 1, "node1->tcp://127.0.0.1:22?prio=high")
+	if channel.writer.priority[10] != prioHigh {
+		t.Error("error")
+		return
+	}
+	router.removeTable(channel, 10)
+	if _, ok := channel.writer.priority[10]; ok {
+		t.Error("error")
+		return
+	}
+	channel.writer.Close()
+}
+
+func TestURIStrip(t *testing.T) {
+	for uri, expect := range map[string]string{
+		"tcp://xx":                     "tcp://xx",
+		"tcp://xx?e2e=1":               "tcp://xx",
+		"tcp://xx?e2e=1&prio=high&a=1": "tcp://xx?a=1",
+		"tcp://xx?a=1":                 "tcp://xx?a=1",
+		"x->tcp://xx?e2e=1":            "x->tcp://xx",
+		"tcp://xx?%zz":                 "tcp://xx?%zz",
+	} {
+		if v := uriStrip(uri, "e2e", "prio"); v != expect {
+			t.Errorf("%v->%v", uri, v)
+		}
+	}
+}
+
+func TestRouterPriorityBond(t *testing.T) {
+	router := NewRouter("test")
+	channel1 := &Channel{cid: 101, name: "x", index: 1, stripe: true, writer: newChannelWriter(newBlockFrameWriter())}
+	channel2 := &Channel{cid: 102, name: "x", index: 2, stripe: true, writer: newChannelWriter(newBlockFrameWriter())}
+	router.addChannel(channel1)
+	bond := router.channel["x"]
+	conn := NewRawConn("a", xio.NewEchoConn(), 1024, 1, "a")
+	router.addTable(bond.conn, 10, conn, 1, "node1->tcp://127.0.0.1:22?prio=bulk")
+	//channel added later
+	router.addChannel(channel2)
+	if channel1.writer.priority[10] != prioBulk || channel2.writer.priority[10] != prioBulk {
+		t.Error("error")
+		return
+	}
+	router.removeTable(bond.conn, 10)
+	if _, ok := channel1.writer.priority[10]; ok {
+		t.Error("error")
+		return
+	}
+	if _, ok := channel2.writer.priority[10]; ok || len(bond.priority) > 0 {
+		t.Error("error")
+		return
+	}
+	channel1.writer.Close()
+	channel2.writer.Close()
+}